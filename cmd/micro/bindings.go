@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// An Action is something the user can bind a key to
+// It returns whether the view should be relocated to show the cursor afterwards
+type Action func(v *View) bool
+
+// actions maps the name of every action to its function
+var actions map[string]Action
+
+func init() {
+	// This is set in init because ToggleHelp shows the help screen, which lists the actions
+	actions = map[string]Action{
+		"CursorUp":       (*View).CursorUp,
+		"CursorDown":     (*View).CursorDown,
+		"CursorLeft":     (*View).CursorLeft,
+		"CursorRight":    (*View).CursorRight,
+		"InsertEnter":    (*View).InsertEnter,
+		"InsertSpace":    (*View).InsertSpace,
+		"InsertTab":      (*View).InsertTab,
+		"Backspace":      (*View).Backspace,
+		"Save":           func(v *View) bool { v.Save(); return true },
+		"Find":           (*View).Find,
+		"FindNext":       (*View).FindNext,
+		"FindPrevious":   (*View).FindPrevious,
+		"Undo":           (*View).Undo,
+		"Redo":           (*View).Redo,
+		"Copy":           func(v *View) bool { v.Copy(); v.UpdateLines(v.topline, v.topline+v.height); return true },
+		"Cut":            func(v *View) bool { v.Cut(); v.UpdateLines(v.topline, v.topline+v.height); return true },
+		"Paste":          func(v *View) bool { v.Paste(); v.UpdateLines(v.topline, v.topline+v.height); return true },
+		"SelectAll":      func(v *View) bool { v.SelectAll(); return true },
+		"OpenFile":       func(v *View) bool { v.OpenFile(); v.UpdateLines(v.topline, v.topline+v.height); return true },
+		"FindFile":       (*View).FindFile,
+		"GotoDefinition": (*View).GotoDefinition,
+		"Hover":          (*View).Hover,
+		"Autocomplete":   (*View).Autocomplete,
+		"NextHunk":       (*View).NextHunk,
+		"PreviousHunk":   (*View).PreviousHunk,
+		"RevertHunk":     (*View).RevertHunk,
+		"DiffGet":        (*View).DiffGet,
+		"DiffPut":        (*View).DiffPut,
+		"Start":          (*View).Start,
+		"End":            (*View).End,
+		"PageUp":         func(v *View) bool { v.PageUp(); return false },
+		"PageDown":       func(v *View) bool { v.PageDown(); return false },
+		"HalfPageUp":     func(v *View) bool { v.HalfPageUp(); return false },
+		"HalfPageDown":   func(v *View) bool { v.HalfPageDown(); return false },
+		"Quit":           func(v *View) bool { v.Quit(); return false },
+		"NextSplit":      func(v *View) bool { CurTab().NextView(); return false },
+		"NewTab":         func(v *View) bool { AddTab(NewBuffer("", "")); return false },
+		"PreviousTab":    func(v *View) bool { SetCurTab((curTab + len(tabs) - 1) % len(tabs)); return false },
+		"NextTab":        func(v *View) bool { SetCurTab((curTab + 1) % len(tabs)); return false },
+		"CommandMode":    (*View).CommandMode,
+		"ToggleHelp":     (*View).ToggleHelp,
+
+		"SpawnMultiCursor":      (*View).SpawnMultiCursor,
+		"RemoveAllMultiCursors": (*View).RemoveAllMultiCursors,
+		"Escape":                (*View).Escape,
+		"BlockSelection":        (*View).BlockSelection,
+	}
+}
+
+// actionHelp describes the actions in the help screen, in the order they are listed
+var actionHelp = [][2]string{
+	{"Quit", "Quit (closes the current split if there are several)"},
+	{"Save", "Save"},
+	{"OpenFile", "Open file"},
+	{"FindFile", "Find a file to open by typing parts of its path"},
+	{"", ""},
+	{"Undo", "Undo"},
+	{"Redo", "Redo"},
+	{"", ""},
+	{"Find", "Find"},
+	{"FindNext", "Find next"},
+	{"FindPrevious", "Find previous"},
+	{"", ""},
+	{"SelectAll", "Select all"},
+	{"", ""},
+	{"GotoDefinition", "Go to the definition of the symbol under the cursor"},
+	{"Hover", "Show the type and documentation of the symbol under the cursor"},
+	{"Autocomplete", "Complete the word before the cursor"},
+	{"", ""},
+	{"NextHunk", "Go to the next change from git's HEAD, or from the other side of a diff"},
+	{"PreviousHunk", "Go to the previous change from git's HEAD, or from the other side of a diff"},
+	{"RevertHunk", "Put back the lines of git's HEAD in the change under the cursor"},
+	{"DiffGet", "Replace the change under the cursor with the other side of the diff"},
+	{"DiffPut", "Replace the other side of the diff with the change under the cursor"},
+	{"", ""},
+	{"SpawnMultiCursor", "Add a cursor at the next occurrence of the selection (Alt-click also adds a cursor)"},
+	{"Escape", "Remove the extra cursors, the block selection and the highlighting of the search"},
+	{"BlockSelection", "Start or end a block selection (Alt-drag also selects a block)"},
+	{"", ""},
+	{"Copy", "Copy"},
+	{"Cut", "Cut"},
+	{"Paste", "Paste"},
+	{"", ""},
+	{"ToggleHelp", "Open this help screen"},
+	{"", ""},
+	{"HalfPageUp", "Half page up"},
+	{"HalfPageDown", "Half page down"},
+	{"PageUp", "Page up"},
+	{"PageDown", "Page down"},
+	{"", ""},
+	{"Start", "Go to beginning"},
+	{"End", "Go to end"},
+	{"", ""},
+	{"CommandMode", "Execute a command"},
+	{"", ""},
+	{"NextSplit", "Move to the next split"},
+	{"", ""},
+	{"NewTab", "Open a new tab"},
+	{"PreviousTab", "Previous tab"},
+	{"NextTab", "Next tab"},
+}
+
+// bindings maps keys to the names of actions
+var bindings map[Key]string
+
+// DefaultBindings returns the default key bindings, as key names and action names
+func DefaultBindings() map[string]string {
+	return map[string]string{
+		"Up":         "CursorUp",
+		"Down":       "CursorDown",
+		"Left":       "CursorLeft",
+		"Right":      "CursorRight",
+		"Enter":      "InsertEnter",
+		"Space":      "InsertSpace",
+		"Tab":        "InsertTab",
+		"Backspace":  "Backspace",
+		"Backspace2": "Backspace",
+		"Ctrl-S":     "Save",
+		"Ctrl-F":     "Find",
+		"Ctrl-N":     "FindNext",
+		"Ctrl-P":     "FindPrevious",
+		"Ctrl-Z":     "Undo",
+		"Ctrl-Y":     "Redo",
+		"Ctrl-C":     "Copy",
+		"Ctrl-X":     "Cut",
+		"Ctrl-V":     "Paste",
+		"Ctrl-A":     "SelectAll",
+		"Ctrl-O":     "OpenFile",
+		"Alt-o":      "FindFile",
+		"F12":        "GotoDefinition",
+		"Alt-h":      "Hover",
+		"Ctrl-Space": "Autocomplete",
+		"Alt-Down":   "NextHunk",
+		"Alt-Up":     "PreviousHunk",
+		"Home":       "Start",
+		"End":        "End",
+		"PgUp":       "PageUp",
+		"PgDn":       "PageDown",
+		"Ctrl-U":     "HalfPageUp",
+		"Ctrl-D":     "HalfPageDown",
+		"Ctrl-Q":     "Quit",
+		"Ctrl-W":     "NextSplit",
+		"Ctrl-T":     "NewTab",
+		"Alt-,":      "PreviousTab",
+		"Alt-.":      "NextTab",
+		"Ctrl-E":     "CommandMode",
+		"Ctrl-G":     "ToggleHelp",
+		"Alt-n":      "SpawnMultiCursor",
+		"Esc":        "Escape",
+		"Alt-b":      "BlockSelection",
+	}
+}
+
+// InitBindings loads the default bindings, and then the user's bindings from
+// $(configDir)/bindings.json, which maps key names to action names like this:
+// {"Ctrl-K": "Cut", "Alt-Up": "PageUp"}
+// Binding a key to "" removes the default binding
+func InitBindings() {
+	bindings = make(map[Key]string)
+	for name, action := range DefaultBindings() {
+		BindKey(name, action)
+	}
+
+	filename := configDir + "/bindings.json"
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		TermMessage("Error reading bindings.json file: " + err.Error())
+		return
+	}
+	var parsed map[string]string
+	if err := json.Unmarshal(input, &parsed); err != nil {
+		TermMessage("Error reading bindings.json: " + err.Error())
+		return
+	}
+	for name, action := range parsed {
+		if err := BindKey(name, action); err != nil {
+			TermMessage("Error in bindings.json: " + err.Error())
+		}
+	}
+}
+
+// BindKey binds the key with the given name to the action with the given name
+// An empty action removes the binding
+func BindKey(name, action string) error {
+	k, ok := ParseKey(name)
+	if !ok {
+		return errors.New("unknown key " + name)
+	}
+	if action == "" {
+		delete(bindings, k)
+		return nil
+	}
+	if _, ok := actions[action]; !ok {
+		return errors.New("unknown action " + action)
+	}
+	bindings[k] = action
+	return nil
+}
+
+// BindingFor returns the name of the action bound to the key press, or "" if there is none
+// Special keys pressed with modifiers that aren't bound do the same as the key
+// without the modifiers, for example Shift-Up moves the cursor up
+func BindingFor(e *tcell.EventKey) string {
+	k := KeyFromEvent(e)
+	if action, ok := bindings[k]; ok {
+		return action
+	}
+	if k.keyCode != tcell.KeyRune {
+		k.modifiers = 0
+		return bindings[k]
+	}
+	return ""
+}
+
+// ActionNames returns the names of all the actions, in alphabetical order
+func ActionNames() []string {
+	var names []string
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// KeysFor returns the names of the keys bound to the action
+func KeysFor(action string) []string {
+	var keys []string
+	for k, a := range bindings {
+		if a == action {
+			keys = append(keys, k.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BindingsHelp returns the help text listing the actions and the keys bound to them
+func BindingsHelp() string {
+	var lines []string
+	for _, h := range actionHelp {
+		action, desc := h[0], h[1]
+		if action == "" {
+			lines = append(lines, "")
+			continue
+		}
+		keys := KeysFor(action)
+		if len(keys) == 0 {
+			continue
+		}
+		name := strings.Join(keys, ", ") + ":"
+		pad := 10 - Count(name)
+		if pad < 1 {
+			pad = 1
+		}
+		lines = append(lines, name+Spaces(pad)+desc)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// CursorUp moves the cursor up
+func (v *View) CursorUp() bool {
+	v.cursor.ResetSelection()
+	v.cursor.Up()
+	return true
+}
+
+// CursorDown moves the cursor down
+func (v *View) CursorDown() bool {
+	v.cursor.ResetSelection()
+	v.cursor.Down()
+	return true
+}
+
+// CursorLeft moves the cursor left
+func (v *View) CursorLeft() bool {
+	v.cursor.ResetSelection()
+	v.cursor.Left()
+	return true
+}
+
+// CursorRight moves the cursor right
+func (v *View) CursorRight() bool {
+	v.cursor.ResetSelection()
+	v.cursor.Right()
+	return true
+}
+
+// InsertEnter inserts a newline
+// With autoindent, the new line gets the indentation of the line the cursor was on,
+// and one more level if that line opens a block
+// In the results of a grep, enter jumps to the result on the line instead
+func (v *View) InsertEnter() bool {
+	if v.buf.grepResults && v.JumpToResult() {
+		return true
+	}
+
+	// Replacing the selection and indenting are undone along with the newline
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	if v.cursor.HasSelection() {
+		v.cursor.DeleteSelection()
+		v.cursor.ResetSelection()
+	}
+
+	text := "\n"
+	if settings.AutoIndent {
+		before := string([]rune(v.buf.Line(v.cursor.y))[:v.cursor.x])
+		text += GetLeadingWhitespace(before)
+		if OpensBlock(v.buf.filetype, before) {
+			text += IndentString()
+		}
+	}
+	loc := v.cursor.Loc()
+	v.buf.eh.Insert(loc, text)
+	v.cursor.SetLoc(loc + Count(text))
+	// Rehighlight the entire buffer
+	v.UpdateLines(v.topline, v.topline+v.height)
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+	return true
+}
+
+// InsertSpace inserts a space
+func (v *View) InsertSpace() bool {
+	if v.cursor.HasSelection() {
+		v.cursor.DeleteSelection()
+		v.cursor.ResetSelection()
+	}
+	v.buf.eh.Insert(v.cursor.Loc(), " ")
+	v.cursor.Right()
+	v.UpdateLines(v.cursor.y, v.cursor.y)
+	return true
+}
+
+// InsertTab inserts a tab, or spaces if tabsToSpaces is on
+func (v *View) InsertTab() bool {
+	if v.cursor.HasSelection() {
+		v.cursor.DeleteSelection()
+		v.cursor.ResetSelection()
+	}
+	if settings.TabsToSpaces {
+		v.buf.eh.Insert(v.cursor.Loc(), Spaces(settings.TabSize))
+		for i := 0; i < settings.TabSize; i++ {
+			v.cursor.Right()
+		}
+	} else {
+		v.buf.eh.Insert(v.cursor.Loc(), "\t")
+		v.cursor.Right()
+	}
+	v.UpdateLines(v.cursor.y, v.cursor.y)
+	return true
+}
+
+// Backspace deletes the selection, or the character before the cursor
+// With tabsToSpaces, backspace in the indentation of a line deletes back to the
+// previous level of indentation
+func (v *View) Backspace() bool {
+	before := string([]rune(v.buf.Line(v.cursor.y))[:v.cursor.x])
+	if v.cursor.HasSelection() {
+		v.cursor.DeleteSelection()
+		v.cursor.ResetSelection()
+		// Rehighlight the entire buffer
+		v.UpdateLines(v.topline, v.topline+v.height)
+	} else if settings.TabsToSpaces && settings.TabSize > 0 && before != "" && strings.TrimLeft(before, " ") == "" {
+		n := v.cursor.x % settings.TabSize
+		if n == 0 {
+			n = settings.TabSize
+		}
+		loc := v.cursor.Loc()
+		v.buf.eh.Remove(loc-n, loc)
+		v.cursor.x -= n
+		v.UpdateLines(v.cursor.y, v.cursor.y)
+	} else if v.cursor.Loc() > 0 {
+		// We have to do something a bit hacky here because we want to
+		// delete the line by first moving left and then deleting backwards
+		// but the undo redo would place the cursor in the wrong place
+		// So instead we move left, save the position, move back, delete
+		// and restore the position
+		v.cursor.Left()
+		cx, cy := v.cursor.x, v.cursor.y
+		v.cursor.Right()
+		loc := v.cursor.Loc()
+		v.buf.eh.Remove(loc-1, loc)
+		v.cursor.x, v.cursor.y = cx, cy
+		// Rehighlight the entire buffer
+		v.UpdateLines(v.topline, v.topline+v.height)
+	}
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+	return true
+}
+
+// Find starts a search from the cursor
+func (v *View) Find() bool {
+	if v.cursor.HasSelection() {
+		searchStart = v.cursor.curSelection[1]
+	} else {
+		searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
+	}
+	BeginSearch()
+	return true
+}
+
+// FindNext searches for the next match of the last search
+func (v *View) FindNext() bool {
+	if v.cursor.HasSelection() {
+		searchStart = v.cursor.curSelection[1]
+	} else {
+		searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
+	}
+	messenger.Message(SearchPrompt() + lastSearch)
+	if err := Search(lastSearch, v, true); err != nil {
+		messenger.Error("Invalid regex: " + err.Error())
+	}
+	return true
+}
+
+// FindPrevious searches for the previous match of the last search
+func (v *View) FindPrevious() bool {
+	if v.cursor.HasSelection() {
+		searchStart = v.cursor.curSelection[0]
+	} else {
+		searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
+	}
+	messenger.Message(SearchPrompt() + lastSearch)
+	if err := Search(lastSearch, v, false); err != nil {
+		messenger.Error("Invalid regex: " + err.Error())
+	}
+	return true
+}
+
+// Undo undoes the last change
+// The extra cursors are removed, because they don't move with the text which is undone
+func (v *View) Undo() bool {
+	v.cursors = nil
+	v.buf.eh.Undo()
+	// Rehighlight the entire buffer
+	v.UpdateLines(v.topline, v.topline+v.height)
+	return true
+}
+
+// Redo redoes the last undone change
+// The extra cursors are removed, like with undo
+func (v *View) Redo() bool {
+	v.cursors = nil
+	v.buf.eh.Redo()
+	// Rehighlight the entire buffer
+	v.UpdateLines(v.topline, v.topline+v.height)
+	return true
+}
+
+// Start scrolls to the start of the buffer
+func (v *View) Start() bool {
+	v.topline, v.topRow = 0, 0
+	return false
+}
+
+// End scrolls to the end of the buffer
+func (v *View) End() bool {
+	if settings.Softwrap {
+		v.topline, v.topRow = v.maxScroll()
+	} else if v.height > v.buf.NumLines() {
+		v.topline = 0
+	} else {
+		v.topline = v.buf.NumLines() - v.height
+	}
+	return false
+}
+
+// Escape removes the extra cursors and the block selection, and stops
+// highlighting the matches of the last search
+func (v *View) Escape() bool {
+	v.cursors = nil
+	v.blockMode = false
+	searchHighlight = false
+	return true
+}
+
+// CommandMode prompts the user for a command and runs it
+func (v *View) CommandMode() bool {
+	input, canceled := messenger.Prompt("> ", "Command", CommandCompletion)
+	if !canceled {
+		HandleCommand(input, v)
+	}
+	return false
+}
+
+// ToggleHelp shows the help screen
+func (v *View) ToggleHelp() bool {
+	DisplayHelp()
+	// Make sure to resize the views if the user resized the terminal while looking at the help text
+	ResizeTabs()
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBindings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-bindings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	json := `{"Ctrl-K": "Cut", "Alt-Up": "PageUp", "Ctrl-Y": ""}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "bindings.json"), []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configDir = dir
+	InitBindings()
+
+	// Every default binding is valid
+	for name, action := range DefaultBindings() {
+		if _, ok := ParseKey(name); !ok {
+			t.Errorf("Invalid default key %q", name)
+		}
+		if _, ok := actions[action]; !ok {
+			t.Errorf("Invalid default action %q", action)
+		}
+	}
+
+	tests := []struct {
+		e    *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyCtrlK, 0, tcell.ModCtrl), "Cut"},
+		{tcell.NewEventKey(tcell.KeyCtrlY, 0, tcell.ModNone), ""},
+		{tcell.NewEventKey(tcell.KeyCtrlS, 0, tcell.ModCtrl), "Save"},
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModAlt), "PageUp"},
+		// Modifiers fall back to the plain key
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModShift), "CursorUp"},
+		{tcell.NewEventKey(tcell.KeyRune, '.', tcell.ModAlt), "NextTab"},
+		{tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), ""},
+	}
+	for _, test := range tests {
+		if got := BindingFor(test.e); got != test.want {
+			t.Errorf("BindingFor(%s) = %q, want %q", test.e.Name(), got, test.want)
+		}
+	}
+
+	if err := BindKey("Ctrl-K", "NotAnAction"); err == nil {
+		t.Error("BindKey accepted an unknown action")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"github.com/atotto/clipboard"
+	"github.com/gdamore/tcell"
+	"strings"
+)
+
+// A block selection is a rectangle of text, which is selected with Alt-drag
+// or with the BlockSelection action and the arrow keys
+// One corner is v.blockAnchor and the other one is the main cursor
+// The corners are in visual columns, so that the rectangle stays straight
+// across lines with tabs
+// The cursor's lastVisualX is used for its column, because that is where the
+// user wants the corner to be when the cursor is on a shorter line
+
+// blockClipboard is the text of the last block which was copied
+// If the clipboard still holds it when the user pastes, it is pasted as a block
+var blockClipboard string
+
+// blockKeepActions are the actions which don't end the block selection
+var blockKeepActions = map[string]bool{
+	"CursorUp":       true,
+	"CursorDown":     true,
+	"CursorLeft":     true,
+	"CursorRight":    true,
+	"PageUp":         true,
+	"PageDown":       true,
+	"HalfPageUp":     true,
+	"HalfPageDown":   true,
+	"Start":          true,
+	"End":            true,
+	"Save":           true,
+	"ToggleHelp":     true,
+	"BlockSelection": true,
+}
+
+// BlockSelection starts a block selection at the cursor, or ends the one
+// which is in progress
+func (v *View) BlockSelection() bool {
+	if v.blockMode {
+		v.blockMode = false
+		return true
+	}
+	v.cursor.ResetSelection()
+	v.cursors = nil
+	v.blockMode = true
+	v.blockAnchor = [2]int{v.cursor.lastVisualX, v.cursor.y}
+	return true
+}
+
+// BlockRect returns the block selection: the lines y1 to y2, and the visual
+// columns from x1 up to (but not including) x2
+func (v *View) BlockRect() (x1, y1, x2, y2 int) {
+	ax, ay := v.blockAnchor[0], v.blockAnchor[1]
+	cx, cy := v.cursor.lastVisualX, v.cursor.y
+	return Min(ax, cx), Min(ay, cy), Max(ax, cx), Max(ay, cy)
+}
+
+// InBlock returns whether visual column x of line y is in the block selection
+func (v *View) InBlock(x, y int) bool {
+	if !v.blockMode {
+		return false
+	}
+	x1, y1, x2, y2 := v.BlockRect()
+	return y >= y1 && y <= y2 && x >= x1 && x < x2
+}
+
+// visualCol returns the visual column of character x of line y
+func (v *View) visualCol(y, x int) int {
+	runes := []rune(v.buf.Line(y))
+	return x + NumOccurences(string(runes[:x]), '\t')*(settings.TabSize-1)
+}
+
+// setBlockCol moves both sides of the block selection to visual column x,
+// which makes it an empty column where typing inserts on every line
+func (v *View) setBlockCol(x int) {
+	v.blockAnchor[0] = x
+	v.cursor.x = v.cursor.GetCharPosInLine(v.cursor.y, x)
+	v.cursor.lastVisualX = x
+}
+
+// BlockText returns the text in the block selection, with the part of
+// each line on its own line
+func (v *View) BlockText() string {
+	x1, y1, x2, y2 := v.BlockRect()
+	var lines []string
+	for y := y1; y <= y2; y++ {
+		start := v.cursor.GetCharPosInLine(y, x1)
+		end := v.cursor.GetCharPosInLine(y, x2)
+		lines = append(lines, string([]rune(v.buf.Line(y))[start:end]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DeleteBlock deletes the text in the block selection
+func (v *View) DeleteBlock() {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	x1, y1, x2, y2 := v.BlockRect()
+	// Go up from the bottom, so that the edits don't move the lines which are left
+	for y := y2; y >= y1; y-- {
+		start := v.cursor.GetCharPosInLine(y, x1)
+		end := v.cursor.GetCharPosInLine(y, x2)
+		if end > start {
+			v.buf.eh.Remove(ToCharPos(start, y, v.buf), ToCharPos(end, y, v.buf))
+		}
+	}
+	v.setBlockCol(x1)
+}
+
+// BlockInsert replaces the block selection with text on every line
+// Afterwards the block is an empty column after the inserted text, so that
+// typing carries on inserting on every line
+func (v *View) BlockInsert(text string) {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	x1, y1, x2, y2 := v.BlockRect()
+	if x2 > x1 {
+		v.DeleteBlock()
+	}
+	for y := y1; y <= y2; y++ {
+		x := v.cursor.GetCharPosInLine(y, x1)
+		v.buf.eh.Insert(ToCharPos(x, y, v.buf), text)
+	}
+	v.setBlockCol(x1 + Count(text) + NumOccurences(text, '\t')*(settings.TabSize-1))
+	v.UpdateLines(y1, y2)
+}
+
+// BlockBackspace deletes the block selection, or the character before it on
+// every line if it is an empty column
+func (v *View) BlockBackspace() {
+	x1, y1, x2, y2 := v.BlockRect()
+	if x2 > x1 {
+		v.DeleteBlock()
+		return
+	}
+	if x1 == 0 {
+		return
+	}
+
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	// The new column is where the deleted character started on the cursor's line
+	newX := x1 - 1
+	for y := y1; y <= y2; y++ {
+		if v.visualLength(y) < x1 {
+			// The line doesn't reach the column
+			continue
+		}
+		x := v.cursor.GetCharPosInLine(y, x1)
+		if x == 0 {
+			continue
+		}
+		if y == v.cursor.y {
+			newX = v.visualCol(y, x-1)
+		}
+		loc := ToCharPos(x, y, v.buf)
+		v.buf.eh.Remove(loc-1, loc)
+	}
+	v.setBlockCol(newX)
+	v.UpdateLines(y1, y2)
+}
+
+// PasteBlock inserts the lines of text below each other, starting at visual
+// column x of line y
+// Lines which are too short are padded with spaces, and lines are added to
+// the end of the buffer if the block goes past it
+func (v *View) PasteBlock(text string, x, y int) {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	for i, line := range strings.Split(text, "\n") {
+		if y+i >= v.buf.NumLines() {
+			v.buf.eh.Insert(v.buf.Len(), "\n")
+		}
+		if pad := x - v.visualLength(y+i); pad > 0 {
+			line = Spaces(pad) + line
+		}
+		pos := v.cursor.GetCharPosInLine(y+i, x)
+		v.buf.eh.Insert(ToCharPos(pos, y+i, v.buf), line)
+	}
+	v.UpdateLines(v.topline, v.topline+v.height)
+}
+
+// CopyBlock copies the block selection to the clipboard
+func (v *View) CopyBlock() {
+	if clipboard.Unsupported {
+		messenger.Error("Clipboard is not supported on your system")
+		return
+	}
+	blockClipboard = v.BlockText()
+	clipboard.WriteAll(blockClipboard)
+}
+
+// HandleBlockKey handles a key press while there is a block selection
+// It returns true if the key was handled, otherwise the key does what it
+// normally does, and the block selection ends unless the action is in blockKeepActions
+func (v *View) HandleBlockKey(e *tcell.EventKey, action string) bool {
+	switch action {
+	case "Copy":
+		v.CopyBlock()
+		return true
+	case "Cut":
+		v.CopyBlock()
+		if !clipboard.Unsupported {
+			v.DeleteBlock()
+		}
+		return true
+	case "Paste":
+		if clipboard.Unsupported {
+			messenger.Error("Clipboard is not supported on your system")
+			return true
+		}
+		clip, _ := clipboard.ReadAll()
+		if clip != blockClipboard {
+			v.BlockInsert(clip)
+			return true
+		}
+		v.buf.eh.BeginGroup()
+		v.DeleteBlock()
+		x1, y1, _, _ := v.BlockRect()
+		v.PasteBlock(clip, x1, y1)
+		v.buf.eh.EndGroup()
+		v.blockMode = false
+		return true
+	case "Backspace":
+		v.BlockBackspace()
+		return true
+	case "InsertSpace":
+		v.BlockInsert(" ")
+		return true
+	case "InsertTab":
+		v.BlockInsert(IndentString())
+		return true
+	case "":
+		if e.Key() == tcell.KeyRune {
+			v.BlockInsert(string(e.Rune()))
+			return true
+		}
+	}
+
+	if !blockKeepActions[action] {
+		v.blockMode = false
+	}
+	return false
+}
+
+// dragBlock makes a block selection from where the mouse was pressed with Alt
+// to x, y
+func (v *View) dragBlock(x, y int) {
+	if !v.blockMode {
+		v.cursors = nil
+		v.cursor.ResetSelection()
+		v.MoveToMouseClick(v.altClick[0], v.altClick[1])
+		v.blockMode = true
+		v.blockAnchor = [2]int{v.clickCol(v.altClick[0]), v.cursor.y}
+	}
+	v.MoveToMouseClick(x, y)
+	v.cursor.lastVisualX = v.clickCol(x)
+}
+
+// clickCol returns the visual column of a click at x, after MoveToMouseClick
+// The corner of a block can be past the end of the line, so this is not the
+// column of the cursor
+// With softwrap the columns of the rows aren't the columns of the line, so the
+// corner stays at the cursor
+func (v *View) clickCol(x int) int {
+	if settings.Softwrap {
+		return v.cursor.lastVisualX
+	}
+	return Max(x, 0)
+}
+
+// releaseAlt finishes a mouse press with Alt
+// If the mouse didn't move it was a click, which adds a cursor there,
+// otherwise it was a drag which selects a block
+func (v *View) releaseAlt(x, y int) {
+	if !v.blockMode && x == v.altClick[0] && y == v.altClick[1] {
+		v.cursors = append(v.cursors, v.cursor)
+		v.MoveToMouseClick(x, y)
+		v.cursor.ResetSelection()
+		v.MergeCursors()
+	} else {
+		v.dragBlock(x, y)
+	}
+	v.altPressed = false
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBlockSelection(t *testing.T) {
+	settings = DefaultSettings()
+	eh := newTestEventHandler("abcdef\n\tx\nab\nabcdef")
+	v := eh.v
+
+	// Select columns 1 to 5 of every line
+	// The tab takes up columns 0 to 3, so it is in the block even though it starts before it
+	v.cursor.x, v.cursor.y, v.cursor.lastVisualX = 1, 0, 1
+	v.BlockSelection()
+	v.cursor.x, v.cursor.y, v.cursor.lastVisualX = 5, 3, 5
+	if got := v.BlockText(); got != "bcde\n\tx\nb\nbcde" {
+		t.Errorf("BlockText gave %q", got)
+	}
+	if !v.InBlock(4, 1) || v.InBlock(5, 1) || v.InBlock(0, 2) {
+		t.Error("InBlock is wrong")
+	}
+
+	// Typing replaces the block on every line, and then inserts after it
+	v.BlockInsert("-")
+	v.BlockInsert("+")
+	if got := v.buf.String(); got != "a-+f\n-+\na-+\na-+f" {
+		t.Errorf("Typing in the block gave %q", got)
+	}
+	// The second line is too short to reach the column now that the tab is gone
+	v.BlockBackspace()
+	if got := v.buf.String(); got != "a-f\n-+\na-\na-f" {
+		t.Errorf("Backspace in the block gave %q", got)
+	}
+
+	// All the edits to the block are undone together
+	eh.UndoGroup()
+	if got := v.buf.String(); got != "a-+f\n-+\na-+\na-+f" {
+		t.Errorf("Undo gave %q", got)
+	}
+
+	// Pasting a block pads the short lines and adds lines at the end
+	eh = newTestEventHandler("abc\na\nabc")
+	v = eh.v
+	v.PasteBlock("12\n34\n56\n78", 2, 1)
+	if got := v.buf.String(); got != "abc\na 12\nab34c\n  56\n  78" {
+		t.Errorf("PasteBlock gave %q", got)
+	}
+}
@@ -1,71 +1,152 @@
 package main
 
 import (
-	"github.com/vinzmay/go-rope"
 	"io/ioutil"
+	"os"
 	"strings"
 )
 
+// The list of open buffers
+// A buffer stays open even if it is not shown in any view, until its last view is closed
+var buffers []*Buffer
+
 // Buffer stores the text for files that are loaded into the text editor
-// It uses a rope to efficiently store the string and contains some
+// It uses a rope of lines to efficiently store the string and contains some
 // simple functions for saving and wrapper functions for modifying the rope
 type Buffer struct {
 	// Stores the text of the buffer
-	r *rope.Rope
+	r *LineRope
 
 	// Path to the file on disk
 	path string
 	// Name of the buffer on the status line
 	name string
 
-	// This is the text stored every time the buffer is saved to check if the buffer is modified
-	savedText     string
-	netInsertions int
+	// This is the hash of the text stored every time the buffer is saved to check if the buffer is modified
+	savedHash uint64
+
+	// The undo/redo history, which is shared by every view of the buffer
+	eh *EventHandler
+	// The syntax highlighting, which is also shared by every view
+	highlighter *Highlighter
 
-	// Provide efficient and easy access to text and lines so the rope String does not
-	// need to be constantly recalculated
-	// These variables are updated in the update() function
-	text  string
-	lines []string
+	// The matches of a search, which are kept until the text or the search changes
+	// searchHash is the hash of the text they were found in
+	searchMatches [][2]int
+	searchRegex   string
+	searchHash    uint64
+
+	// Whether the buffer lists the results of a grep, where enter jumps to
+	// the result under the cursor
+	grepResults bool
 
 	// Syntax highlighting rules
 	rules []SyntaxRule
 	// The buffer's filetype
 	filetype string
+
+	// The language server which is told about the edits, and the version of
+	// the text it was last told about
+	lsp        *LSPClient
+	lspVersion int
+
+	// The messages which flag lines in the gutter, by who they are from
+	messages map[string][]GutterMessage
+
+	// What git says about the file, and the hunks where the text differs from
+	// the file at HEAD, which were found in the text with the hash gitHash
+	// gitDiff is the diff running in the background, if there is one, and
+	// gitWait waits for the text to stop changing before it is started
+	git      *GitStatus
+	gitHunks []Hunk
+	gitHash  uint64
+	gitDiff  *gitDiff
+	gitWait  diffWait
 }
 
 // NewBuffer creates a new buffer from `txt` with path and name `path`
 func NewBuffer(txt, path string) *Buffer {
 	b := new(Buffer)
-	if txt == "" {
-		b.r = new(rope.Rope)
-	} else {
-		b.r = rope.New(txt)
-	}
+	b.r = NewLineRope(txt)
 	b.path = path
 	b.name = path
-	b.savedText = txt
+	b.savedHash = b.r.Hash()
+	b.eh = NewEventHandler(b)
+	b.highlighter = NewHighlighter(b)
 
-	b.Update()
 	b.UpdateRules()
 
+	// A history which can't be read is simply not restored
+	b.LoadUndo()
+
 	return b
 }
 
+// NewBufferFromFile opens the file at path in a new buffer
+// If the file does not exist yet, the buffer is empty and the file is created when it is saved
+func NewBufferFromFile(path string) (*Buffer, error) {
+	var txt []byte
+	if _, err := os.Stat(path); err == nil {
+		txt, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewBuffer(string(txt), path), nil
+}
+
+// OpenBuffer returns the open buffer for the file at path,
+// or opens the file in a new buffer if it is not open yet
+func OpenBuffer(path string) (*Buffer, error) {
+	for _, b := range buffers {
+		if path != "" && b.path == path {
+			return b, nil
+		}
+	}
+	b, err := NewBufferFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	AddBuffer(b)
+	return b, nil
+}
+
+// AddBuffer adds the buffer to the list of open buffers, if it isn't in it already
+// The plugins' onOpen hooks are run for every new buffer
+func AddBuffer(buf *Buffer) {
+	if BufferIndex(buf) == -1 {
+		buffers = append(buffers, buf)
+		RunOpenHook(buf)
+		StartLSP(buf)
+		UpdateGit(buf)
+	}
+}
+
+// CloseBuffer removes the buffer from the list of open buffers
+// Its undo history is saved so it can be restored the next time the file is opened
+func CloseBuffer(buf *Buffer) {
+	buf.SaveUndo()
+	StopLSP(buf)
+	if i := BufferIndex(buf); i != -1 {
+		buffers = append(buffers[:i], buffers[i+1:]...)
+	}
+}
+
+// BufferIndex returns the index of the buffer in the list of open buffers, or -1
+func BufferIndex(buf *Buffer) int {
+	for i, b := range buffers {
+		if b == buf {
+			return i
+		}
+	}
+	return -1
+}
+
 // UpdateRules updates the syntax rules and filetype for this buffer
 // This is called when the colorscheme changes
 func (b *Buffer) UpdateRules() {
 	b.rules, b.filetype = GetRules(b)
-}
-
-// Update fetches the string from the rope and updates the `text` and `lines` in the buffer
-func (b *Buffer) Update() {
-	if b.r.Len() == 0 {
-		b.text = ""
-	} else {
-		b.text = b.r.String()
-	}
-	b.lines = strings.Split(b.text, "\n")
+	b.highlighter.Reset()
 }
 
 // Save saves the buffer to its default path
@@ -76,45 +157,85 @@ func (b *Buffer) Save() error {
 // SaveAs saves the buffer to a specified path (filename), creating the file if it does not exist
 func (b *Buffer) SaveAs(filename string) error {
 	b.UpdateRules()
-	err := ioutil.WriteFile(filename, []byte(b.text), 0644)
+	err := ioutil.WriteFile(filename, []byte(b.String()), 0644)
 	if err == nil {
-		b.savedText = b.text
-		b.netInsertions = 0
+		b.savedHash = b.r.Hash()
+		if b.lsp != nil {
+			b.lsp.DidSave(b)
+		}
 	}
 	return err
 }
 
+// GetName returns the name of the buffer to show the user
+func (b *Buffer) GetName() string {
+	if b.name == "" {
+		return "No name"
+	}
+	return b.name
+}
+
+// CanClose returns whether or not the buffer can be closed
+// If there are unsaved changes, the user will be asked if the buffer can be closed
+// causing them to lose the unsaved changes
+// The message is what to print after saying "You have unsaved changes. "
+func (b *Buffer) CanClose(msg string) bool {
+	if b.IsDirty() {
+		prompt := "You have unsaved changes. "
+		if len(buffers) > 1 {
+			prompt = "You have unsaved changes in " + b.GetName() + ". "
+		}
+		quit, canceled := messenger.Prompt(prompt+msg, "", NoCompletion)
+		if !canceled {
+			if strings.ToLower(quit) == "yes" || strings.ToLower(quit) == "y" {
+				return true
+			}
+		}
+	} else {
+		return true
+	}
+	return false
+}
+
 // IsDirty returns whether or not the buffer has been modified compared to the one on disk
 func (b *Buffer) IsDirty() bool {
-	if b.netInsertions == 0 {
-		return b.savedText != b.text
-	}
-	return true
+	return b.r.Hash() != b.savedHash
 }
 
 // Insert a string into the rope
 func (b *Buffer) Insert(idx int, value string) {
-	b.netInsertions += len(value)
-	b.r = b.r.Insert(idx, value)
-	b.Update()
+	var pos lspPosition
+	if b.lsp != nil {
+		pos = lspPos(b, idx)
+	}
+	_, y := b.r.Locate(idx)
+	b.r.Insert(idx, value)
+	b.highlighter.Edit(y, 0, strings.Count(value, "\n"))
+	if b.lsp != nil {
+		b.lsp.DidChange(b, pos, pos, value)
+	}
 }
 
 // Remove a slice of the rope from start to end (exclusive)
 // Returns the string that was removed
 func (b *Buffer) Remove(start, end int) string {
-	b.netInsertions -= end - start
 	if start < 0 {
 		start = 0
 	}
 	if end > b.Len() {
 		end = b.Len()
 	}
-	removed := b.text[start:end]
-	// The rope implenentation I am using wants indicies starting at 1 instead of 0
-	start++
-	end++
-	b.r = b.r.Delete(start, end-start)
-	b.Update()
+	var pos1, pos2 lspPosition
+	if b.lsp != nil {
+		pos1, pos2 = lspPos(b, start), lspPos(b, end)
+	}
+	_, y1 := b.r.Locate(start)
+	_, y2 := b.r.Locate(end)
+	removed := b.r.Remove(start, end)
+	b.highlighter.Edit(y1, y2-y1, 0)
+	if b.lsp != nil {
+		b.lsp.DidChange(b, pos1, pos2, "")
+	}
 	return removed
 }
 
@@ -122,3 +243,29 @@ func (b *Buffer) Remove(start, end int) string {
 func (b *Buffer) Len() int {
 	return b.r.Len()
 }
+
+// String returns the whole text of the buffer
+// This builds the text from the rope, so it is O(n) in the size of the buffer
+func (b *Buffer) String() string {
+	return b.r.String()
+}
+
+// Substr returns the text from the character position start up to (but not including) end
+func (b *Buffer) Substr(start, end int) string {
+	return b.r.Substr(start, end)
+}
+
+// Line returns line number n of the buffer, without its newline
+func (b *Buffer) Line(n int) string {
+	return b.r.Line(n)
+}
+
+// Lines returns the lines from start up to (but not including) end
+func (b *Buffer) Lines(start, end int) []string {
+	return b.r.Lines(start, end)
+}
+
+// NumLines returns the number of lines in the buffer
+func (b *Buffer) NumLines() int {
+	return b.r.NumLines()
+}
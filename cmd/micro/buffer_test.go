@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestBufferEdits(t *testing.T) {
+	b := NewBuffer("hello\nwörld", "")
+	// A plain string of runes to check the buffer against
+	text := []rune(b.String())
+
+	r := rand.New(rand.NewSource(1))
+	pieces := []string{"a", "ü", "\n", "ab\ncd", "\n\n", "xyz"}
+	for i := 0; i < 2000; i++ {
+		if r.Intn(2) == 0 || len(text) == 0 {
+			loc := r.Intn(len(text) + 1)
+			s := pieces[r.Intn(len(pieces))]
+			b.Insert(loc, s)
+			text = append(text[:loc], append([]rune(s), text[loc:]...)...)
+		} else {
+			start := r.Intn(len(text))
+			end := start + r.Intn(len(text)-start+1)
+			removed := b.Remove(start, end)
+			if removed != string(text[start:end]) {
+				t.Fatalf("Remove(%d, %d) = %q, want %q", start, end, removed, string(text[start:end]))
+			}
+			text = append(text[:start], text[end:]...)
+		}
+
+		if b.String() != string(text) {
+			t.Fatalf("String() = %q, want %q", b.String(), string(text))
+		}
+		if b.Len() != len(text) {
+			t.Fatalf("Len() = %d, want %d", b.Len(), len(text))
+		}
+	}
+
+	lines := strings.Split(string(text), "\n")
+	if b.NumLines() != len(lines) {
+		t.Fatalf("NumLines() = %d, want %d", b.NumLines(), len(lines))
+	}
+	loc := 0
+	for y, line := range lines {
+		if b.Line(y) != line {
+			t.Errorf("Line(%d) = %q, want %q", y, b.Line(y), line)
+		}
+		for x := 0; x <= Count(line); x++ {
+			if got := ToCharPos(x, y, b); got != loc+x {
+				t.Errorf("ToCharPos(%d, %d) = %d, want %d", x, y, got, loc+x)
+			}
+			if gx, gy := FromCharPos(loc+x, b); gx != x || gy != y {
+				t.Errorf("FromCharPos(%d) = %d, %d, want %d, %d", loc+x, gx, gy, x, y)
+			}
+		}
+		loc += Count(line) + 1
+	}
+}
+
+func TestBufferDirty(t *testing.T) {
+	b := NewBuffer("abc", "")
+	if b.IsDirty() {
+		t.Error("New buffer is dirty")
+	}
+	b.Insert(1, "x")
+	if !b.IsDirty() {
+		t.Error("Buffer isn't dirty after an insert")
+	}
+	b.Remove(1, 2)
+	if b.IsDirty() {
+		t.Error("Buffer is dirty after undoing the insert by hand")
+	}
+	b.Remove(0, 1)
+	b.Insert(0, "z")
+	if !b.IsDirty() {
+		t.Error("Buffer isn't dirty after replacing a character")
+	}
+}
+
+// newBenchBuffer returns a buffer with the given number of lines
+func newBenchBuffer(lines int) *Buffer {
+	line := strings.Repeat("x", 79)
+	return NewBuffer(strings.Repeat(line+"\n", lines), "")
+}
+
+// The benchmarks run on buffers of different sizes to show that the cost of
+// an operation doesn't grow with the size of the buffer
+var benchSizes = []int{1000, 100000, 1000000}
+
+func BenchmarkBufferInsert(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			buf := newBenchBuffer(n)
+			mid := buf.Len() / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Insert(mid, "a")
+			}
+		})
+	}
+}
+
+func BenchmarkBufferInsertRemove(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			buf := newBenchBuffer(n)
+			mid := buf.Len() / 2
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Insert(mid, "a\nb")
+				buf.Remove(mid, mid+3)
+			}
+		})
+	}
+}
+
+func BenchmarkToCharPos(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			buf := newBenchBuffer(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ToCharPos(10, n-1, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkFromCharPos(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			buf := newBenchBuffer(n)
+			loc := buf.Len() - 10
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				FromCharPos(loc, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkBufferLine(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			buf := newBenchBuffer(n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf.Line(i % n)
+			}
+		})
+	}
+}
@@ -1,22 +1,85 @@
 package main
 
 import (
-	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// A Command is a function which can be run from the command prompt
+// It is given the view the command was run in, and the arguments of the command
+type Command func(view *View, args []string)
+
+// commands maps the name of every command to the function which runs it
+// Plugins can add their own commands with MakeCommand
+var commands map[string]Command
+
+// InitCommands registers the built in commands
+func InitCommands() {
+	commands = map[string]Command{
+		"set":         func(view *View, args []string) { SetOption(view, args) },
+		"quit":        func(view *View, args []string) { view.Quit() },
+		"quitall":     func(view *View, args []string) { QuitAll() },
+		"save":        func(view *View, args []string) { view.Save() },
+		"replace":     Replace,
+		"grep":        GrepCommand,
+		"grepreplace": GrepReplace,
+		"run":         RunCommand,
+		"filter":      FilterCommand,
+		"read":        ReadCommand,
+		"term":        TermCommand,
+		"termcopy":    TermCopy,
+		"rename":      RenameCommand,
+		"lint":        LintCommand,
+		"fmt":         FormatCommand,
+		"nexthunk":    func(view *View, args []string) { view.NextHunk(); view.Relocate() },
+		"prevhunk":    func(view *View, args []string) { view.PreviousHunk(); view.Relocate() },
+		"reverthunk":  func(view *View, args []string) { view.RevertHunk(); view.Relocate() },
+		"diff":        DiffCommand,
+		"diffget":     func(view *View, args []string) { view.DiffGet() },
+		"diffput":     func(view *View, args []string) { view.DiffPut() },
+		"vsplit":      func(view *View, args []string) { Split(view, args, VerticalSplit) },
+		"hsplit":      func(view *View, args []string) { Split(view, args, HorizontalSplit) },
+		"resize":      Resize,
+		"tabnew":      TabNew,
+		"bnext":       func(view *View, args []string) { view.NextBuffer(1) },
+		"bprev":       func(view *View, args []string) { view.NextBuffer(-1) },
+		"buffers":     ListBuffers,
+		"buffer":      SwitchBuffer,
+		"earlier":     func(view *View, args []string) { TimeTravel(view, args, true) },
+		"later":       func(view *View, args []string) { TimeTravel(view, args, false) },
+		"undotree":    ShowUndoTree,
+	}
+}
+
+// MakeCommand registers a new command, replacing any command with the same name
+func MakeCommand(name string, cmd Command) {
+	commands[name] = cmd
+}
+
 // HandleCommand handles input from the user
+// A command can be abbreviated, as long as only one command starts with the abbreviation
+// Input starting with ! is a shell command, see ShellBang
 func HandleCommand(input string, view *View) {
+	if strings.HasPrefix(input, "!") {
+		ShellBang(view, input[1:])
+		return
+	}
+
 	inputCmd := strings.Split(input, " ")[0]
 	args := strings.Split(input, " ")[1:]
 
-	commands := []string{"set", "quit", "save", "replace"}
-
 	i := 0
 	cmd := inputCmd
 
-	for _, c := range commands {
+	for c := range commands {
+		if c == inputCmd {
+			// An exact match wins even if other commands start with it
+			i = 1
+			cmd = c
+			break
+		}
 		if strings.HasPrefix(c, inputCmd) {
 			i++
 			cmd = c
@@ -26,72 +89,184 @@ func HandleCommand(input string, view *View) {
 		inputCmd = cmd
 	}
 
-	switch inputCmd {
-	case "set":
-		SetOption(view, args)
-	case "quit":
-		if view.CanClose("Quit anyway? ") {
-			screen.Fini()
-			os.Exit(0)
-		}
-	case "save":
-		view.Save()
-	case "replace":
-		r := regexp.MustCompile(`"[^"\\]*(?:\\.[^"\\]*)*"|[^\s]*`)
-		replaceCmd := r.FindAllString(strings.Join(args, " "), -1)
-		if len(replaceCmd) < 2 {
-			messenger.Error("Invalid replace statement: " + strings.Join(args, " "))
-			return
-		}
+	if command, ok := commands[inputCmd]; ok {
+		command(view, args)
+	} else {
+		messenger.Error("Unknown command: " + inputCmd)
+	}
+}
 
-		var flags string
-		if len(replaceCmd) == 3 {
-			// The user included some flags
-			flags = replaceCmd[2]
+// TabNew opens the given file, or an empty buffer, in a new tab
+func TabNew(view *View, args []string) {
+	buf := NewBuffer("", "")
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		buf, err = OpenBuffer(args[0])
+		if err != nil {
+			messenger.Error(err.Error())
+			return
 		}
+	}
+	AddTab(buf)
+}
 
-		search := string(replaceCmd[0])
-		replace := string(replaceCmd[1])
-
-		if strings.HasPrefix(search, `"`) && strings.HasSuffix(search, `"`) {
-			search = search[1 : len(search)-1]
+// ListBuffers lists the open buffers, marking the one in the current view
+func ListBuffers(view *View, args []string) {
+	var list []string
+	for i, b := range buffers {
+		entry := strconv.Itoa(i+1) + ":" + b.GetName()
+		if b.IsDirty() {
+			entry += " +"
 		}
-		if strings.HasPrefix(replace, `"`) && strings.HasSuffix(replace, `"`) {
-			replace = replace[1 : len(replace)-1]
+		if b == view.buf {
+			entry = "[" + entry + "]"
 		}
+		list = append(list, entry)
+	}
+	messenger.Message(strings.Join(list, "  "))
+}
 
-		search = strings.Replace(search, `\"`, `"`, -1)
-		replace = strings.Replace(replace, `\"`, `"`, -1)
-
-		// messenger.Error(search + " -> " + replace)
+// SwitchBuffer shows the buffer with the given number in the view
+func SwitchBuffer(view *View, args []string) {
+	if len(args) != 1 {
+		messenger.Error("Invalid buffer statement, please use buffer n")
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(buffers) {
+		messenger.Error("No buffer number " + args[0])
+		return
+	}
+	view.SetBuffer(buffers[n-1])
+}
 
-		regex, err := regexp.Compile(search)
+// Split splits the view, showing the given file or the current buffer in the new view
+// A file which is already open is shown in the buffer it is open in
+func Split(view *View, args []string, kind SplitType) {
+	buf := view.buf
+	if len(args) > 0 && args[0] != "" {
+		var err error
+		buf, err = OpenBuffer(args[0])
 		if err != nil {
 			messenger.Error(err.Error())
 			return
 		}
+	}
+	if kind == VerticalSplit {
+		view.VSplit(buf)
+	} else {
+		view.HSplit(buf)
+	}
+}
+
+// Resize grows or shrinks the view's split by the given percentage
+func Resize(view *View, args []string) {
+	if len(args) != 1 {
+		messenger.Error("Invalid resize statement, please use resize +n or resize -n")
+		return
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		messenger.Error("Invalid value for resize: " + args[0])
+		return
+	}
+	view.ResizeSplit(n)
+}
 
-		found := false
-		for {
-			match := regex.FindStringIndex(view.buf.text)
-			if match == nil {
-				break
-			}
-			found = true
-			if strings.Contains(flags, "c") {
-				// 	// The 'check' flag was used
-				// 	if messenger.YesNoPrompt("Perform replacement?") {
-				// 		view.eh.Replace(match[0], match[1], replace)
-				// 	} else {
-				// 		continue
-				// 	}
-			}
-			view.eh.Replace(match[0], match[1], replace)
+// ParseArgs splits the arguments of a command again, keeping the text in
+// double quotes together as one argument
+// The quotes are removed, and \" becomes "
+func ParseArgs(args []string) []string {
+	r := regexp.MustCompile(`"[^"\\]*(?:\\.[^"\\]*)*"|[^\s]+`)
+	var parsed []string
+	for _, arg := range r.FindAllString(strings.Join(args, " "), -1) {
+		if len(arg) >= 2 && strings.HasPrefix(arg, `"`) && strings.HasSuffix(arg, `"`) {
+			arg = arg[1 : len(arg)-1]
 		}
-		if !found {
-			messenger.Message("Nothing matched " + search)
+		parsed = append(parsed, strings.Replace(arg, `\"`, `"`, -1))
+	}
+	return parsed
+}
+
+// Replace replaces the matches of a regex in the buffer, or in the selection if there is one
+func Replace(view *View, args []string) {
+	replaceCmd := ParseArgs(args)
+	if len(replaceCmd) < 2 {
+		messenger.Error("Invalid replace statement: " + strings.Join(args, " "))
+		return
+	}
+
+	var flags string
+	if len(replaceCmd) == 3 {
+		// The user included some flags
+		flags = replaceCmd[2]
+	}
+
+	search := replaceCmd[0]
+	replace := replaceCmd[1]
+
+	regex, err := regexp.Compile(search)
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+
+	// Only replace in the selection if there is one
+	start, end := 0, view.buf.Len()
+	if view.cursor.HasSelection() {
+		start = Min(view.cursor.curSelection[0], view.cursor.curSelection[1])
+		end = Max(view.cursor.curSelection[0], view.cursor.curSelection[1])
+	}
+
+	replacements := FindReplacements(view.buf, regex, replace, start, end)
+	if len(replacements) == 0 {
+		messenger.Message("Nothing matched " + search)
+		return
+	}
+	// The 'c' flag asks before each replacement
+	n := ReplaceMatches(view, replacements, strings.Contains(flags, "c"))
+	view.cursor.ResetSelection()
+	view.Clamp()
+	messenger.Message("Replaced " + strconv.Itoa(n) + " of " + strconv.Itoa(len(replacements)) + " matches of " + search)
+}
+
+// TimeTravel moves back (or forward) in the undo history by a number of changes
+// or a duration like 5m
+func TimeTravel(view *View, args []string, back bool) {
+	arg := "1"
+	if len(args) > 0 && args[0] != "" {
+		arg = args[0]
+	}
+	if n, err := strconv.Atoi(arg); err == nil {
+		if back {
+			view.buf.eh.Earlier(n)
+		} else {
+			view.buf.eh.Later(n)
 		}
-	default:
-		messenger.Error("Unknown command: " + inputCmd)
+	} else if d, err := time.ParseDuration(arg); err == nil {
+		if back {
+			view.buf.eh.EarlierTime(d)
+		} else {
+			view.buf.eh.LaterTime(d)
+		}
+	} else {
+		cmd := "later"
+		if back {
+			cmd = "earlier"
+		}
+		messenger.Error("Invalid " + cmd + " statement, please use a count or a duration like 5m")
+		return
 	}
+	messenger.Message("At change " + strconv.Itoa(view.buf.eh.tree.cur.seq) + " of " + strconv.Itoa(view.buf.eh.tree.Len()))
+}
+
+// ShowUndoTree shows the branches of the undo tree in a split below the view
+func ShowUndoTree(view *View, args []string) {
+	t := view.buf.eh.tree
+	header := "Undo tree of " + view.buf.GetName() + ": " + strconv.Itoa(t.Len()) +
+		" changes, the current state is marked with *"
+	lines := append([]string{header, ""}, t.Visualize()...)
+	buf := NewBuffer(strings.Join(lines, "\n"), "")
+	buf.name = "Undo tree"
+	view.HSplit(buf)
 }
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"github.com/mitchellh/go-homedir"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// A Completion is what the words typed in a prompt are completed with
+type Completion int
+
+// The kinds of completion
+const (
+	NoCompletion Completion = iota
+	// File paths
+	FileCompletion
+	// Command names, and then the arguments of the command
+	CommandCompletion
+)
+
+// fileCommands are the commands whose arguments are files or directories
+var fileCommands = map[string]bool{
+	"tabnew":      true,
+	"vsplit":      true,
+	"hsplit":      true,
+	"grep":        true,
+	"grepreplace": true,
+	"read":        true,
+}
+
+// Complete returns the completions of the word which ends at the end of input,
+// and where that word starts in input
+// Words are separated by spaces
+func Complete(completion Completion, input string) (int, []string) {
+	start := strings.LastIndex(input, " ") + 1
+	word := input[start:]
+
+	switch completion {
+	case FileCompletion:
+		return start, CompleteFile(word)
+	case CommandCompletion:
+		args := strings.Fields(input[:start])
+		if len(args) == 0 {
+			return start, completeFrom(commandNames(), word)
+		}
+		cmd := args[0]
+		if cmd == "set" {
+			if len(args) == 1 {
+				return start, completeFrom(possibleSettings, word)
+			}
+			if len(args) == 2 {
+				return start, completeFrom(OptionValues(args[1]), word)
+			}
+		}
+		if fileCommands[cmd] {
+			return start, CompleteFile(word)
+		}
+	}
+	return start, nil
+}
+
+// completeFrom returns the words of the list which start with prefix, sorted
+func completeFrom(list []string, prefix string) []string {
+	var completions []string
+	for _, s := range list {
+		if strings.HasPrefix(s, prefix) {
+			completions = append(completions, s)
+		}
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// commandNames returns the names of the commands, including the ones which
+// were added by plugins
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	return names
+}
+
+// OptionValues returns the values an option can be set to, or nothing if its
+// values can't be listed
+func OptionValues(option string) []string {
+	switch option {
+	case "colorscheme":
+		return ColorschemeNames()
+	case "tabsize":
+		return nil
+	}
+	if Contains(possibleSettings, option) {
+		return []string{"on", "off"}
+	}
+	return nil
+}
+
+// ColorschemeNames returns the names of the colorschemes, both the ones
+// embedded in micro and the ones in $(configDir)/colorschemes
+func ColorschemeNames() []string {
+	names := append([]string{}, preInstalledColors[:]...)
+	files, _ := ioutil.ReadDir(configDir + "/colorschemes")
+	for _, f := range files {
+		if name := strings.TrimSuffix(f.Name(), ".micro"); name != f.Name() && !Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CompleteFile returns the files and directories which start with the path
+// Directories end with a slash, so that completing can carry on into them
+// Hidden files are only completed if the name starts with a dot
+func CompleteFile(path string) []string {
+	dir := path[:strings.LastIndex(path, "/")+1]
+	name := path[len(dir):]
+
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	} else if expanded, err := homedir.Expand(readDir); err == nil {
+		readDir = expanded
+	}
+	files, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+
+	var completions []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), name) || (strings.HasPrefix(f.Name(), ".") && !strings.HasPrefix(name, ".")) {
+			continue
+		}
+		completion := dir + f.Name()
+		if f.IsDir() {
+			completion += "/"
+		}
+		completions = append(completions, completion)
+	}
+	return completions
+}
+
+// CommonPrefix returns the longest prefix which all the strings start with
+func CommonPrefix(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	prefix := []rune(list[0])
+	for _, s := range list[1:] {
+		runes := []rune(s)
+		i := 0
+		for i < len(prefix) && i < len(runes) && prefix[i] == runes[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return string(prefix)
+}
+
+// Complete completes the word before the cursor in the prompt
+// If there is one completion, the word is replaced with it, otherwise it is
+// extended to the prefix that the completions share, and the completions are
+// listed above the prompt
+// Pressing tab again puts the completions in the prompt in turn
+func (m *Messenger) Complete(completion Completion) {
+	cursor := m.input.Cursor()
+
+	if len(m.suggestions) > 1 && m.completing {
+		m.suggestion = (m.suggestion + 1) % len(m.suggestions)
+		m.input.Replace(m.completionStart, cursor, m.suggestions[m.suggestion])
+		return
+	}
+
+	before := string(m.input.text[:cursor])
+	start, completions := Complete(completion, before)
+	m.suggestions, m.suggestion = nil, -1
+	if len(completions) == 0 {
+		return
+	}
+
+	// A completed command or option is followed by its arguments
+	replacement := CommonPrefix(completions)
+	if len(completions) == 1 && completion == CommandCompletion && !strings.HasSuffix(replacement, "/") {
+		replacement += " "
+	}
+	if len(completions) > 1 {
+		m.suggestions = completions
+		m.completionStart = Count(before[:start])
+		m.completing = true
+	}
+	m.input.Replace(Count(before[:start]), cursor, replacement)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	InitCommands()
+	dir, err := ioutil.TempDir("", "micro-completion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProject(t, dir, map[string]string{
+		"main.go":              "",
+		"makefile":             "",
+		".hidden":              "",
+		"micro/view.go":        "",
+		"colorschemes/a.micro": "",
+	})
+	configDir = dir
+
+	tests := []struct {
+		completion Completion
+		input      string
+		start      int
+		want       []string
+	}{
+		{CommandCompletion, "un", 0, []string{"undotree"}},
+		{CommandCompletion, "gr", 0, []string{"grep", "grepreplace"}},
+		{CommandCompletion, "set ta", 4, []string{"tabsToSpaces", "tabsize"}},
+		{CommandCompletion, "set  softwrap o", 14, []string{"off", "on"}},
+		{CommandCompletion, "set colorscheme s", 16, []string{"solarized", "solarized-tc"}},
+		{CommandCompletion, "set colorscheme ", 16, []string{"a", "default", "solarized", "solarized-tc"}},
+		{CommandCompletion, "set tabsize ", 12, nil},
+		{CommandCompletion, "tabnew " + dir + "/ma", 7, []string{dir + "/main.go", dir + "/makefile"}},
+		{CommandCompletion, "quit ", 5, nil},
+		{FileCompletion, dir + "/mi", 0, []string{dir + "/micro/"}},
+		{FileCompletion, dir + "/.h", 0, []string{dir + "/.hidden"}},
+	}
+	for _, test := range tests {
+		start, got := Complete(test.completion, test.input)
+		if start != test.start || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Completing %q gave %d, %q, want %d, %q", test.input, start, got, test.start, test.want)
+		}
+	}
+}
+
+func TestMessengerComplete(t *testing.T) {
+	InitCommands()
+	m := new(Messenger)
+
+	// A single completion is followed by a space
+	m.input.SetText("und")
+	m.Complete(CommandCompletion)
+	if m.input.Text() != "undotree " || len(m.suggestions) != 0 {
+		t.Errorf("Completing und gave %q with suggestions %q", m.input.Text(), m.suggestions)
+	}
+
+	// Several completions are extended to their common prefix, and tab again
+	// goes through them
+	m.input.SetText("set tabs")
+	m.Complete(CommandCompletion)
+	if m.input.Text() != "set tabs" || len(m.suggestions) != 2 {
+		t.Errorf("Completing set tabs gave %q with suggestions %q", m.input.Text(), m.suggestions)
+	}
+	m.Complete(CommandCompletion)
+	m.Complete(CommandCompletion)
+	if m.input.Text() != "set tabsize" || m.input.Cursor() != Count("set tabsize") {
+		t.Errorf("Going through the completions gave %q with the cursor at %d", m.input.Text(), m.input.Cursor())
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	if got := CommonPrefix([]string{"éab", "éac", "éa"}); got != "éa" {
+		t.Errorf("CommonPrefix gave %q", got)
+	}
+	if got := CommonPrefix(nil); got != "" {
+		t.Errorf("CommonPrefix of nothing gave %q", got)
+	}
+}
@@ -6,34 +6,12 @@ import (
 
 // FromCharPos converts from a character position to an x, y position
 func FromCharPos(loc int, buf *Buffer) (int, int) {
-	return FromCharPosStart(0, 0, 0, loc, buf)
-}
-
-// FromCharPosStart converts from a character position to an x, y position, starting at the specified character location
-func FromCharPosStart(startLoc, startX, startY, loc int, buf *Buffer) (int, int) {
-	charNum := startLoc
-	x, y := startX, startY
-
-	lineLen := Count(buf.lines[y]) + 1
-	for charNum+lineLen <= loc {
-		charNum += lineLen
-		y++
-		lineLen = Count(buf.lines[y]) + 1
-	}
-	x = loc - charNum
-
-	return x, y
+	return buf.r.Locate(loc)
 }
 
 // ToCharPos converts from an x, y position to a character position
 func ToCharPos(x, y int, buf *Buffer) int {
-	loc := 0
-	for i := 0; i < y; i++ {
-		// + 1 for the newline
-		loc += Count(buf.lines[i]) + 1
-	}
-	loc += x
-	return loc
+	return buf.r.LineStart(y) + x
 }
 
 // The Cursor struct stores the location of the cursor in the view
@@ -85,13 +63,19 @@ func (c *Cursor) HasSelection() bool {
 	return c.curSelection[0] != c.curSelection[1]
 }
 
+// IsSelected returns whether the character at loc is in the cursor's selection
+func (c *Cursor) IsSelected(loc int) bool {
+	sel := c.curSelection
+	return c.HasSelection() && (loc >= sel[0] && loc < sel[1] || loc < sel[0] && loc >= sel[1])
+}
+
 // DeleteSelection deletes the currently selected text
 func (c *Cursor) DeleteSelection() {
 	if c.curSelection[0] > c.curSelection[1] {
-		c.v.eh.Remove(c.curSelection[1], c.curSelection[0])
+		c.v.buf.eh.Remove(c.curSelection[1], c.curSelection[0])
 		c.SetLoc(c.curSelection[1])
 	} else {
-		c.v.eh.Remove(c.curSelection[0], c.curSelection[1])
+		c.v.buf.eh.Remove(c.curSelection[0], c.curSelection[1])
 		c.SetLoc(c.curSelection[0])
 	}
 }
@@ -99,9 +83,9 @@ func (c *Cursor) DeleteSelection() {
 // GetSelection returns the cursor's selection
 func (c *Cursor) GetSelection() string {
 	if c.curSelection[0] > c.curSelection[1] {
-		return string([]rune(c.v.buf.text)[c.curSelection[1]:c.curSelection[0]])
+		return c.v.buf.Substr(c.curSelection[1], c.curSelection[0])
 	}
-	return string([]rune(c.v.buf.text)[c.curSelection[0]:c.curSelection[1]])
+	return c.v.buf.Substr(c.curSelection[0], c.curSelection[1])
 }
 
 // SelectLine selects the current line
@@ -136,7 +120,7 @@ func (c *Cursor) AddLineToSelection() {
 
 // SelectWord selects the word the cursor is currently on
 func (c *Cursor) SelectWord() {
-	if len(c.v.buf.lines[c.y]) == 0 {
+	if len(c.v.buf.Line(c.y)) == 0 {
 		return
 	}
 
@@ -157,7 +141,7 @@ func (c *Cursor) SelectWord() {
 	c.curSelection[0] = ToCharPos(backward, c.y, c.v.buf)
 	c.origSelection[0] = c.curSelection[0]
 
-	for forward < Count(c.v.buf.lines[c.y])-1 && IsWordChar(string(c.RuneUnder(forward+1))) {
+	for forward < Count(c.v.buf.Line(c.y))-1 && IsWordChar(string(c.RuneUnder(forward+1))) {
 		forward++
 	}
 
@@ -188,7 +172,7 @@ func (c *Cursor) AddWordToSelection() {
 	if loc > c.origSelection[1] {
 		forward := c.x
 
-		for forward < Count(c.v.buf.lines[c.y])-1 && IsWordChar(string(c.RuneUnder(forward+1))) {
+		for forward < Count(c.v.buf.Line(c.y))-1 && IsWordChar(string(c.RuneUnder(forward+1))) {
 			forward++
 		}
 
@@ -199,7 +183,7 @@ func (c *Cursor) AddWordToSelection() {
 
 // RuneUnder returns the rune under the given x position
 func (c *Cursor) RuneUnder(x int) rune {
-	line := []rune(c.v.buf.lines[c.y])
+	line := []rune(c.v.buf.Line(c.y))
 	if x >= len(line) {
 		x = len(line) - 1
 	} else if x < 0 {
@@ -209,11 +193,16 @@ func (c *Cursor) RuneUnder(x int) rune {
 }
 
 // Up moves the cursor up one line (if possible)
+// With softwrap it moves up one row of the screen instead
 func (c *Cursor) Up() {
+	if settings.Softwrap {
+		c.moveRows(-1)
+		return
+	}
 	if c.y > 0 {
 		c.y--
 
-		runes := []rune(c.v.buf.lines[c.y])
+		runes := []rune(c.v.buf.Line(c.y))
 		c.x = c.GetCharPosInLine(c.y, c.lastVisualX)
 		if c.x > len(runes) {
 			c.x = len(runes)
@@ -222,11 +211,16 @@ func (c *Cursor) Up() {
 }
 
 // Down moves the cursor down one line (if possible)
+// With softwrap it moves down one row of the screen instead
 func (c *Cursor) Down() {
-	if c.y < len(c.v.buf.lines)-1 {
+	if settings.Softwrap {
+		c.moveRows(1)
+		return
+	}
+	if c.y < c.v.buf.NumLines()-1 {
 		c.y++
 
-		runes := []rune(c.v.buf.lines[c.y])
+		runes := []rune(c.v.buf.Line(c.y))
 		c.x = c.GetCharPosInLine(c.y, c.lastVisualX)
 		if c.x > len(runes) {
 			c.x = len(runes)
@@ -234,6 +228,23 @@ func (c *Cursor) Down() {
 	}
 }
 
+// moveRows moves the cursor n rows down (or up if n is negative) when the lines are wrapped
+// The cursor stays in the same column of the row, like it stays in the same column
+// of the line without softwrap
+func (c *Cursor) moveRows(n int) {
+	row := c.VisualRow()
+	y, newRow := c.v.moveRows(c.y, row, n)
+	if y == c.y && newRow == row {
+		return
+	}
+	textWidth := c.v.textWidth()
+	c.y = y
+	c.x = c.GetCharPosInLine(y, newRow*textWidth+c.lastVisualX%textWidth)
+	if c.x > Count(c.v.buf.Line(y)) {
+		c.x = Count(c.v.buf.Line(y))
+	}
+}
+
 // Left moves the cursor left one cell (if possible) or to the last line if it is at the beginning
 func (c *Cursor) Left() {
 	if c.Loc() == 0 {
@@ -253,7 +264,7 @@ func (c *Cursor) Right() {
 	if c.Loc() == c.v.buf.Len() {
 		return
 	}
-	if c.x < Count(c.v.buf.lines[c.y]) {
+	if c.x < Count(c.v.buf.Line(c.y)) {
 		c.x++
 	} else {
 		c.Down()
@@ -264,7 +275,7 @@ func (c *Cursor) Right() {
 
 // End moves the cursor to the end of the line it is on
 func (c *Cursor) End() {
-	c.x = Count(c.v.buf.lines[c.y])
+	c.x = Count(c.v.buf.Line(c.y))
 	c.lastVisualX = c.GetVisualX()
 }
 
@@ -279,7 +290,7 @@ func (c *Cursor) GetCharPosInLine(lineNum, visualPos int) int {
 	// Get the tab size
 	tabSize := settings.TabSize
 	// This is the visual line -- every \t replaced with the correct number of spaces
-	visualLine := strings.Replace(c.v.buf.lines[lineNum], "\t", "\t"+Spaces(tabSize-1), -1)
+	visualLine := strings.Replace(c.v.buf.Line(lineNum), "\t", "\t"+Spaces(tabSize-1), -1)
 	if visualPos > Count(visualLine) {
 		visualPos = Count(visualLine)
 	}
@@ -292,17 +303,45 @@ func (c *Cursor) GetCharPosInLine(lineNum, visualPos int) int {
 
 // GetVisualX returns the x value of the cursor in visual spaces
 func (c *Cursor) GetVisualX() int {
-	runes := []rune(c.v.buf.lines[c.y])
+	runes := []rune(c.v.buf.Line(c.y))
 	tabSize := settings.TabSize
 	return c.x + NumOccurences(string(runes[:c.x]), '\t')*(tabSize-1)
 }
 
+// VisualRow returns the row of its line which the cursor is on, when the line is wrapped
+// Without softwrap it is always 0
+func (c *Cursor) VisualRow() int {
+	if !settings.Softwrap {
+		return 0
+	}
+	return c.GetVisualX() / c.v.textWidth()
+}
+
+// ScreenPos returns where the cursor is on the screen, and false if it is out
+// of the viewport
+func (c *Cursor) ScreenPos() (int, int, bool) {
+	v := c.v
+	x := c.GetVisualX() + v.lineNumOffset - v.leftCol
+	y := c.y - v.topline
+	if settings.Softwrap {
+		row := c.VisualRow()
+		x = c.GetVisualX()%v.textWidth() + v.lineNumOffset
+		if rowBefore(c.y, row, v.topline, v.topRow) {
+			y = -1
+		} else {
+			y = v.rowsBetween(v.topline, v.topRow, c.y, row, v.height)
+		}
+	}
+	return v.x + x, v.y + y, y >= 0 && y <= v.height-1
+}
+
 // Display draws the cursor to the screen at the correct position
 func (c *Cursor) Display() {
+	x, y, ok := c.ScreenPos()
 	// Don't draw the cursor if it is out of the viewport or if it has a selection
-	if (c.y-c.v.topline < 0 || c.y-c.v.topline > c.v.height-1) || c.HasSelection() {
+	if !ok || c.HasSelection() {
 		screen.HideCursor()
 	} else {
-		screen.ShowCursor(c.GetVisualX()+c.v.lineNumOffset-c.v.leftCol, c.y-c.v.topline)
+		screen.ShowCursor(x, y)
 	}
 }
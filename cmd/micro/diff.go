@@ -0,0 +1,274 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Hunk is a run of lines which differ between two texts: the lines
+// [aStart, aEnd) of the first are replaced by the lines [bStart, bEnd) of the
+// second
+type Hunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// DiffLines returns the hunks which turn the lines a into the lines b, in
+// order, changing as few lines as possible
+// It uses Myers' algorithm, which is fast when the texts are alike
+func DiffLines(a, b []string) []Hunk {
+	// The lines which are the same at the start and the end are left out of
+	// the search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	n, m := len(a)-pre-suf, len(b)-pre-suf
+
+	var hunks []Hunk
+	i, j := 0, 0
+	for _, match := range append(commonLines(a[pre:pre+n], b[pre:pre+m]), [2]int{n, m}) {
+		if match[0] > i || match[1] > j {
+			hunks = append(hunks, Hunk{pre + i, pre + match[0], pre + j, pre + match[1]})
+		}
+		i, j = match[0]+1, match[1]+1
+	}
+	return hunks
+}
+
+// maxDiffCost is the most lines which a diff removes and inserts between the
+// lines the texts have in common
+// The search takes time and memory in proportion to the square of this, so
+// texts which differ more are taken to differ completely
+const maxDiffCost = 1000
+
+// commonLines returns the pairs of indexes of the lines which a and b keep in
+// common when a is turned into b with the fewest lines removed and inserted
+// If that is more than maxDiffCost lines, it returns no lines in common
+func commonLines(a, b []string) [][2]int {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+
+	// v[k+max] is the furthest x reached on the diagonal k = x - y, and trace[d]
+	// keeps the diagonals -d..d of v as they were before step d
+	max := Min(n+m, maxDiffCost)
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	x, y := 0, 0
+	found := false
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1]
+			} else {
+				x = v[max+k-1] + 1
+			}
+			y = x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	// Go back through the steps, collecting the diagonal moves, which are
+	// the lines in common
+	var matches [][2]int
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prev := trace[d]
+			k := x - y
+			prevK := k - 1
+			if k == -d || k != d && prev[k-1+d] < prev[k+1+d] {
+				prevK = k + 1
+			}
+			prevX = prev[prevK+d]
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+// diffDelay is how long a text must stay the same before it is diffed again in
+// the background, so that typing doesn't start a diff for every key
+const diffDelay = 300 * time.Millisecond
+
+// A diffJob finds hunks in the background
+// The result is handed over through the struct, and not through an interrupt,
+// so that it can't get lost
+type diffJob struct {
+	sync.Mutex
+	hunks []Hunk
+	done  bool
+}
+
+// startDiff runs diff in the background, and redraws the screen once it has
+// found the hunks
+func startDiff(diff func() []Hunk) *diffJob {
+	j := new(diffJob)
+	// The screen is taken now, since the tests change it
+	s := screen
+	go func() {
+		hunks := diff()
+		j.Lock()
+		j.hunks, j.done = hunks, true
+		j.Unlock()
+		if s != nil {
+			s.PostEvent(tcell.NewEventInterrupt(nil))
+		}
+	}()
+	return j
+}
+
+// Result returns the hunks, and whether they have been found yet
+func (j *diffJob) Result() ([]Hunk, bool) {
+	j.Lock()
+	defer j.Unlock()
+	return j.hunks, j.done
+}
+
+// A diffWait waits for a text to stop changing before it is diffed
+type diffWait struct {
+	hash  uint64
+	since time.Time
+	timer *time.Timer
+}
+
+// Ready returns whether the text with the hash has stayed the same for
+// diffDelay
+// When the text changes, the screen is redrawn once the delay is over, so
+// that the text is checked again
+func (w *diffWait) Ready(hash uint64) bool {
+	if hash != w.hash {
+		w.hash, w.since = hash, time.Now()
+		if s := screen; s != nil {
+			if w.timer == nil {
+				w.timer = time.AfterFunc(diffDelay, func() {
+					s.PostEvent(tcell.NewEventInterrupt(nil))
+				})
+			} else {
+				w.timer.Reset(diffDelay)
+			}
+		}
+		return false
+	}
+	return time.Since(w.since) >= diffDelay
+}
+
+// MapLine returns the line of the new text which line y of the old text
+// became after the hunks were applied
+// A line in a hunk goes to the line in the same place of its replacement, or
+// to the line after the hunk if it was deleted
+func MapLine(hunks []Hunk, y int) int {
+	offset := 0
+	for _, h := range hunks {
+		if y < h.aStart {
+			break
+		}
+		if y < h.aEnd {
+			if h.bEnd == h.bStart {
+				return h.bStart
+			}
+			return h.bStart + Min(y-h.aStart, h.bEnd-h.bStart-1)
+		}
+		offset = h.bEnd - h.aEnd
+	}
+	return y + offset
+}
+
+// ReplaceText changes the text of the buffer to text, replacing only the
+// lines which differ, as a single undo
+// The cursors of the buffer's views stay on the same lines of the text
+// It returns the hunks which were changed
+func ReplaceText(buf *Buffer, text string) []Hunk {
+	old := strings.Split(buf.String(), "\n")
+	lines := strings.Split(text, "\n")
+	hunks := DiffLines(old, lines)
+	if len(hunks) == 0 {
+		return nil
+	}
+
+	views := editViews(buf)
+	buf.eh.BeginGroup()
+	// Go up from the bottom, so that the lines of the other hunks stay where
+	// they are
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		var start, end int
+		var insert string
+		if h.aEnd < len(old) {
+			// Whole lines, with their newlines, are replaced
+			start, end = ToCharPos(0, h.aStart, buf), ToCharPos(0, h.aEnd, buf)
+			for _, l := range lines[h.bStart:h.bEnd] {
+				insert += l + "\n"
+			}
+		} else if h.aStart == len(old) {
+			// Lines are added after the last one
+			start, end = buf.Len(), buf.Len()
+			insert = "\n" + strings.Join(lines[h.bStart:], "\n")
+		} else if h.bStart == len(lines) {
+			// The last lines are deleted, with the newline before them
+			start, end = ToCharPos(0, h.aStart, buf)-1, buf.Len()
+		} else {
+			start, end = ToCharPos(0, h.aStart, buf), buf.Len()
+			insert = strings.Join(lines[h.bStart:], "\n")
+		}
+		if end > start {
+			buf.eh.Remove(start, end)
+		}
+		if insert != "" {
+			buf.eh.Insert(start, insert)
+		}
+	}
+	buf.eh.EndGroup()
+
+	for _, v := range views {
+		v.cursor.ResetSelection()
+		v.cursor.y = MapLine(hunks, v.cursor.y)
+		for i := range v.cursors {
+			v.cursors[i].ResetSelection()
+			v.cursors[i].y = MapLine(hunks, v.cursors[i].y)
+		}
+		v.Clamp()
+		v.cursor.lastVisualX = v.cursor.GetVisualX()
+		v.Relocate()
+		v.UpdateLines(0, v.buf.NumLines())
+	}
+	return hunks
+}
+
+// ReplaceLines replaces the lines [start, end) of the buffer with lines, like
+// ReplaceText
+func ReplaceLines(buf *Buffer, start, end int, lines []string) {
+	old := strings.Split(buf.String(), "\n")
+	text := append(append(append([]string(nil), old[:start]...), lines...), old[end:]...)
+	ReplaceText(buf, strings.Join(text, "\n"))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []Hunk
+	}{
+		{"a\nb\nc", "a\nb\nc", nil},
+		{"a\nb\nc", "a\nx\nc", []Hunk{{1, 2, 1, 2}}},
+		{"a\nb\nc", "a\nc", []Hunk{{1, 2, 1, 1}}},
+		{"a\nc", "a\nb\nc", []Hunk{{1, 1, 1, 2}}},
+		{"a\nb\nc\nd\ne", "x\nb\nc\ne\ny", []Hunk{{0, 1, 0, 1}, {3, 4, 3, 3}, {5, 5, 4, 5}}},
+		{"a\nb", "", []Hunk{{0, 2, 0, 1}}},
+		{"a\nb\na\nb", "b\na\nb\na", []Hunk{{0, 1, 0, 0}, {4, 4, 3, 4}}},
+	}
+	for _, test := range tests {
+		a, b := strings.Split(test.a, "\n"), strings.Split(test.b, "\n")
+		if got := DiffLines(a, b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("DiffLines(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDiffLinesLarge(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 6000; i++ {
+		a = append(a, "a"+strconv.Itoa(i))
+		b = append(b, "b"+strconv.Itoa(i))
+	}
+	// Texts which differ completely are one hunk, found without searching
+	// through every way to turn one into the other
+	start := time.Now()
+	if got, want := DiffLines(a, b), []Hunk{{0, 6000, 0, 6000}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffLines gave %v, want %v", got, want)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("DiffLines took %v", d)
+	}
+
+	// A few changes are still found exactly
+	c := append([]string(nil), a...)
+	c[10], c[5000] = "x", "y"
+	if got, want := DiffLines(a, c), []Hunk{{10, 11, 10, 11}, {5000, 5001, 5000, 5001}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffLines gave %v, want %v", got, want)
+	}
+}
+
+func TestMapLine(t *testing.T) {
+	// Line 1 is replaced by two lines and line 3 is deleted
+	hunks := []Hunk{{1, 2, 1, 3}, {3, 4, 4, 4}}
+	for y, want := range []int{0, 1, 3, 4, 4} {
+		if got := MapLine(hunks, y); got != want {
+			t.Errorf("Line %d went to %d, want %d", y, got, want)
+		}
+	}
+}
+
+func TestReplaceText(t *testing.T) {
+	tests := []struct{ old, new string }{
+		{"a\nb\nc\n", "a\nB\nc\n"},
+		{"a\nb\nc", "a\nb"},
+		{"a\nb", "a\nb\nc\nd"},
+		{"a\nb", "x"},
+		{"a\nb\n", "b\nc\nd\n"},
+		{"", "a\n"},
+	}
+	for _, test := range tests {
+		eh := newTestEventHandler(test.old)
+		ReplaceText(eh.buf, test.new)
+		if got := eh.buf.String(); got != test.new {
+			t.Errorf("Replacing %q with %q gave %q", test.old, test.new, got)
+		}
+		eh.Undo()
+		if got := eh.buf.String(); got != test.old {
+			t.Errorf("Undoing the replacement of %q with %q gave %q", test.old, test.new, got)
+		}
+	}
+}
+
+func TestReplaceTextCursor(t *testing.T) {
+	eh := newTestEventHandler("one\n  two\nthree\nfour\n")
+	v := eh.v
+	v.height = 10
+	tabs = []*Tab{{views: []*View{v}}}
+	defer func() { tabs = nil }()
+
+	v.cursor.x, v.cursor.y = 2, 2
+	ReplaceText(eh.buf, "zero\none\ntwo\nthree\nfour\n")
+	if v.cursor.x != 2 || v.cursor.y != 3 {
+		t.Errorf("The cursor went to %d,%d, want 2,3", v.cursor.x, v.cursor.y)
+	}
+}
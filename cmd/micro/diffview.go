@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"strings"
+)
+
+// errNoDiff is the error for a diff command in a view which isn't in a diff
+var errNoDiff = errors.New("this view is not comparing files, use diff to compare them")
+
+// A DiffView links two views which compare their buffers side by side
+// The views scroll together, and the lines which differ are highlighted
+type DiffView struct {
+	left, right *View
+
+	// The hunks which turn the left text into the right one, and the hashes of
+	// the texts they were found in
+	hunks []Hunk
+	hash  [2]uint64
+	// The diff running in the background, if there is one, the hashes of its
+	// texts, and the wait for the texts to stop changing before it is started
+	job     *diffJob
+	jobHash [2]uint64
+	wait    diffWait
+
+	// Where the views were scrolled to when they were last lined up
+	topline [2]int
+	leftCol [2]int
+}
+
+// NewDiffView links the two views in a diff
+func NewDiffView(left, right *View) *DiffView {
+	d := &DiffView{left: left, right: right}
+	left.diff, right.diff = d, d
+	d.hash = [2]uint64{left.buf.r.Hash() + 1, right.buf.r.Hash() + 1}
+	d.Hunks(true)
+	return d
+}
+
+// OpenDiff opens a new tab which compares the buffers side by side
+func OpenDiff(a, b *Buffer) {
+	AddTab(a)
+	left := CurView()
+	left.VSplit(b)
+	NewDiffView(left, CurView())
+}
+
+// DiffCommand compares the current buffer with a file (diff file), or with
+// the file it was loaded from when there is no file (diff)
+func DiffCommand(view *View, args []string) {
+	if len(args) > 1 {
+		messenger.Error("Invalid diff statement, please use diff [file]")
+		return
+	}
+	if len(args) == 1 {
+		buf, err := OpenBuffer(args[0])
+		if err != nil {
+			messenger.Error(err.Error())
+			return
+		}
+		OpenDiff(view.buf, buf)
+		return
+	}
+
+	if view.buf.path == "" {
+		messenger.Error("This buffer has not been saved, use diff file")
+		return
+	}
+	data, err := ioutil.ReadFile(view.buf.path)
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	// The saved text isn't the file, so that it can't be saved over it
+	saved := NewBuffer(string(data), "")
+	saved.name = view.buf.path + " (saved)"
+	saved.filetype, saved.rules = view.buf.filetype, view.buf.rules
+	OpenDiff(saved, view.buf)
+}
+
+// Close unlinks the views, which become normal views
+func (d *DiffView) Close() {
+	d.left.diff, d.right.diff = nil, nil
+}
+
+// other returns the view on the other side of v
+func (d *DiffView) other(v *View) *View {
+	if v == d.left {
+		return d.right
+	}
+	return d.left
+}
+
+// Hunks returns the hunks which turn the left text into the right one
+// After one of the texts changes they are found again, in the background once
+// the texts stop changing unless wait is true, and the old ones are returned
+// until then
+func (d *DiffView) Hunks(wait bool) []Hunk {
+	if d.job != nil {
+		if hunks, done := d.job.Result(); done {
+			d.hunks, d.hash, d.job = hunks, d.jobHash, nil
+		}
+	}
+	hash := [2]uint64{d.left.buf.r.Hash(), d.right.buf.r.Hash()}
+	if hash == d.hash || d.job != nil && !wait {
+		return d.hunks
+	}
+
+	if wait {
+		// A diff in the background is of older texts
+		d.job = nil
+		d.hunks = DiffLines(strings.Split(d.left.buf.String(), "\n"), strings.Split(d.right.buf.String(), "\n"))
+		d.hash = hash
+		return d.hunks
+	}
+	if !d.wait.Ready(hash[0]*31 + hash[1]) {
+		return d.hunks
+	}
+	left, right := d.left.buf.String(), d.right.buf.String()
+	d.job = startDiff(func() []Hunk {
+		return DiffLines(strings.Split(left, "\n"), strings.Split(right, "\n"))
+	})
+	d.jobHash = hash
+	return d.hunks
+}
+
+// HunksFor returns the hunks which turn the text on the other side of v into
+// the text of v, like Hunks
+func (d *DiffView) HunksFor(v *View, wait bool) []Hunk {
+	if v == d.right {
+		return d.Hunks(wait)
+	}
+	return swapHunks(d.Hunks(wait))
+}
+
+// swapHunks returns the hunks which undo the hunks
+func swapHunks(hunks []Hunk) []Hunk {
+	swapped := make([]Hunk, len(hunks))
+	for i, h := range hunks {
+		swapped[i] = Hunk{h.bStart, h.bEnd, h.aStart, h.aEnd}
+	}
+	return swapped
+}
+
+// Scroll lines the views up after one of them scrolled, so that the other one
+// shows the same part of the text
+func (d *DiffView) Scroll() {
+	views := [2]*View{d.left, d.right}
+	for i, v := range views {
+		if v.topline == d.topline[i] && v.leftCol == d.leftCol[i] {
+			continue
+		}
+		other := views[1-i]
+		other.topline = MapLine(d.HunksFor(other, false), v.topline)
+		other.topline = Max(0, Min(other.topline, other.buf.NumLines()-1))
+		other.topRow = 0
+		other.leftCol = v.leftCol
+		break
+	}
+	d.topline = [2]int{d.left.topline, d.right.topline}
+	d.leftCol = [2]int{d.left.leftCol, d.right.leftCol}
+}
+
+// Changes returns the kind of change of each line of v which differs from the
+// other side, like GitChanges, and the part of each modified line which
+// differs from the line it replaces, as a range of characters
+func (d *DiffView) Changes(v *View) (map[int]int, map[int][2]int) {
+	hunks := d.HunksFor(v, false)
+	changes := GitChanges(hunks)
+	if v == d.left {
+		// The lines which are only on the left were removed
+		for y, kind := range changes {
+			if kind == GitAdded {
+				changes[y] = GitRemoved
+			}
+		}
+	}
+
+	text := make(map[int][2]int)
+	other := d.other(v).buf
+	for _, h := range hunks {
+		for i := 0; i < h.aEnd-h.aStart && i < h.bEnd-h.bStart; i++ {
+			text[h.bStart+i] = changedRange(other.Line(h.aStart+i), v.buf.Line(h.bStart+i))
+		}
+	}
+	return changes, text
+}
+
+// changedRange returns the range of the characters of the line which differ
+// from the old line, leaving out what they start and end with in common
+func changedRange(old, line string) [2]int {
+	a, b := []rune(old), []rune(line)
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		start++
+	}
+	end := len(b)
+	for end > start && len(a)-(len(b)-end) > start && a[len(a)-(len(b)-end)-1] == b[end-1] {
+		end--
+	}
+	return [2]int{start, end}
+}
+
+// diffBackground returns the background of a line of a diff view with a kind
+// of change, or of the part of a modified line which changed
+// It returns false for the lines which aren't highlighted
+func diffBackground(kind int, changedText bool) (tcell.Color, bool) {
+	group, color := "", tcell.ColorDefault
+	switch {
+	case kind == GitAdded:
+		group, color = "diff-line-added", tcell.ColorDarkGreen
+	case kind == GitRemoved:
+		group, color = "diff-line-deleted", tcell.ColorMaroon
+	case kind == GitModified && changedText:
+		group, color = "diff-text-changed", tcell.ColorTeal
+	case kind == GitModified:
+		group, color = "diff-line-changed", tcell.ColorNavy
+	default:
+		return color, false
+	}
+	if style, ok := colorscheme[group]; ok {
+		_, bg, _ := style.Decompose()
+		return bg, true
+	}
+	return color, true
+}
+
+// DiffGet replaces the change under the cursor with the lines on the other
+// side of the diff
+func (v *View) DiffGet() bool {
+	if v.diff == nil {
+		messenger.Error(errNoDiff.Error())
+		return true
+	}
+	h, ok := hunkAt(v.diff.HunksFor(v, true), v.cursor.y)
+	if !ok {
+		messenger.Message("The cursor is not on a change")
+		return true
+	}
+	other := v.diff.other(v).buf
+	ReplaceLines(v.buf, h.bStart, h.bEnd, other.Lines(h.aStart, h.aEnd))
+	return true
+}
+
+// DiffPut replaces the lines on the other side of the diff with the change
+// under the cursor
+func (v *View) DiffPut() bool {
+	if v.diff == nil {
+		messenger.Error(errNoDiff.Error())
+		return true
+	}
+	h, ok := hunkAt(v.diff.HunksFor(v, true), v.cursor.y)
+	if !ok {
+		messenger.Message("The cursor is not on a change")
+		return true
+	}
+	other := v.diff.other(v).buf
+	ReplaceLines(other, h.aStart, h.aEnd, v.buf.Lines(h.bStart, h.bEnd))
+	return true
+}
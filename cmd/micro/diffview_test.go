@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newTestDiff returns two views comparing the texts
+func newTestDiff(left, right string) *DiffView {
+	views := [2]*View{}
+	for i, txt := range []string{left, right} {
+		views[i] = &View{buf: NewBuffer(txt, "")}
+		views[i].cursor.v = views[i]
+		views[i].buf.eh.v = views[i]
+		views[i].height = 10
+	}
+	return NewDiffView(views[0], views[1])
+}
+
+func TestChangedRange(t *testing.T) {
+	tests := []struct {
+		old, line string
+		want      [2]int
+	}{
+		{"foo(a, b)", "foo(a, c)", [2]int{7, 8}},
+		{"abc", "abxbc", [2]int{2, 4}},
+		{"abxbc", "abc", [2]int{2, 2}},
+		{"", "é", [2]int{0, 1}},
+		{"aaa", "aaaa", [2]int{3, 4}},
+	}
+	for _, test := range tests {
+		if got := changedRange(test.old, test.line); got != test.want {
+			t.Errorf("changedRange(%q, %q) = %v, want %v", test.old, test.line, got, test.want)
+		}
+	}
+}
+
+func TestDiffView(t *testing.T) {
+	messenger = new(Messenger)
+	d := newTestDiff("a\nb\nc\nd\ne\nf", "a\nB\nc\nx\ny\nd\nf")
+	left, right := d.left, d.right
+
+	changes, text := d.Changes(right)
+	if want := map[int]int{1: GitModified, 3: GitAdded, 4: GitAdded, 5: GitDeleted}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("The changes on the right are %v, want %v", changes, want)
+	}
+	if text[1] != [2]int{0, 1} {
+		t.Errorf("The changed text of B is %v", text[1])
+	}
+	changes, _ = d.Changes(left)
+	if want := map[int]int{1: GitModified, 2: GitDeleted, 4: GitRemoved}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("The changes on the left are %v, want %v", changes, want)
+	}
+
+	// Scrolling one side scrolls the other to the same part of the text
+	right.topline = 5
+	d.Scroll()
+	if left.topline != 3 {
+		t.Errorf("The left view scrolled to %d, want 3", left.topline)
+	}
+	left.topline = 5
+	d.Scroll()
+	if right.topline != 6 {
+		t.Errorf("The right view scrolled to %d, want 6", right.topline)
+	}
+
+	right.NextHunk()
+	right.NextHunk()
+	if right.cursor.y != 3 {
+		t.Errorf("The second change on the right is on line %d, want 3", right.cursor.y)
+	}
+
+	// Getting the added lines deletes them, and putting them adds them back
+	right.DiffGet()
+	if got := right.buf.String(); got != "a\nB\nc\nd\nf" {
+		t.Errorf("Getting the change gave %q", got)
+	}
+	right.buf.eh.Undo()
+	right.cursor.y = 1
+	right.DiffPut()
+	if got := left.buf.String(); got != "a\nB\nc\nd\ne\nf" {
+		t.Errorf("Putting the change gave %q", got)
+	}
+	left.cursor.y = 4
+	left.DiffPut()
+	if got := right.buf.String(); got != "a\nB\nc\nx\ny\nd\ne\nf" {
+		t.Errorf("Putting the removed line gave %q", got)
+	}
+	if hunks := d.Hunks(true); len(hunks) != 1 {
+		t.Errorf("The hunks left are %v", hunks)
+	}
+
+	// While the text is edited the old hunks are shown, and the texts are
+	// diffed again in the background once they stop changing
+	right.buf.Insert(0, "new\n")
+	if hunks := d.Hunks(false); len(hunks) != 1 || d.job != nil {
+		t.Errorf("The texts were diffed while they were being edited")
+	}
+	waitFor(t, "the diff", func() bool { return len(d.Hunks(false)) == 2 })
+
+	d.Close()
+	if left.diff != nil || right.diff != nil {
+		t.Error("Closing the diff left the views linked")
+	}
+	if left.DiffGet(); messenger.message != errNoDiff.Error() {
+		t.Errorf("DiffGet outside of a diff said %q", messenger.message)
+	}
+}
@@ -42,29 +42,45 @@ func UndoTextEvent(t *TextEvent) {
 
 // EventHandler executes text manipulations and allows undoing and redoing
 type EventHandler struct {
-	v    *View
-	undo *Stack
-	redo *Stack
+	buf *Buffer
+	// The view which is making the edits, whose cursor is saved and restored
+	// This changes when the buffer is open in multiple views, and is nil when
+	// the buffer is edited without being shown, like a file changed by a grep
+	v *View
+	// Every state the buffer has been in
+	tree *UndoTree
+
+	// The group which new events are added to, and how many groups are open
+	group      int
+	groupDepth int
 }
 
-// NewEventHandler returns a new EventHandler
-func NewEventHandler(v *View) *EventHandler {
+// NewEventHandler returns a new EventHandler for the buffer
+func NewEventHandler(buf *Buffer) *EventHandler {
 	eh := new(EventHandler)
-	eh.undo = new(Stack)
-	eh.redo = new(Stack)
-	eh.v = v
+	eh.tree = NewUndoTree()
+	eh.buf = buf
 	return eh
 }
 
+// cursor returns the cursor of the view making the edits, which is saved with
+// the events
+func (eh *EventHandler) cursor() Cursor {
+	if eh.v == nil {
+		return Cursor{}
+	}
+	return eh.v.cursor
+}
+
 // Insert creates an insert text event and executes it
 func (eh *EventHandler) Insert(start int, text string) {
 	e := &TextEvent{
-		c:         eh.v.cursor,
+		c:         eh.cursor(),
 		eventType: TextEventInsert,
 		text:      text,
 		start:     start,
 		end:       start + Count(text),
-		buf:       eh.v.buf,
+		buf:       eh.buf,
 		time:      time.Now(),
 	}
 	eh.Execute(e)
@@ -73,11 +89,11 @@ func (eh *EventHandler) Insert(start int, text string) {
 // Remove creates a remove text event and executes it
 func (eh *EventHandler) Remove(start, end int) {
 	e := &TextEvent{
-		c:         eh.v.cursor,
+		c:         eh.cursor(),
 		eventType: TextEventRemove,
 		start:     start,
 		end:       end,
-		buf:       eh.v.buf,
+		buf:       eh.buf,
 		time:      time.Now(),
 	}
 	eh.Execute(e)
@@ -89,39 +105,64 @@ func (eh *EventHandler) Replace(start, end int, replace string) {
 	eh.Insert(start, replace)
 }
 
-// Execute a textevent and add it to the undo stack
+// BeginGroup starts a group of events which are undone and redone together,
+// as if they were a single event
+// Every BeginGroup must be followed by an EndGroup
+// Groups can be nested, in which case the events of the inner groups are
+// part of the outermost group
+func (eh *EventHandler) BeginGroup() {
+	if eh.groupDepth == 0 {
+		// The sequence number of the first event identifies the group
+		eh.group = len(eh.tree.nodes)
+	}
+	eh.groupDepth++
+}
+
+// EndGroup ends the group started by the last BeginGroup
+func (eh *EventHandler) EndGroup() {
+	eh.groupDepth--
+	if eh.groupDepth == 0 {
+		eh.group = 0
+	}
+}
+
+// Execute a textevent and add it to the undo tree
+// If some changes were undone, the new event starts a new branch of the tree
+// so the changes can still be reached
 func (eh *EventHandler) Execute(t *TextEvent) {
-	if eh.redo.Len() > 0 {
-		eh.redo = new(Stack)
+	n := eh.tree.Add(t)
+	n.group = eh.group
+	if eh.v == nil {
+		ExecuteTextEvent(t)
+		return
 	}
-	eh.undo.Push(t)
-	ExecuteTextEvent(t)
+	eh.v.executeAtCursors(t)
 }
 
-// Undo the first event in the undo stack
+// Undo the last event, along with the events which were made just before it
 func (eh *EventHandler) Undo() {
-	t := eh.undo.Peek()
-	if t == nil {
+	if eh.tree.cur == eh.tree.root {
 		return
 	}
 
-	te := t.(*TextEvent)
-	startTime := t.(*TextEvent).time.UnixNano() / int64(time.Millisecond)
+	startTime := eh.tree.cur.time.UnixNano() / int64(time.Millisecond)
 
-	eh.UndoOneEvent()
+	eh.UndoGroup()
 
-	for {
-		t = eh.undo.Peek()
-		if t == nil {
+	for eh.tree.cur != eh.tree.root {
+		if startTime-(eh.tree.cur.time.UnixNano()/int64(time.Millisecond)) > undoThreshold {
 			return
 		}
 
-		te = t.(*TextEvent)
-
-		if startTime-(te.time.UnixNano()/int64(time.Millisecond)) > undoThreshold {
-			return
-		}
+		eh.UndoGroup()
+	}
+}
 
+// UndoGroup undoes one event, and the other events of its group
+func (eh *EventHandler) UndoGroup() {
+	group := eh.tree.cur.group
+	eh.UndoOneEvent()
+	for group != 0 && eh.tree.cur.group == group {
 		eh.UndoOneEvent()
 	}
 }
@@ -129,68 +170,137 @@ func (eh *EventHandler) Undo() {
 // UndoOneEvent undoes one event
 func (eh *EventHandler) UndoOneEvent() {
 	// This event should be undone
-	// Pop it off the stack
-	t := eh.undo.Pop()
-	if t == nil {
+	// Move up the tree
+	n := eh.tree.cur
+	if n == eh.tree.root {
 		return
 	}
 
-	te := t.(*TextEvent)
+	te := n.event
 	// Undo it
 	// Modifies the text event
 	UndoTextEvent(te)
 
 	// Set the cursor in the right place
-	teCursor := te.c
-	te.c = eh.v.cursor
-	eh.v.cursor = teCursor
+	eh.swapCursor(te)
 
-	// Push it to the redo stack
-	eh.redo.Push(te)
+	// Redo should come back down this branch
+	parent := n.parent
+	for i, child := range parent.children {
+		if child == n {
+			parent.redoChild = i
+		}
+	}
+	eh.tree.cur = parent
 }
 
-// Redo the first event in the redo stack
+// Redo the next event on the current branch, along with the events which
+// were made just after it
 func (eh *EventHandler) Redo() {
-	t := eh.redo.Peek()
-	if t == nil {
+	n := eh.tree.RedoNode()
+	if n == nil {
 		return
 	}
 
-	te := t.(*TextEvent)
-	startTime := t.(*TextEvent).time.UnixNano() / int64(time.Millisecond)
+	startTime := n.time.UnixNano() / int64(time.Millisecond)
 
-	eh.RedoOneEvent()
+	eh.RedoGroup()
 
 	for {
-		t = eh.redo.Peek()
-		if t == nil {
+		n = eh.tree.RedoNode()
+		if n == nil {
 			return
 		}
 
-		te = t.(*TextEvent)
-
-		if (te.time.UnixNano()/int64(time.Millisecond))-startTime > undoThreshold {
+		if (n.time.UnixNano()/int64(time.Millisecond))-startTime > undoThreshold {
 			return
 		}
 
+		eh.RedoGroup()
+	}
+}
+
+// RedoGroup redoes one event, and the other events of its group
+func (eh *EventHandler) RedoGroup() {
+	eh.RedoOneEvent()
+	group := eh.tree.cur.group
+	for group != 0 {
+		n := eh.tree.RedoNode()
+		if n == nil || n.group != group {
+			return
+		}
 		eh.RedoOneEvent()
 	}
 }
 
 // RedoOneEvent redoes one event
 func (eh *EventHandler) RedoOneEvent() {
-	t := eh.redo.Pop()
-	if t == nil {
+	n := eh.tree.RedoNode()
+	if n == nil {
 		return
 	}
 
-	te := t.(*TextEvent)
+	te := n.event
 	// Modifies the text event
 	UndoTextEvent(te)
 
+	eh.swapCursor(te)
+
+	eh.tree.cur = n
+}
+
+// swapCursor puts the view's cursor back where it was at the event, and keeps
+// the cursor of the view in the event for the next undo or redo
+func (eh *EventHandler) swapCursor(te *TextEvent) {
+	if eh.v == nil {
+		return
+	}
 	teCursor := te.c
 	te.c = eh.v.cursor
 	eh.v.cursor = teCursor
+	// The event may have been made from another view of the buffer
+	eh.v.cursor.v = eh.v
+}
+
+// GoTo undoes and redoes events until the buffer is in the given state,
+// which may be on another branch of the undo tree
+func (eh *EventHandler) GoTo(target *UndoNode) {
+	undo, redo := eh.tree.Path(target)
+	for range undo {
+		eh.UndoOneEvent()
+	}
+	for _, n := range redo {
+		// Make sure the redo goes down the right branch
+		for i, child := range eh.tree.cur.children {
+			if child == n {
+				eh.tree.cur.redoChild = i
+			}
+		}
+		eh.RedoOneEvent()
+	}
+}
 
-	eh.undo.Push(te)
+// Earlier moves n changes back in time, regardless of which branch they were made on
+func (eh *EventHandler) Earlier(n int) {
+	eh.GoTo(eh.tree.Seq(-n))
+}
+
+// Later moves n changes forward in time, regardless of which branch they were made on
+func (eh *EventHandler) Later(n int) {
+	eh.GoTo(eh.tree.Seq(n))
+}
+
+// EarlierTime goes back to the state the buffer was in the given duration before
+// the current state
+func (eh *EventHandler) EarlierTime(d time.Duration) {
+	eh.GoTo(eh.tree.AtTime(eh.tree.cur.time.Add(-d)))
+}
+
+// LaterTime goes forward to the state the buffer was in the given duration after
+// the current state
+func (eh *EventHandler) LaterTime(d time.Duration) {
+	target := eh.tree.AtTime(eh.tree.cur.time.Add(d))
+	if target.seq > eh.tree.cur.seq {
+		eh.GoTo(target)
+	}
 }
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/gdamore/tcell"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxIndexedFiles is the most files the finder indexes, so that opening it in
+// a huge directory (like the home directory) doesn't use up the memory
+const maxIndexedFiles = 100000
+
+// errIndexStopped stops the walk of a file index
+var errIndexStopped = errors.New("indexing stopped")
+
+// A FileIndex is the list of files under a directory, which is filled in by a
+// goroutine in the background
+type FileIndex struct {
+	sync.Mutex
+	files []string
+	// Whether the whole directory tree has been indexed
+	done bool
+	// Whether the index stopped at maxIndexedFiles
+	truncated bool
+	stopped   bool
+}
+
+// IndexFiles starts indexing the files under root, with their paths relative
+// to root, leaving out the files which are ignored by a .gitignore
+// Whenever a batch of files has been added, an interrupt event is posted to
+// the screen, so that the finder can update its list
+func IndexFiles(root string) *FileIndex {
+	idx := new(FileIndex)
+	go func() {
+		var batch []string
+		flush := func() {
+			idx.Lock()
+			idx.files = append(idx.files, batch...)
+			idx.Unlock()
+			batch = nil
+			if screen != nil {
+				screen.PostEvent(tcell.NewEventInterrupt(idx))
+			}
+		}
+
+		count := 0
+		err := WalkProject(root, func(file string) error {
+			if count == maxIndexedFiles {
+				return errIndexStopped
+			}
+			idx.Lock()
+			stopped := idx.stopped
+			idx.Unlock()
+			if stopped {
+				return errIndexStopped
+			}
+
+			if rel, err := filepath.Rel(root, file); err == nil {
+				file = rel
+			}
+			batch = append(batch, file)
+			count++
+			if len(batch) == 1000 {
+				flush()
+			}
+			return nil
+		})
+
+		idx.Lock()
+		idx.done = true
+		idx.truncated = err == errIndexStopped && count == maxIndexedFiles
+		idx.Unlock()
+		flush()
+	}()
+	return idx
+}
+
+// Files returns the files which have been indexed so far, and whether the
+// indexing is done
+func (idx *FileIndex) Files() ([]string, bool) {
+	idx.Lock()
+	defer idx.Unlock()
+	// Files are only ever appended, so the slice can be used after unlocking
+	return idx.files, idx.done
+}
+
+// Stop stops the indexing, if it isn't done yet
+func (idx *FileIndex) Stop() {
+	idx.Lock()
+	idx.stopped = true
+	idx.Unlock()
+}
+
+// The scores of a fuzzy match
+// Every matched character scores fuzzyMatchScore, with a bonus if it starts a
+// word or follows the character matched before it, and a penalty for every
+// character skipped between two matched characters
+const (
+	fuzzyMatchScore       = 16
+	fuzzySlashBonus       = 10
+	fuzzyBoundaryBonus    = 8
+	fuzzyCamelBonus       = 7
+	fuzzyConsecutiveBonus = 8
+	fuzzyBasenameBonus    = 2
+	fuzzyGapPenalty       = 1
+)
+
+// fuzzyBonus returns the bonus for matching the character at j
+func fuzzyBonus(c []rune, j, lastSlash int) int {
+	bonus := 0
+	if j > lastSlash {
+		bonus += fuzzyBasenameBonus
+	}
+	switch {
+	case j == 0 || c[j-1] == '/':
+		bonus += fuzzySlashBonus
+	case strings.ContainsRune("_-. ", c[j-1]):
+		bonus += fuzzyBoundaryBonus
+	case unicode.IsLower(c[j-1]) && unicode.IsUpper(c[j]):
+		bonus += fuzzyCamelBonus
+	}
+	return bonus
+}
+
+// FuzzyMatch matches the characters of pattern, in order and ignoring case, in
+// candidate
+// It returns the score of the best match (higher is better) and the positions
+// of the matched characters, or false if candidate doesn't have every character
+// of pattern in order
+func FuzzyMatch(pattern, candidate string) (int, []int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, nil, true
+	}
+	c := []rune(candidate)
+	lower := []rune(strings.ToLower(candidate))
+	if len(lower) != len(c) {
+		// A few characters change length when lowered, so give up on them
+		lower = c
+	}
+
+	// Most candidates don't match at all, so check that first
+	i := 0
+	for j := 0; j < len(lower) && i < len(p); j++ {
+		if lower[j] == p[i] {
+			i++
+		}
+	}
+	if i < len(p) {
+		return 0, nil, false
+	}
+
+	lastSlash := strings.LastIndex(candidate, "/")
+	if lastSlash >= 0 {
+		lastSlash = Count(candidate[:lastSlash])
+	}
+
+	// scores[i][j] is the best score of matching p[:i+1] with p[i] at c[j],
+	// and from[i][j] is where p[i-1] is in that match
+	const none = -1 << 30
+	scores := make([][]int, len(p))
+	from := make([][]int, len(p))
+	for i := range p {
+		scores[i] = make([]int, len(c))
+		from[i] = make([]int, len(c))
+		// The best score of a match of p[:i] ending before j, minus the penalty
+		// for the characters skipped since, and where that match ends
+		best, bestAt := none, -1
+		for j := range c {
+			if i > 0 && j > 0 {
+				if best != none {
+					best -= fuzzyGapPenalty
+				}
+				if scores[i-1][j-1] > best {
+					best, bestAt = scores[i-1][j-1], j-1
+				}
+			}
+			scores[i][j] = none
+			if lower[j] != p[i] {
+				continue
+			}
+			score := fuzzyMatchScore + fuzzyBonus(c, j, lastSlash)
+			if i == 0 {
+				scores[i][j] = score
+				continue
+			}
+			if j > 0 && scores[i-1][j-1] != none && scores[i-1][j-1]+fuzzyConsecutiveBonus >= best {
+				scores[i][j] = scores[i-1][j-1] + fuzzyConsecutiveBonus + score
+				from[i][j] = j - 1
+			} else if best != none {
+				scores[i][j] = best + score
+				from[i][j] = bestAt
+			}
+		}
+	}
+
+	last := len(p) - 1
+	end := -1
+	for j := range c {
+		if scores[last][j] != none && (end < 0 || scores[last][j] > scores[last][end]) {
+			end = j
+		}
+	}
+	positions := make([]int, len(p))
+	for i, j := last, end; i >= 0; i-- {
+		positions[i] = j
+		j = from[i][j]
+	}
+	return scores[last][end], positions, true
+}
+
+// A FuzzyResult is a file which matches the query of the finder
+type FuzzyResult struct {
+	path      string
+	score     int
+	positions []int
+}
+
+// RankFiles returns the files which match the pattern, best first
+// Matches with the same score are sorted by length, and then by name
+func RankFiles(pattern string, files []string) []FuzzyResult {
+	var results []FuzzyResult
+	for _, file := range files {
+		if score, positions, ok := FuzzyMatch(pattern, file); ok {
+			results = append(results, FuzzyResult{file, score, positions})
+		}
+	}
+	if pattern == "" {
+		return results
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.score != b.score {
+			return a.score > b.score
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) < len(b.path)
+		}
+		return a.path < b.path
+	})
+	return results
+}
+
+// A Finder is the state of the fuzzy finder while it is open
+type Finder struct {
+	idx *FileIndex
+
+	// The results for the query, and the query and number of files they were ranked for
+	results     []FuzzyResult
+	rankedQuery string
+	rankedFiles int
+
+	// The selected result, and the first result shown on the screen
+	selected int
+	top      int
+
+	// The lines of the previewed file
+	previewPath  string
+	previewLines []string
+}
+
+// FindFile opens the fuzzy finder on the files under the current directory,
+// and opens the chosen file in the view
+func (v *View) FindFile() bool {
+	f := &Finder{idx: IndexFiles(".")}
+	defer f.idx.Stop()
+
+	file, ok := f.Run()
+	// Make sure to resize the views if the user resized the terminal in the finder
+	ResizeTabs()
+	if !ok {
+		return true
+	}
+	buf, err := OpenBuffer(file)
+	if err != nil {
+		messenger.Error(err.Error())
+		return true
+	}
+	v.SetBuffer(buf)
+	v.UpdateLines(v.topline, v.topline+v.height)
+	return true
+}
+
+// Run shows the finder over the whole screen until the user chooses a file or
+// cancels, and returns the chosen file
+// The query is typed in the messenger, and the results are updated as more
+// files are indexed
+// It blocks the main loop
+func (f *Finder) Run() (string, bool) {
+	messenger.Reset()
+	messenger.hasPrompt = true
+	defer func() {
+		messenger.hasPrompt = false
+		messenger.Reset()
+		messenger.Clear()
+	}()
+
+	for {
+		f.update()
+		f.Display()
+
+		_, h := screen.Size()
+		switch e := PollEvent().(type) {
+		case *tcell.EventKey:
+			switch e.Key() {
+			case tcell.KeyCtrlQ, tcell.KeyCtrlC, tcell.KeyEscape:
+				return "", false
+			case tcell.KeyEnter:
+				if len(f.results) > 0 {
+					return f.results[f.selected].path, true
+				}
+			case tcell.KeyUp, tcell.KeyCtrlP:
+				f.selected--
+			case tcell.KeyDown, tcell.KeyCtrlN:
+				f.selected++
+			case tcell.KeyPgUp:
+				f.selected -= h - 1
+			case tcell.KeyPgDn:
+				f.selected += h - 1
+			default:
+				if messenger.HandleEvent(e) {
+					// Backspace in an empty query cancels, like in a prompt
+					return "", false
+				}
+			}
+		}
+	}
+}
+
+// update ranks the files again if the query or the indexed files changed, and
+// keeps the selected result on the screen
+func (f *Finder) update() {
+	files, _ := f.idx.Files()
+	query := messenger.input.Text()
+	if query != f.rankedQuery || len(files) != f.rankedFiles {
+		if query != f.rankedQuery {
+			f.selected = 0
+		}
+		f.results = RankFiles(query, files)
+		f.rankedQuery = query
+		f.rankedFiles = len(files)
+	}
+
+	_, h := screen.Size()
+	height := h - 1
+	f.selected = Max(Min(f.selected, len(f.results)-1), 0)
+	if f.selected < f.top {
+		f.top = f.selected
+	}
+	if f.selected >= f.top+height {
+		f.top = f.selected - height + 1
+	}
+}
+
+// prompt returns the prompt of the finder, with the number of results
+func (f *Finder) prompt() string {
+	files, done := f.idx.Files()
+	status := strconv.Itoa(len(f.results)) + "/" + strconv.Itoa(len(files))
+	f.idx.Lock()
+	if f.idx.truncated {
+		status += ", only the first " + strconv.Itoa(maxIndexedFiles) + " files"
+	}
+	f.idx.Unlock()
+	if !done {
+		status += ", indexing..."
+	}
+	return "Open file (" + status + "): "
+}
+
+// preview returns the first lines of the selected file, with tabs expanded
+func (f *Finder) preview() []string {
+	if len(f.results) == 0 {
+		return nil
+	}
+	file := f.results[f.selected].path
+	if file == f.previewPath {
+		return f.previewLines
+	}
+	f.previewPath = file
+	_, h := screen.Size()
+	lines, ok := readLines(file, h)
+	if !ok {
+		f.previewLines = []string{"(binary file)"}
+		return f.previewLines
+	}
+	for i := range lines {
+		lines[i] = strings.Replace(lines[i], "\t", Spaces(settings.TabSize), -1)
+	}
+	f.previewLines = lines
+	return lines
+}
+
+// maxPreviewLine is the longest line which is read for a preview
+const maxPreviewLine = 64 * 1024
+
+// readLines reads the first n lines of a file, so that a big file can be
+// previewed without reading all of it
+// A very long line ends the preview, and it returns false if the lines have a
+// null byte, like a binary file
+func readLines(file string, n int) ([]string, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxPreviewLine)
+	for len(lines) < n && scanner.Scan() {
+		if bytes.IndexByte(scanner.Bytes(), 0) >= 0 {
+			return nil, false
+		}
+		lines = append(lines, scanner.Text())
+	}
+	return lines, true
+}
+
+// Display draws the results on the left, the preview of the selected file on
+// the right and the query at the bottom
+func (f *Finder) Display() {
+	screen.Clear()
+	w, h := screen.Size()
+
+	matchStyle := defStyle.Foreground(tcell.ColorYellow).Bold(true)
+	if style, ok := colorscheme["finder-match"]; ok {
+		matchStyle = style
+	}
+
+	// There is only room for the preview on a wide enough screen
+	listWidth := w
+	if w >= 60 {
+		listWidth = w / 2
+	}
+
+	for y := 0; y < h-1 && f.top+y < len(f.results); y++ {
+		r := f.results[f.top+y]
+		selected := f.top+y == f.selected
+		matched := make(map[int]bool)
+		for _, pos := range r.positions {
+			matched[pos] = true
+		}
+		for x, ch := range []rune(r.path) {
+			if x >= listWidth-1 {
+				break
+			}
+			style := defStyle
+			if matched[x] {
+				style = matchStyle
+			}
+			if selected {
+				style = style.Reverse(true)
+			}
+			screen.SetContent(x, y, ch, nil, style)
+		}
+	}
+
+	if listWidth < w {
+		for y := 0; y < h-1; y++ {
+			screen.SetContent(listWidth, y, tcell.RuneVLine, nil, defStyle)
+		}
+		for y, line := range f.preview() {
+			if y >= h-1 {
+				break
+			}
+			x := listWidth + 2
+			for _, ch := range line {
+				if x >= w {
+					break
+				}
+				screen.SetContent(x, y, ch, nil, defStyle)
+				x++
+			}
+		}
+	}
+
+	messenger.Message(f.prompt())
+	messenger.Display()
+	screen.Show()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		pattern, candidate string
+		positions          []int
+		ok                 bool
+	}{
+		{"", "abc", nil, true},
+		{"abc", "ab", nil, false},
+		{"ca", "abc", nil, false},
+		{"ABC", "xaxbxc", []int{1, 3, 5}, true},
+		// The start of the file name is preferred over an earlier match
+		{"mi", "cmd/micro/view.go", []int{4, 5}, true},
+		{"vgo", "cmd/micro/view.go", []int{10, 15, 16}, true},
+		// Consecutive characters are preferred
+		{"view", "v/i/e/w/view", []int{8, 9, 10, 11}, true},
+		{"fb", "fooBar", []int{0, 3}, true},
+	}
+	for _, test := range tests {
+		_, positions, ok := FuzzyMatch(test.pattern, test.candidate)
+		if ok != test.ok || (ok && !reflect.DeepEqual(positions, test.positions)) {
+			t.Errorf("FuzzyMatch(%q, %q) = %v, %v, want %v, %v", test.pattern, test.candidate,
+				positions, ok, test.positions, test.ok)
+		}
+	}
+}
+
+func TestRankFiles(t *testing.T) {
+	files := []string{"README.md", "cmd/micro/buffer.go", "cmd/micro/view.go", "runtime/syntax/vi.micro", "tools/vwgo"}
+	var got []string
+	for _, r := range RankFiles("vgo", files) {
+		got = append(got, r.path)
+	}
+	// The g of view.go starts a word, so it beats the closer g of vwgo
+	want := []string{"cmd/micro/view.go", "tools/vwgo"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ranking for vgo gave %v, want %v", got, want)
+	}
+
+	// Without a query every file is listed
+	if n := len(RankFiles("", files)); n != len(files) {
+		t.Errorf("An empty query gave %d files, want %d", n, len(files))
+	}
+}
+
+func TestIndexFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-finder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProject(t, dir, map[string]string{
+		".gitignore": "*.o\n",
+		"a/b.go":     "",
+		"a/b.o":      "",
+		"c.txt":      "",
+	})
+
+	idx := IndexFiles(dir)
+	var files []string
+	for done := false; !done; {
+		files, done = idx.Files()
+	}
+	sort.Strings(files)
+	want := []string{".gitignore", "a/b.go", "c.txt"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Indexing gave %v, want %v", files, want)
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-preview")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	text := filepath.Join(dir, "text")
+	ioutil.WriteFile(text, []byte("a\nb\nc\nd\n"), 0644)
+	binary := filepath.Join(dir, "binary")
+	ioutil.WriteFile(binary, []byte("a\x00b\n"), 0644)
+
+	if lines, ok := readLines(text, 2); !ok || !reflect.DeepEqual(lines, []string{"a", "b"}) {
+		t.Errorf("The first lines are %q, %v", lines, ok)
+	}
+	if _, ok := readLines(binary, 2); ok {
+		t.Error("The binary file was read")
+	}
+}
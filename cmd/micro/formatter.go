@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// formatTimeout is how long a formatter can take, since micro waits for it
+const formatTimeout = 5 * time.Second
+
+// formatters maps filetypes to the shell command which formats their text
+// The command reads the text on its standard input and writes the formatted
+// text to its standard output. %f in the command is the name of the file,
+// and the command runs in the file's directory
+var formatters map[string]string
+
+// DefaultFormatters returns the formatters micro runs when they are installed,
+// by filetype
+func DefaultFormatters() map[string]string {
+	return map[string]string{
+		"Go":         "gofmt",
+		"Python":     "black -q -",
+		"JavaScript": "prettier --stdin-filepath %f",
+		"Rust":       "rustfmt --emit stdout",
+		"C":          "clang-format --assume-filename=%f",
+	}
+}
+
+// InitFormatters loads the default formatters, and then the user's from
+// $(configDir)/formatters.json, which maps filetypes to commands like this:
+// {"Python": "autopep8 -"}
+// Mapping a filetype to "" turns its formatter off
+func InitFormatters() {
+	formatters = DefaultFormatters()
+
+	filename := configDir + "/formatters.json"
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		TermMessage("Error reading formatters.json file: " + err.Error())
+		return
+	}
+	var parsed map[string]string
+	if err := json.Unmarshal(input, &parsed); err != nil {
+		TermMessage("Error reading formatters.json: " + err.Error())
+		return
+	}
+	for filetype, command := range parsed {
+		formatters[filetype] = command
+	}
+}
+
+// errNoFormatter is the error for formatting a buffer which has no formatter
+var errNoFormatter = errors.New("there is no formatter for this buffer")
+
+// formatterFor returns the formatter command for the buffer, or "" if it has
+// none or the formatter isn't installed
+func formatterFor(buf *Buffer) string {
+	command := formatters[buf.filetype]
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return ""
+	}
+	return command
+}
+
+// Format pipes the text through a formatter command, and returns the
+// formatted text
+// The command runs in the directory of the file at path, if there is one
+func Format(text, path, command string) (string, error) {
+	dir, file := "", ""
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return "", err
+		}
+		dir, file = filepath.Split(abs)
+	}
+	return RunShellTimeout(dir, strings.Replace(command, "%f", shellQuote(file), -1), text, formatTimeout)
+}
+
+// FormatBuffer runs the buffer's formatter on its text, and changes only the
+// lines which the formatter changed, so that the formatting is a single undo
+// and the cursors stay on the same lines
+// It returns whether anything changed
+// If the formatter fails, for example because of a syntax error, the buffer
+// is left alone
+func FormatBuffer(buf *Buffer) (bool, error) {
+	command := formatterFor(buf)
+	if command == "" {
+		return false, errNoFormatter
+	}
+	text, err := Format(buf.String(), buf.path, command)
+	if err != nil {
+		return false, errors.New(strings.Fields(command)[0] + ": " + err.Error())
+	}
+	return len(ReplaceText(buf, text)) > 0, nil
+}
+
+// FormatCommand formats the current buffer
+func FormatCommand(view *View, args []string) {
+	changed, err := FormatBuffer(view.buf)
+	if err != nil {
+		messenger.Error(err.Error())
+	} else if changed {
+		messenger.Message("Formatted " + view.buf.name)
+	} else {
+		messenger.Message(view.buf.name + " is already formatted")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatBuffer(t *testing.T) {
+	messenger = new(Messenger)
+	dir, err := ioutil.TempDir("", "micro-fmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("a  b\nc\n"), 0644)
+
+	buf, err := NewBufferFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &View{buf: buf}
+	v.cursor.v = v
+	buf.eh.v = v
+
+	// The formatter gets the file's name, runs in its directory and reads
+	// the text from its standard input
+	formatters = map[string]string{buf.filetype: `test -f %f && sed 's/  */ /g'`}
+	defer func() { formatters = nil }()
+	if changed, err := FormatBuffer(buf); !changed || err != nil {
+		t.Fatalf("Formatting gave %v, %v", changed, err)
+	}
+	if got := buf.String(); got != "a b\nc\n" {
+		t.Errorf("The formatted text is %q", got)
+	}
+	if changed, err := FormatBuffer(buf); changed || err != nil {
+		t.Errorf("Formatting again gave %v, %v", changed, err)
+	}
+	buf.eh.Undo()
+	if got := buf.String(); got != "a  b\nc\n" {
+		t.Errorf("Undoing the formatting gave %q", got)
+	}
+
+	// A formatter which fails leaves the text alone
+	formatters[buf.filetype] = "echo 'syntax error' >&2; exit 1"
+	if _, err := FormatBuffer(buf); err == nil || err.Error() != "echo: syntax error" {
+		t.Errorf("The failing formatter gave %v", err)
+	}
+	if got := buf.String(); got != "a  b\nc\n" {
+		t.Errorf("The failing formatter changed the text to %q", got)
+	}
+
+	formatters[buf.filetype] = ""
+	if _, err := FormatBuffer(buf); err != errNoFormatter {
+		t.Errorf("A buffer with no formatter gave %v", err)
+	}
+}
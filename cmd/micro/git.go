@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gdamore/tcell"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A GitStatus is what git says about the file of a buffer
+type GitStatus struct {
+	// The branch which is checked out, or the commit if no branch is
+	branch string
+	// Whether the repository has changes which aren't committed
+	dirty bool
+	// The lines of the file at HEAD, or nil if it isn't committed
+	base []string
+}
+
+// git runs git in the directory dir, and returns its output
+// If it fails, the error is what it wrote to its standard error
+func git(dir string, args ...string) (string, error) {
+	c := exec.Command("git", args...)
+	c.Dir = dir
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// ReadGitStatus asks git about the file at path
+// It returns nil if the file isn't in a git repository, or git isn't installed
+func ReadGitStatus(path string) *GitStatus {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	dir, file := filepath.Split(abs)
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	if _, err := git(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil
+	}
+
+	status := new(GitStatus)
+	if branch, err := git(dir, "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		status.branch = strings.TrimSpace(branch)
+	} else if commit, err := git(dir, "rev-parse", "--short", "HEAD"); err == nil {
+		status.branch = strings.TrimSpace(commit)
+	}
+	if changes, err := git(dir, "status", "--porcelain", "--untracked-files=no"); err == nil {
+		status.dirty = changes != ""
+	}
+	if base, err := git(dir, "show", "HEAD:./"+file); err == nil {
+		status.base = strings.Split(base, "\n")
+	}
+	return status
+}
+
+// inBackground runs work in a goroutine, and then the function it returns on
+// the main goroutine, where it is safe to change the buffers
+// Without a screen (in the tests) it all runs right away
+func inBackground(work func() func()) {
+	if screen == nil {
+		work()()
+		return
+	}
+	// The screen is taken now, since the tests change it
+	s := screen
+	go func() {
+		done := work()
+		s.PostEvent(tcell.NewEventInterrupt(done))
+	}()
+}
+
+// UpdateGit asks git about the buffer's file again, in the background
+// This is done when the file is opened and when it is saved
+func UpdateGit(buf *Buffer) {
+	if buf.path == "" {
+		return
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+	path := buf.path
+	inBackground(func() func() {
+		status := ReadGitStatus(path)
+		return func() {
+			buf.git = status
+			buf.gitHunks, buf.gitHash = nil, 0
+		}
+	})
+}
+
+// A gitDiff diffs a buffer against HEAD in the background
+type gitDiff struct {
+	*diffJob
+	// The status and the hash of the text which are diffed
+	status *GitStatus
+	hash   uint64
+}
+
+// GitHunks returns the hunks where the buffer differs from its file at HEAD
+// After the text changes they are found again, in the background once the
+// text stops changing unless wait is true, and the old ones are returned
+// until then
+func (b *Buffer) GitHunks(wait bool) []Hunk {
+	if d := b.gitDiff; d != nil {
+		if hunks, done := d.Result(); done {
+			b.gitDiff = nil
+			// The file may have been committed in the meantime
+			if d.status == b.git {
+				b.gitHunks, b.gitHash = hunks, d.hash
+			}
+		}
+	}
+	if b.git == nil || b.git.base == nil {
+		return nil
+	}
+	hash := b.r.Hash()
+	if hash == b.gitHash || b.gitDiff != nil && !wait {
+		return b.gitHunks
+	}
+
+	status := b.git
+	if wait {
+		// A diff in the background is of an older text
+		b.gitDiff = nil
+		b.gitHunks, b.gitHash = DiffLines(status.base, strings.Split(b.String(), "\n")), hash
+		return b.gitHunks
+	}
+	if !b.gitWait.Ready(hash) {
+		return b.gitHunks
+	}
+	text := b.String()
+	b.gitDiff = &gitDiff{startDiff(func() []Hunk {
+		return DiffLines(status.base, strings.Split(text, "\n"))
+	}), status, hash}
+	return b.gitHunks
+}
+
+// GitStatusLine returns the branch and whether the repository has changes
+// which aren't committed, as it is shown in the status line
+func (b *Buffer) GitStatusLine() string {
+	if b.git == nil || b.git.branch == "" {
+		return ""
+	}
+	if b.git.dirty {
+		return b.git.branch + "*"
+	}
+	return b.git.branch
+}
+
+// The kinds of changes which are marked in the gutter
+const (
+	GitAdded = iota + 1
+	GitModified
+	// Lines were deleted after the line, or before it for the first line
+	GitDeleted
+	GitDeletedAbove
+	// The line is only on the left side of a diff
+	GitRemoved
+)
+
+// GitChanges returns the kind of change of each changed line of the new text
+// of the hunks
+// The lines of a hunk which replace lines are modified, and the rest added
+func GitChanges(hunks []Hunk) map[int]int {
+	changes := make(map[int]int)
+	for _, h := range hunks {
+		if h.bStart == h.bEnd {
+			if h.bStart == 0 {
+				changes[0] = GitDeletedAbove
+			} else {
+				changes[h.bStart-1] = GitDeleted
+			}
+			continue
+		}
+		for y := h.bStart; y < h.bEnd; y++ {
+			if y-h.bStart < h.aEnd-h.aStart {
+				changes[y] = GitModified
+			} else {
+				changes[y] = GitAdded
+			}
+		}
+	}
+	return changes
+}
+
+// GitMarker returns the marker of a kind of change, and its style
+func GitMarker(kind int) (rune, tcell.Style) {
+	switch kind {
+	case GitAdded:
+		if style, ok := colorscheme["diff-added"]; ok {
+			return '+', style
+		}
+		return '+', defStyle.Foreground(tcell.ColorGreen)
+	case GitModified:
+		if style, ok := colorscheme["diff-modified"]; ok {
+			return '~', style
+		}
+		return '~', defStyle.Foreground(tcell.ColorYellow)
+	}
+	marker := '_'
+	if kind == GitDeletedAbove {
+		marker = '‾'
+	} else if kind == GitRemoved {
+		marker = '-'
+	}
+	if style, ok := colorscheme["diff-deleted"]; ok {
+		return marker, style
+	}
+	return marker, defStyle.Foreground(tcell.ColorRed)
+}
+
+// hunkLine returns the line where a hunk is marked in the new text
+func hunkLine(h Hunk) int {
+	if h.bStart == h.bEnd {
+		return Max(h.bStart-1, 0)
+	}
+	return h.bStart
+}
+
+// hunkAt returns the hunk which is marked on line y of the new text
+func hunkAt(hunks []Hunk, y int) (Hunk, bool) {
+	for _, h := range hunks {
+		if y == hunkLine(h) || h.bStart <= y && y < h.bEnd {
+			return h, true
+		}
+	}
+	return Hunk{}, false
+}
+
+// hunks returns the changes which NextHunk and PreviousHunk go through: the
+// differences from the other side of a diff, or from git's HEAD
+func (v *View) hunks() []Hunk {
+	if v.diff != nil {
+		return v.diff.HunksFor(v, true)
+	}
+	return v.buf.GitHunks(true)
+}
+
+// NextHunk moves the cursor to the next change
+func (v *View) NextHunk() bool {
+	for _, h := range v.hunks() {
+		if y := hunkLine(h); y > v.cursor.y {
+			v.gotoLine(y)
+			return true
+		}
+	}
+	messenger.Message("No more changes below")
+	return true
+}
+
+// PreviousHunk moves the cursor to the previous change
+func (v *View) PreviousHunk() bool {
+	hunks := v.hunks()
+	for i := len(hunks) - 1; i >= 0; i-- {
+		if y := hunkLine(hunks[i]); y < v.cursor.y {
+			v.gotoLine(y)
+			return true
+		}
+	}
+	messenger.Message("No more changes above")
+	return true
+}
+
+// gotoLine moves the cursor to the start of line y
+func (v *View) gotoLine(y int) {
+	v.cursor.ResetSelection()
+	v.cursor.x, v.cursor.y = 0, y
+	v.cursor.lastVisualX = 0
+}
+
+// RevertHunk puts back the lines of HEAD in the change the cursor is on
+// The revert is a single undo
+func (v *View) RevertHunk() bool {
+	if v.buf.git == nil || v.buf.git.base == nil {
+		messenger.Error("This file is not committed in git")
+		return true
+	}
+	h, ok := hunkAt(v.buf.GitHunks(true), v.cursor.y)
+	if !ok {
+		messenger.Message("The cursor is not on a change")
+		return true
+	}
+	ReplaceLines(v.buf, h.bStart, h.bEnd, v.buf.git.base[h.aStart:h.aEnd])
+	v.cursor.y = Min(h.bStart, v.buf.NumLines()-1)
+	v.Clamp()
+	return true
+}
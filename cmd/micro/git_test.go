@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGitChanges(t *testing.T) {
+	hunks := []Hunk{{0, 0, 0, 1}, {2, 3, 3, 5}, {5, 7, 7, 7}}
+	want := map[int]int{0: GitAdded, 3: GitModified, 4: GitAdded, 6: GitDeleted}
+	if got := GitChanges(hunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("The changes are %v, want %v", got, want)
+	}
+	if got := GitChanges([]Hunk{{0, 1, 0, 0}}); got[0] != GitDeletedAbove {
+		t.Errorf("Deleting the first line gave %v", got)
+	}
+}
+
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	messenger = new(Messenger)
+	dir, err := ioutil.TempDir("", "micro-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0644)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"checkout", "-q", "-b", "topic"},
+		{"add", "a.txt"},
+		{"-c", "user.name=micro", "-c", "user.email=micro@example.com", "commit", "-q", "-m", "a"},
+	} {
+		if _, err := git(dir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := NewBufferFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	UpdateGit(buf)
+	if got := buf.GitStatusLine(); got != "topic" {
+		t.Errorf("The status is %q, want topic", got)
+	}
+	v := &View{buf: buf}
+	v.cursor.v = v
+	buf.eh.v = v
+
+	buf.Insert(0, "zero\n")
+	buf.Remove(ToCharPos(0, 3, buf), ToCharPos(0, 4, buf))
+	buf.Insert(ToCharPos(0, 3, buf), "FOUR")
+	// The text is only diffed once it stops changing for a while
+	buf.GitHunks(false)
+	if buf.gitDiff != nil {
+		t.Error("The text was diffed while it was being edited")
+	}
+	// The diff in the background is picked up, even though no interrupt
+	// is handled
+	waitFor(t, "the diff", func() bool { return len(buf.GitHunks(false)) == 2 })
+	// zero, one, two, FOURfour
+	if got, want := buf.GitHunks(true), []Hunk{{0, 0, 0, 1}, {2, 4, 3, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("The hunks are %v, want %v", got, want)
+	}
+
+	v.NextHunk()
+	if v.cursor.y != 3 {
+		t.Errorf("The next change is on line %d, want 3", v.cursor.y)
+	}
+	v.PreviousHunk()
+	if v.cursor.y != 0 {
+		t.Errorf("The previous change is on line %d, want 0", v.cursor.y)
+	}
+
+	v.cursor.y = 3
+	v.RevertHunk()
+	if got := buf.String(); got != "zero\none\ntwo\nthree\nfour\n" {
+		t.Errorf("Reverting the change gave %q", got)
+	}
+	if got := buf.GitHunks(true); len(got) != 1 {
+		t.Errorf("The hunks after reverting are %v", got)
+	}
+	buf.eh.Undo()
+	if got := buf.Line(3); got != "FOURfour" {
+		t.Errorf("Undoing the revert gave %q", got)
+	}
+
+	// Saving makes the repository dirty
+	buf.Save()
+	UpdateGit(buf)
+	if got := buf.GitStatusLine(); got != "topic*" {
+		t.Errorf("The status after saving is %q, want topic*", got)
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// grepResultRegex matches a line of the grep results buffer, which starts
+// with the file, line and column of the match
+var grepResultRegex = regexp.MustCompile(`^(.+?):(\d+):(\d+): `)
+
+// An ignoreRule is a pattern from a .gitignore file
+type ignoreRule struct {
+	pattern string
+	// A rule starting with ! un-ignores what an earlier rule ignored
+	negate bool
+	// A rule ending with / only matches directories
+	dirOnly bool
+	// A rule with a / in it matches the path from the directory of the
+	// .gitignore, otherwise it matches the name at any depth
+	anchored bool
+}
+
+// A gitignore holds the rules of the .gitignore file in dir
+type gitignore struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// parseGitignore parses the rules in the text of a .gitignore file
+func parseGitignore(text string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// matches returns whether the rule matches rel, a slash separated path from
+// the directory of the .gitignore
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		rel = path.Base(rel)
+	}
+	return matchGlob(strings.Split(r.pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchGlob matches the parts of a path against the parts of a pattern
+// A ** part matches any number of parts, and the other parts are matched
+// with path.Match
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// isIgnored returns whether the file at path is ignored by the .gitignore
+// files of the directories it is in
+// The .gitignore files must be in order from the top directory down, so that
+// the rules of a deeper one win
+func isIgnored(ignores []gitignore, file string, isDir bool) bool {
+	ignored := false
+	for _, g := range ignores {
+		rel, err := filepath.Rel(g.dir, file)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		for _, r := range g.rules {
+			if r.matches(rel, isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// WalkProject calls fn for every file under root, except for the files which
+// are ignored by a .gitignore and the .git directory
+// Files and directories which can't be read are skipped
+// If fn returns an error the walk stops, and WalkProject returns the error
+func WalkProject(root string, fn func(file string) error) error {
+	var ignores []gitignore
+	return filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if file == root {
+				return err
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if file != root && (info.Name() == ".git" || isIgnored(ignores, file, true)) {
+				return filepath.SkipDir
+			}
+			if data, err := ioutil.ReadFile(filepath.Join(file, ".gitignore")); err == nil {
+				ignores = append(ignores, gitignore{file, parseGitignore(string(data))})
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() && !isIgnored(ignores, file, false) {
+			return fn(file)
+		}
+		return nil
+	})
+}
+
+// readTextFile reads a file, and returns false if it looks like a binary file
+func readTextFile(file string) (string, bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	// Like git, a file with a null byte near the start is taken to be binary
+	if bytes.IndexByte(data[:Min(len(data), 8000)], 0) >= 0 {
+		return "", false
+	}
+	return string(data), true
+}
+
+// A GrepResult is a line of a file which matches the regex of a grep
+// The line and the column of the match start at 0
+type GrepResult struct {
+	path string
+	line int
+	col  int
+	text string
+}
+
+// Grep returns the lines of the files under root which match the regex
+// Like the search, empty matches are left out
+func Grep(r *regexp.Regexp, root string) ([]GrepResult, error) {
+	var results []GrepResult
+	err := WalkProject(root, func(file string) error {
+		text, ok := readTextFile(file)
+		if !ok {
+			return nil
+		}
+		for i, line := range strings.Split(text, "\n") {
+			for _, m := range r.FindAllStringIndex(line, -1) {
+				if m[1] > m[0] {
+					results = append(results, GrepResult{file, i, Count(line[:m[0]]), line})
+					break
+				}
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// FormatGrepResults returns the text of the results buffer, with a line for
+// every result like file:line:col: text
+func FormatGrepResults(search string, results []GrepResult) string {
+	files := make(map[string]bool)
+	for _, r := range results {
+		files[r.path] = true
+	}
+	header := strconv.Itoa(len(results)) + " matches of " + search + " in " + strconv.Itoa(len(files)) +
+		" files, press enter to jump to the one under the cursor"
+	lines := []string{header, ""}
+	for _, r := range results {
+		lines = append(lines, r.path+":"+strconv.Itoa(r.line+1)+":"+strconv.Itoa(r.col+1)+": "+strings.TrimSpace(r.text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GrepCommand searches the files under a directory (the current one by
+// default) for a regex, and shows the results in a split below the view
+func GrepCommand(view *View, args []string) {
+	args = ParseArgs(args)
+	if len(args) < 1 || len(args) > 2 {
+		messenger.Error("Invalid grep statement, please use grep \"search\" [dir]")
+		return
+	}
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	r, err := SearchRegex(args[0])
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	results, err := Grep(r, dir)
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	if len(results) == 0 {
+		messenger.Message("Nothing matched " + args[0])
+		return
+	}
+
+	buf := NewBuffer(FormatGrepResults(args[0], results), "")
+	buf.name = "grep " + args[0]
+	buf.grepResults = true
+	view.HSplit(buf)
+	CurView().cursor.y = 2
+}
+
+// JumpToResult opens the file of the grep result under the cursor at the
+// match, in the view above the results (or in this view if it is the only one)
+func (v *View) JumpToResult() bool {
+	m := grepResultRegex.FindStringSubmatch(v.buf.Line(v.cursor.y))
+	if m == nil {
+		return false
+	}
+	buf, err := OpenBuffer(m[1])
+	if err != nil {
+		messenger.Error(err.Error())
+		return true
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+
+	t := CurTab()
+	target := v
+	for i, view := range t.views {
+		if view == v && len(t.views) > 1 {
+			target = t.views[(i+len(t.views)-1)%len(t.views)]
+		}
+	}
+	if target.buf != buf {
+		target.SetBuffer(buf)
+	}
+	target.cursor.ResetSelection()
+	target.cursor.y = Min(line-1, buf.NumLines()-1)
+	target.cursor.x = Min(col-1, Count(buf.Line(target.cursor.y)))
+	target.cursor.lastVisualX = target.cursor.GetVisualX()
+	target.Relocate()
+	target.matches = Match(target)
+	t.SetCurView(target)
+	return true
+}
+
+// A LineChange replaces the text of a line
+type LineChange struct {
+	line     int
+	old, new string
+}
+
+// A FileChange is the lines of a file which a grepreplace changes, in order
+type FileChange struct {
+	path  string
+	lines []LineChange
+}
+
+// FindFileChanges replaces the matches of the regex in the files under root,
+// and returns the lines which change without writing them
+// The replacement can use the groups of the match, like the replace command
+func FindFileChanges(r *regexp.Regexp, replace, root string) ([]FileChange, error) {
+	var changes []FileChange
+	err := WalkProject(root, func(file string) error {
+		text, ok := readTextFile(file)
+		if !ok {
+			return nil
+		}
+		change := FileChange{path: file}
+		for i, line := range strings.Split(text, "\n") {
+			if newLine := r.ReplaceAllString(line, replace); newLine != line {
+				change.lines = append(change.lines, LineChange{i, line, newLine})
+			}
+		}
+		if len(change.lines) > 0 {
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// FormatFileChanges returns a diff of the changes, for the user to look over
+// before they are written
+func FormatFileChanges(changes []FileChange) string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, "--- "+c.path, "+++ "+c.path)
+		for _, l := range c.lines {
+			n := strconv.Itoa(l.line + 1)
+			lines = append(lines, "@@ -"+n+" +"+n+" @@", "-"+l.old)
+			for _, newLine := range strings.Split(l.new, "\n") {
+				lines = append(lines, "+"+newLine)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// openBufferAt returns the open buffer of the file at path, or nil if the
+// file isn't open
+func openBufferAt(file string) *Buffer {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil
+	}
+	for _, b := range buffers {
+		if b.path == "" {
+			continue
+		}
+		if bAbs, err := filepath.Abs(b.path); err == nil && bAbs == abs {
+			return b
+		}
+	}
+	return nil
+}
+
+// ApplyFileChanges writes the changes to the files
+// A file which is open is changed in its buffer, so that the change can be
+// undone, and the buffer is saved
+// A file is left alone if it has changed since the changes were found, or if
+// its buffer has unsaved changes
+// It returns the number of files which were written, and the errors of the others
+func ApplyFileChanges(changes []FileChange) (int, []error) {
+	written := 0
+	var errs []error
+	for _, c := range changes {
+		var err error
+		if buf := openBufferAt(c.path); buf != nil {
+			err = applyToBuffer(buf, c)
+		} else {
+			err = applyToFile(c)
+		}
+		if err != nil {
+			errs = append(errs, errors.New(c.path+": "+err.Error()))
+		} else {
+			written++
+		}
+	}
+	return written, errs
+}
+
+// errFileChanged is the error for a file which changed after a grepreplace
+// found the lines to replace in it
+var errFileChanged = errors.New("the file has changed since the search")
+
+// editViews returns the views which show the buffer, and gets the buffer ready
+// to be edited by something other than the user
+// The events are made at the cursor of a view of the buffer, or without a
+// cursor if the buffer isn't shown anywhere
+func editViews(buf *Buffer) []*View {
+	var views []*View
+	for _, t := range tabs {
+		for _, v := range t.views {
+			if v.buf == buf {
+				views = append(views, v)
+			}
+		}
+	}
+	buf.eh.v = nil
+	if len(views) > 0 {
+		buf.eh.v = views[0]
+	}
+	return views
+}
+
+// applyToBuffer makes the changes in an open buffer as a single undo, and saves it
+func applyToBuffer(buf *Buffer, c FileChange) error {
+	if buf.IsDirty() {
+		return errors.New("the buffer has unsaved changes")
+	}
+	for _, l := range c.lines {
+		if l.line >= buf.NumLines() || buf.Line(l.line) != l.old {
+			return errFileChanged
+		}
+	}
+
+	views := editViews(buf)
+	buf.eh.BeginGroup()
+	// Go up from the bottom, in case a replacement adds lines
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		l := c.lines[i]
+		start := ToCharPos(0, l.line, buf)
+		if l.old != "" {
+			buf.eh.Remove(start, start+Count(l.old))
+		}
+		if l.new != "" {
+			buf.eh.Insert(start, l.new)
+		}
+	}
+	buf.eh.EndGroup()
+	for _, v := range views {
+		v.Clamp()
+		v.UpdateLines(v.topline, v.topline+v.height)
+	}
+	return buf.Save()
+}
+
+// applyToFile makes the changes in a file which isn't open
+func applyToFile(c FileChange) error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, l := range c.lines {
+		if l.line >= len(lines) || lines[l.line] != l.old {
+			return errFileChanged
+		}
+		lines[l.line] = l.new
+	}
+	return ioutil.WriteFile(c.path, []byte(strings.Join(lines, "\n")), info.Mode())
+}
+
+// GrepReplace replaces the matches of a regex in the files under a directory
+// (the current one by default)
+// The changes are shown as a diff in a split, and only written if the user agrees
+func GrepReplace(view *View, args []string) {
+	args = ParseArgs(args)
+	if len(args) < 2 || len(args) > 3 {
+		messenger.Error("Invalid grepreplace statement, please use grepreplace \"search\" \"value\" [dir]")
+		return
+	}
+	dir := "."
+	if len(args) == 3 {
+		dir = args[2]
+	}
+	r, err := SearchRegex(args[0])
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	changes, err := FindFileChanges(r, args[1], dir)
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	if len(changes) == 0 {
+		messenger.Message("Nothing matched " + args[0])
+		return
+	}
+
+	preview := NewBuffer(FormatFileChanges(changes), "")
+	preview.name = "grepreplace " + args[0]
+	view.HSplit(preview)
+	answer, ok := messenger.LetterPrompt("Write the changes to "+strconv.Itoa(len(changes))+" files? (y,n)", 'y', 'n')
+	CurView().Quit()
+	if !ok || answer != 'y' {
+		messenger.Message("Nothing was written")
+		return
+	}
+
+	written, errs := ApplyFileChanges(changes)
+	if len(errs) > 0 {
+		messenger.Error("Wrote " + strconv.Itoa(written) + " files, but " + strconv.Itoa(len(errs)) +
+			" could not be written: " + errs[0].Error())
+		return
+	}
+	messenger.Message("Wrote the changes to " + strconv.Itoa(written) + " files")
+}
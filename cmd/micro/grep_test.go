@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+// writeProject writes the files, given by their slash separated paths, under dir
+func writeProject(t *testing.T, dir string, files map[string]string) {
+	for name, text := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, rel string
+		isDir, want  bool
+	}{
+		{"*.log", "a/b/x.log", false, true},
+		{"*.log", "a/b/x.go", false, false},
+		{"/build", "build", true, true},
+		{"/build", "a/build", true, false},
+		{"build/", "a/build", true, true},
+		{"build/", "a/build", false, false},
+		{"docs/*.md", "docs/a.md", false, true},
+		{"docs/*.md", "docs/x/a.md", false, false},
+		{"**/gen", "a/b/gen", true, true},
+		{"a/**/z", "a/z", false, true},
+		{"a/**/z", "a/b/c/z", false, true},
+	}
+	for _, test := range tests {
+		rules := parseGitignore(test.pattern)
+		if got := rules[0].matches(test.rel, test.isDir); got != test.want {
+			t.Errorf("%q matching %q gave %v, want %v", test.pattern, test.rel, got, test.want)
+		}
+	}
+}
+
+func TestWalkProject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProject(t, dir, map[string]string{
+		".gitignore":       "# build output\n*.log\n!keep.log\nbuild/\n",
+		".git/config":      "",
+		"main.go":          "",
+		"x.log":            "",
+		"keep.log":         "",
+		"build/out":        "",
+		"sub/.gitignore":   "*.tmp\n",
+		"sub/a.tmp":        "",
+		"sub/a.go":         "",
+		"other/a.tmp":      "",
+		"other/debug.log":  "",
+		"other/build/file": "",
+	})
+
+	var files []string
+	if err := WalkProject(dir, func(file string) error {
+		rel, _ := filepath.Rel(dir, file)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	want := []string{".gitignore", "keep.log", "main.go", "other/a.tmp", "sub/.gitignore", "sub/a.go"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Walking the project gave %v, want %v", files, want)
+	}
+}
+
+func TestGrep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProject(t, dir, map[string]string{
+		"a.txt":   "foo\n\té foo bar\n",
+		"bin.dat": "foo\x00",
+	})
+
+	results, err := Grep(regexp.MustCompile("foo|x*"), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "a.txt")
+	want := []GrepResult{{file, 0, 0, "foo"}, {file, 1, 3, "\té foo bar"}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("Grep gave %v, want %v", results, want)
+	}
+
+	m := grepResultRegex.FindStringSubmatch("a:b.go:12:3: x := 1")
+	if m == nil || m[1] != "a:b.go" || m[2] != "12" || m[3] != "3" {
+		t.Errorf("Parsing a result line gave %q", m)
+	}
+}
+
+func TestGrepReplace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-grep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeProject(t, dir, map[string]string{
+		"a.txt": "x1 y\nz\nx2\n",
+		"b.txt": "x3",
+		"c.txt": "nothing",
+	})
+
+	changes, err := FindFileChanges(regexp.MustCompile(`x(\d)`), "<$1>", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("There are changes to %d files, want 2", len(changes))
+	}
+	want := []LineChange{{0, "x1 y", "<1> y"}, {2, "x2", "<2>"}}
+	if !reflect.DeepEqual(changes[0].lines, want) {
+		t.Errorf("The changes to a.txt are %v, want %v", changes[0].lines, want)
+	}
+
+	// b.txt is open in a buffer, so it is changed there and saved
+	buffers = nil
+	defer func() { buffers = nil }()
+	buf, err := OpenBuffer(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written, errs := ApplyFileChanges(changes)
+	if written != 2 || len(errs) != 0 {
+		t.Fatalf("Applying the changes wrote %d files with errors %v", written, errs)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "<1> y\nz\n<2>\n" {
+		t.Errorf("a.txt is %q after the replace", data)
+	}
+	if got := buf.String(); got != "<3>" || buf.IsDirty() {
+		t.Errorf("The buffer of b.txt is %q after the replace, dirty: %v", got, buf.IsDirty())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "b.txt")); string(data) != "<3>" {
+		t.Errorf("b.txt is %q after the replace", data)
+	}
+	// The buffer isn't shown, so it was edited without a view, and the change
+	// can be undone once it is shown
+	if buf.eh.v != nil {
+		t.Error("The buffer was edited with a made up view")
+	}
+	v := &View{buf: buf}
+	v.cursor.v = v
+	buf.eh.v = v
+	buf.eh.Undo()
+	if got := buf.String(); got != "x3" {
+		t.Errorf("Undoing the replace gave %q", got)
+	}
+	buf.eh.Redo()
+	buf.Save()
+
+	// The files have changed, so applying the changes again does nothing
+	if written, errs := ApplyFileChanges(changes); written != 0 || len(errs) != 2 {
+		t.Errorf("Applying the changes again wrote %d files with errors %v", written, errs)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	got := ParseArgs([]string{`"a`, `b"`, `c`, `""`, `"d\"e"`})
+	want := []string{"a b", "c", "", `d"e`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseArgs gave %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+)
+
+// The kinds of gutter messages, from the least to the most serious
+const (
+	GutterInfo = iota
+	GutterWarning
+	GutterError
+)
+
+// A GutterMessage flags a line of a buffer, like a diagnostic of a language
+// server, with a marker in the gutter
+type GutterMessage struct {
+	line int
+	msg  string
+	kind int
+}
+
+// SetGutterMessages replaces the gutter messages of an owner, like "linter",
+// in the buffer
+func (b *Buffer) SetGutterMessages(owner string, msgs []GutterMessage) {
+	if b.messages == nil {
+		b.messages = make(map[string][]GutterMessage)
+	}
+	b.messages[owner] = msgs
+}
+
+// GutterMessages returns the most serious message for each flagged line of
+// the buffer, from every owner and from the language server
+func (b *Buffer) GutterMessages() map[int]GutterMessage {
+	msgs := make(map[int]GutterMessage)
+	add := func(m GutterMessage) {
+		if old, ok := msgs[m.line]; !ok || m.kind > old.kind {
+			msgs[m.line] = m
+		}
+	}
+	for _, owned := range b.messages {
+		for _, m := range owned {
+			add(m)
+		}
+	}
+	if b.lsp != nil {
+		for _, m := range b.lsp.GutterMessages(b) {
+			add(m)
+		}
+	}
+	return msgs
+}
+
+// DisplayGutterMessage shows the gutter message of the line the cursor moved
+// to in the messenger, and takes it away when the cursor leaves the line
+// Other messages are left alone
+func (v *View) DisplayGutterMessage() {
+	m, ok := v.buf.GutterMessages()[v.cursor.y]
+	if ok && (m.msg != v.gutterMessage || v.cursor.y != v.gutterLine) {
+		if m.kind == GutterError {
+			messenger.Error(m.msg)
+		} else {
+			messenger.Message(m.msg)
+		}
+		v.gutterMessage, v.gutterLine = m.msg, v.cursor.y
+	} else if !ok && v.gutterMessage != "" {
+		if messenger.message == v.gutterMessage {
+			messenger.Message("")
+		}
+		v.gutterMessage = ""
+	}
+}
+
+// GutterStyle returns the style of the marker for a kind of gutter message
+func GutterStyle(kind int) tcell.Style {
+	switch kind {
+	case GutterError:
+		if style, ok := colorscheme["gutter-error"]; ok {
+			return style
+		}
+		return defStyle.Foreground(tcell.ColorRed)
+	case GutterWarning:
+		if style, ok := colorscheme["gutter-warning"]; ok {
+			return style
+		}
+		return defStyle.Foreground(tcell.ColorYellow)
+	}
+	if style, ok := colorscheme["gutter-info"]; ok {
+		return style
+	}
+	return defStyle.Foreground(tcell.ColorBlue)
+}
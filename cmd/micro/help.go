@@ -5,47 +5,136 @@ import (
 	"strings"
 )
 
-const helpTxt = `Press Ctrl-q to quit help
+// helpTxt is the help text shown after the list of key bindings
+const helpTxt = `In the command prompt, and the prompts for a file name, tab completes the
+command, option, value or file before the cursor. If there are several
+completions they are listed above the prompt, and pressing tab again goes
+through them. Up and down go through what you typed in earlier prompts of the
+same kind, and in earlier searches. This history is kept in $(configDir)/history.
 
-Micro keybindings:
+While typing in a prompt, Home and End (or Ctrl-a and Ctrl-e) go to the start
+and the end, Ctrl-Left and Ctrl-Right (or Alt-b and Alt-f) move by a word,
+Delete deletes the character after the cursor, Ctrl-w deletes the word before
+it, Ctrl-u and Ctrl-k delete everything before or after it, and Ctrl-v pastes.
+Clicking in the prompt moves the cursor there.
 
-Ctrl-q:   Quit
-Ctrl-s:   Save
-Ctrl-o:   Open file
+Possible commands:
 
-Ctrl-z:   Undo
-Ctrl-y:   Redo
+'quit': Quits micro (or closes the current split)
+'quitall': Quits micro, closing every split and tab
+'save': saves the current buffer
 
-Ctrl-f:   Find
-Ctrl-n:   Find next
-Ctrl-p:   Find previous
+'tabnew [file]': opens 'file', or an empty buffer, in a new tab
+'bnext': shows the next open buffer in the current split
+'bprev': shows the previous open buffer in the current split
+'buffers': lists the open buffers
+'buffer n': shows buffer number 'n' in the current split
 
-Ctrl-a:   Select all
+'earlier n': goes back 'n' changes in time, even across branches of the undo tree
+	'n' can also be a duration such as 30s, 5m or 1h
+'later n': goes forward 'n' changes (or a duration) in time
+'undotree': shows the branches of the undo tree
 
-Ctrl-c:   Copy
-Ctrl-x:   Cut
-Ctrl-v:   Paste
+'vsplit [file]': opens a vertical split with 'file', or the current buffer
+'hsplit [file]': opens a horizontal split with 'file', or the current buffer
+'resize n': grows the current split by 'n' percent (shrinks it if 'n' is negative)
 
-Ctrl-g:   Open this help screen
+'replace "search" "value" [flags]': This will replace 'search' with 'value'.
+Note that 'search' must be a valid regex.  If one of the arguments
+does not have any spaces in it, you may omit the quotes.
+'value' can use the groups of the match with $1, ${1} or ${name}.
+If there is a selection, only the matches inside it are replaced.
+The flag 'c' asks before each replacement: y replaces it, n skips it,
+a replaces it and all the rest, and q stops.
+The whole replace is undone in one step.
 
-Ctrl-u:   Half page up
-Ctrl-d:   Half page down
-PageUp:   Page up
-PageDown: Page down
+'grep "search" [dir]': searches the files under 'dir', or the current directory,
+for the regex 'search', and lists the matching lines in a split below.
+Press enter on a line of the list to jump to the match.
+Files ignored by a .gitignore are skipped, and the search options
+(ignorecase, literalsearch...) apply.
+'grepreplace "search" "value" [dir]': replaces 'search' with 'value' in the files
+under 'dir'. A diff of the changes is shown first, and nothing is written
+unless you agree. Open files are changed in their buffers, so that the
+change can be undone, and files with unsaved changes are skipped.
 
-Home:     Go to beginning
-End:      Go to end
+'run command': runs 'command' in the shell. Output which fits on one line is
+shown at the bottom, and longer output in a split below.
+'filter command': pipes the selection, or the whole buffer if nothing is
+selected, through 'command' in the shell, and replaces it with the output.
+The change is undone in one step, and nothing changes if the command fails.
+'!command': filters the selection through 'command', or runs it if nothing is
+selected.
+'read !command': inserts the output of 'command' at the cursor.
+'read file': inserts the contents of 'file' at the cursor.
 
-Ctrl-e:   Execute a command
+'term [command]': opens a terminal in a split below, running 'command' or your
+$SHELL. Keys are sent to the shell, except Ctrl-w, which moves to the next
+split, and the keys for switching tabs. After Ctrl-\, the next key does what it
+does in the editor: Ctrl-\ Ctrl-e opens the command prompt and Ctrl-\ Ctrl-q
+closes the terminal. The mouse wheel scrolls back through the output. Once the
+shell exits, any key closes the terminal.
+'termcopy': opens the output of the terminal in the tab, with what scrolled off
+the top, in a new buffer, so that it can be searched, copied or saved.
 
-Possible commands:
+'rename name': renames the symbol under the cursor to 'name' in every file,
+using the language server. Open files are changed in their buffers.
 
-'quit': Quits micro
-'save': saves the current buffer
+Language servers give micro an understanding of the code. When a file is opened,
+the server for its filetype is started if it is installed: gopls for Go,
+pyright-langserver for Python, clangd for C, rust-analyzer for Rust,
+typescript-language-server for JavaScript and bash-language-server for SH.
+Other servers are set in $(configDir)/lsp.json, which maps filetypes to
+commands like {"Python": ["pylsp"]}, and [] turns a filetype's server off.
+The lines with errors and warnings are marked with >> in a gutter, in the
+'gutter-error', 'gutter-warning' and 'gutter-info' colorscheme groups. F12
+goes to a definition, Alt-h shows the type and documentation of a symbol, and
+Ctrl-Space opens a list of completions, which are picked with up, down and
+enter (colorscheme groups 'completion' and 'completion-selected').
 
-'replace "search" "value"': This will replace 'search' with 'value'.
-Note that 'search' must be a valid regex.  If one of the arguments
-does not have any spaces in it, you may omit the quotes.
+'lint': lints the current file, and flags the lines with problems in the gutter.
+Files are also linted when they are saved. The linters are go vet for Go,
+shellcheck for SH, pyflakes for Python and eslint for JavaScript, when they
+are installed. Others are set in $(configDir)/linters.json, which maps
+filetypes to shell commands like {"Python": "flake8 %f"}, where %f is the
+file's name. The command runs in the file's directory, and its output lines
+like file:line:col: message flag the lines. "" turns a filetype's linter off.
+The message of a flagged line is shown at the bottom when the cursor is on it.
+
+'fmt': formats the current buffer. With the formatonsave option, buffers are
+also formatted when they are saved. The formatters are gofmt for Go, black for
+Python, prettier for JavaScript, rustfmt for Rust and clang-format for C, when
+they are installed. Others are set in $(configDir)/formatters.json, which maps
+filetypes to shell commands like {"Python": "autopep8 -"}, which read the text
+and write it formatted. %f is the file's name, and "" turns a filetype's
+formatter off. Only the lines which change are replaced, so the cursor stays on
+its line and the formatting is undone in one step. A formatter which takes more
+than 5 seconds is stopped.
+
+In a git repository, the status line shows the branch, with a * when there are
+changes which aren't committed, and the gutter marks the lines which differ
+from HEAD: + for added lines, ~ for modified ones, and _ for deleted lines
+below (colorscheme groups 'diff-added', 'diff-modified' and 'diff-deleted').
+'nexthunk' and 'prevhunk' (Alt-Down and Alt-Up) go to the next and previous
+change, and 'reverthunk' puts back the lines of HEAD in the change under the
+cursor.
+
+'diff [file]': compares the current buffer with 'file', or with the file as it
+was saved, side by side in a new tab ('micro -diff a b' starts with two files
+compared). The views scroll together, and the lines which differ are
+highlighted: the lines only on the left with the 'diff-line-deleted'
+colorscheme group, the ones only on the right with 'diff-line-added', and the
+modified ones with 'diff-line-changed', where the part which changed is in
+'diff-text-changed'. The gutter marks them like the changes from git, and
+'nexthunk' and 'prevhunk' (Alt-Down and Alt-Up) go through them.
+'diffget': replaces the change under the cursor with the other side's lines.
+'diffput': replaces the other side's lines with the change under the cursor.
+
+The file finder (Alt-o) lists the files under the current directory, leaving out
+the ones ignored by a .gitignore. Type parts of the path of a file, in order, to
+narrow the list down: 'vwgo' finds view.go. Up and down select a file, which is
+previewed on the right, and enter opens it. The matched characters are
+highlighted with the 'finder-match' colorscheme group.
 
 'set option value': sets the option to value. Please see the next section for a list of options you can set
 
@@ -67,17 +156,98 @@ tabsize: sets the tab size to 'option'
 syntax: turns syntax on or off
 	default value: 'on'
 
+autoindent: new lines start with the indentation of the line above, and one more
+	level after a line which opens a block (like a line ending with '{')
+	default value: 'on'
+
 tabsToSpaces: use spaces instead of tabs
+	With this on, backspace in the indentation of a line deletes a whole level
+	default value: 'off'
+
+persistentundo: saves the undo history of each file in $(configDir)/undo, so that
+	it can still be undone after micro is closed and the file is opened again
+	default value: 'on'
+
+ignorecase: the search ignores case
+	While searching, Alt-c toggles this
+	default value: 'off'
+
+smartcase: with ignorecase on, a search with capital letters in it doesn't ignore case
+	default value: 'on'
+
+literalsearch: the search is for the text itself instead of a regex
+	While searching, Alt-r toggles this
 	default value: 'off'
+
+wholeword: the search only matches whole words
+	While searching, Alt-w toggles this
+	default value: 'off'
+
+The matches of the search are highlighted with the 'search-match' colorscheme group.
+
+softwrap: wraps lines which are wider than the view onto the next rows of the
+	screen, instead of scrolling horizontally
+	default value: 'off'
+
+formatonsave: formats buffers with the formatter of their filetype when they
+	are saved (see the 'fmt' command)
+	default value: 'off'
+
+Plugins:
+
+Micro loads Lua plugins from $(configDir)/plugins. A plugin is either a file 'name.lua'
+or a directory 'name' containing 'name.lua'.
+
+A plugin can define these hooks:
+
+onSave(path): called after a buffer is saved
+onOpen(path): called when a buffer is opened, before it is shown
+	The micro functions work on the buffer being opened, but micro.run runs in the
+	focused view, and the buffer has no cursor or selection yet
+onKey(key): called for every key press, with a name like 'Ctrl-K'
+	If it returns true, micro does not handle the key
+
+Plugins use the 'micro' table to control the editor:
+
+micro.message(msg), micro.error(msg): show a message
+micro.command(name, fn): adds a command, fn is called with a table of the arguments
+micro.bind(key, fn): calls fn when the key (like 'Ctrl-K' or 'Alt-x') is pressed
+micro.run(command): runs a command
+micro.path(), micro.filetype(): the current buffer's path and filetype
+micro.text(), micro.line(y), micro.numlines(): the current buffer's text
+micro.insert(x, y, text), micro.remove(x1, y1, x2, y2): edit the current buffer
+micro.cursor(), micro.setcursor(x, y): get and set the cursor location
+micro.selection(): the selected text
+
+Locations start at 0.
+
+Key bindings:
+
+The key bindings can be changed in $(configDir)/bindings.json, which maps key names to actions:
+
+{
+    "Ctrl-K": "Cut",
+    "Alt-Up": "PageUp",
+    "Ctrl-Y": ""
+}
+
+Key names can use the modifiers Ctrl-, Alt- and Shift-. Binding a key to "" removes its binding.
+The actions are:
 `
 
+// HelpText returns the whole help text, listing the current key bindings
+func HelpText() string {
+	return "Press Ctrl-q to quit help\n\nMicro keybindings:\n\n" + BindingsHelp() + "\n\n" + helpTxt +
+		strings.Join(ActionNames(), ", ")
+}
+
 // DisplayHelp displays the help txt
 // It blocks the main loop
 func DisplayHelp() {
 	topline := 0
 	_, height := screen.Size()
 	screen.HideCursor()
-	totalLines := strings.Split(helpTxt, "\n")
+	totalLines := strings.Split(HelpText(), "\n")
 	for {
 		screen.Clear()
 
@@ -95,7 +265,7 @@ func DisplayHelp() {
 
 		screen.Show()
 
-		event := screen.PollEvent()
+		event := PollEvent()
 		switch e := event.(type) {
 		case *tcell.EventResize:
 			_, height = e.Size()
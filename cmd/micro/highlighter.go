@@ -23,6 +23,9 @@ type SyntaxRule struct {
 	flags string
 	// Whether this regex is a start=... end=... regex
 	startend bool
+	// The regexes for the start and the end of the region, for start=... end=... regexes
+	start *regexp.Regexp
+	end   *regexp.Regexp
 	// How to highlight it
 	style tcell.Style
 }
@@ -307,7 +310,7 @@ func LoadRulesFromFile(text, filename string) []SyntaxRule {
 			}
 			// Add the regex, flags, and style
 			// False because this is not start-end
-			rules = append(rules, SyntaxRule{regex, flags, false, st})
+			rules = append(rules, SyntaxRule{regex: regex, flags: flags, style: st})
 		} else if ruleStartEndParser.MatchString(line) {
 			// Start-end syntax rule
 			submatch := ruleStartEndParser.FindSubmatch([]byte(line))
@@ -333,8 +336,15 @@ func LoadRulesFromFile(text, filename string) []SyntaxRule {
 				continue
 			}
 
-			// Compile the regex
-			regex, err := regexp.Compile("(?" + flags + ")" + "(" + start + ").*?(" + end + ")")
+			// Compile the regexes
+			// Regions are highlighted one line at a time, so the start and the end are
+			// matched separately
+			startRegex, err := regexp.Compile("(?" + flags + ")" + start)
+			if err != nil {
+				TermError(filename, lineNum, err.Error())
+				continue
+			}
+			endRegex, err := regexp.Compile("(?" + flags + ")" + end)
 			if err != nil {
 				TermError(filename, lineNum, err.Error())
 				continue
@@ -352,7 +362,7 @@ func LoadRulesFromFile(text, filename string) []SyntaxRule {
 			}
 			// Add the regex, flags, and style
 			// True because this is start-end
-			rules = append(rules, SyntaxRule{regex, flags, true, st})
+			rules = append(rules, SyntaxRule{flags: flags, startend: true, start: startRegex, end: endRegex, style: st})
 		}
 	}
 	return rules
@@ -365,7 +375,7 @@ func GetRules(buf *Buffer) ([]SyntaxRule, string) {
 		if r[0] != nil && r[0].MatchString(buf.path) {
 			// Check if the syntax statement matches the extension
 			return LoadRulesFromFile(syntaxFiles[r].text, syntaxFiles[r].filename), syntaxFiles[r].filetype
-		} else if r[1] != nil && r[1].MatchString(buf.lines[0]) {
+		} else if r[1] != nil && r[1].MatchString(buf.Line(0)) {
 			// Check if the header statement matches the first line
 			return LoadRulesFromFile(syntaxFiles[r].text, syntaxFiles[r].filename), syntaxFiles[r].filetype
 		}
@@ -377,85 +387,262 @@ func GetRules(buf *Buffer) ([]SyntaxRule, string) {
 // so map[3] represents the style of the third character
 type SyntaxMatches [][]tcell.Style
 
-// Match takes a buffer and returns the syntax matches a map specifying how it should be syntax highlighted
-// We need to check the start-end regexes for the entire buffer every time Match is called, but for the
-// non start-end rules, we only have to update the updateLines provided by the view
+// Match returns the syntax highlighting of the lines shown in the view
+// The lines in v.updateLines are highlighted again, and the others come from
+// the buffer's highlighter, which only highlights lines that have changed
 func Match(v *View) SyntaxMatches {
-	buf := v.buf
-	rules := v.buf.rules
+	h := v.buf.highlighter
+	h.Invalidate(v.updateLines[0], v.updateLines[1])
+	v.updateLines = [2]int{0, 0}
 
-	viewStart := v.topline
 	viewEnd := v.topline + v.height
-	if viewEnd > len(buf.lines) {
-		viewEnd = len(buf.lines)
+	if viewEnd > v.buf.NumLines() {
+		viewEnd = v.buf.NumLines()
+	}
+	return h.Highlight(v.topline, viewEnd)
+}
+
+// A Highlighter highlights the lines of a buffer and caches the result
+// Start-end rules (like block comments) can span multiple lines, so the highlighter
+// also stores the region each line starts in. When a line is edited only that line
+// is highlighted again, and the lines after it only if the region they start in changed
+// Only the regions are kept for the lines which are off the screen, so that a big
+// file doesn't keep the styles of every character
+type Highlighter struct {
+	buf *Buffer
+
+	// The index of the start-end rule whose region each line starts in,
+	// or -1 if it doesn't start in a region
+	// Only the lines from the top of the buffer to the last line that was
+	// shown are stored, and one more: the state of the first line which hasn't
+	// been highlighted yet
+	states []int
+	// Whether each line must be highlighted again, for every line in states
+	// but the last
+	stale []bool
+	// The lines before valid are highlighted correctly
+	valid int
+
+	// The styles of every character (and one more for the newline) of the
+	// lines from top which were last shown, or nil if the line must be
+	// highlighted again
+	top    int
+	styles [][]tcell.Style
+}
+
+// NewHighlighter returns a new highlighter for the buffer
+func NewHighlighter(buf *Buffer) *Highlighter {
+	h := new(Highlighter)
+	h.buf = buf
+	h.Reset()
+	return h
+}
+
+// Reset throws away all the highlighting, for example when the rules have changed
+func (h *Highlighter) Reset() {
+	h.states = []int{-1}
+	h.stale = nil
+	h.valid = 0
+	h.top, h.styles = 0, nil
+}
+
+// forget marks line y to be highlighted again
+func (h *Highlighter) forget(y int) {
+	h.stale[y] = true
+	if y >= h.top && y < h.top+len(h.styles) {
+		h.styles[y-h.top] = nil
+	}
+}
+
+// Invalidate marks the lines from start up to (but not including) end to be highlighted again
+func (h *Highlighter) Invalidate(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(h.stale) {
+		end = len(h.stale)
+	}
+	for i := start; i < end; i++ {
+		h.forget(i)
+	}
+	if start < end && start < h.valid {
+		h.valid = start
+	}
+}
+
+// Edit updates the cache after the lines after line y were changed: removed lines
+// were joined to line y, and then added new lines were inserted after it
+// Line y is highlighted again the next time it is needed
+func (h *Highlighter) Edit(y, removed, added int) {
+	if y >= len(h.stale) {
+		// Nothing after the line has been highlighted
+		return
+	}
+
+	end := y + 1 + removed
+	if end > len(h.stale) {
+		end = len(h.stale)
+	}
+	h.stale = append(h.stale[:y+1], h.stale[end:]...)
+	h.states = append(h.states[:y+1], h.states[end:]...)
+
+	if added > 0 {
+		h.stale = append(h.stale, make([]bool, added)...)
+		copy(h.stale[y+1+added:], h.stale[y+1:])
+		h.states = append(h.states, make([]int, added)...)
+		copy(h.states[y+1+added:], h.states[y+1:])
+		for i := y + 1; i <= y+added; i++ {
+			h.stale[i] = true
+			h.states[i] = -1
+		}
 	}
 
-	// updateStart := v.updateLines[0]
-	// updateEnd := v.updateLines[1]
-	//
-	// if updateEnd > len(buf.lines) {
-	// 	updateEnd = len(buf.lines)
-	// }
-	// if updateStart < 0 {
-	// 	updateStart = 0
-	// }
-	lines := buf.lines[viewStart:viewEnd]
-	// updateLines := buf.lines[updateStart:updateEnd]
-	matches := make(SyntaxMatches, len(lines))
-
-	for i, line := range lines {
-		matches[i] = make([]tcell.Style, len(line)+1)
+	// The shown lines move with the text
+	if i := y - h.top; i >= 0 && i < len(h.styles) {
+		n := len(h.styles)
+		end := Min(i+1+removed, n)
+		rest := append(make([][]tcell.Style, Min(added, n)), h.styles[end:]...)
+		h.styles = append(h.styles[:i+1], rest...)
+		if len(h.styles) > n {
+			h.styles = h.styles[:n]
+		}
+	} else if i < 0 && (removed > 0 || added > 0) {
+		h.styles = nil
 	}
 
-	// We don't actually check the entire buffer, just from synLinesUp to synLinesDown
-	totalStart := v.topline - synLinesUp
-	totalEnd := v.topline + v.height + synLinesDown
-	if totalStart < 0 {
-		totalStart = 0
+	h.forget(y)
+	if y < h.valid {
+		h.valid = y
 	}
-	if totalEnd > len(buf.lines) {
-		totalEnd = len(buf.lines)
+}
+
+// Highlight returns the styles of the lines from start up to (but not including) end
+// Any lines before end which have changed are highlighted first
+func (h *Highlighter) Highlight(start, end int) [][]tcell.Style {
+	if start >= end {
+		return nil
 	}
 
-	str := strings.Join(buf.lines[totalStart:totalEnd], "\n")
-	startNum := ToCharPos(0, totalStart, v.buf)
-
-	toplineNum := ToCharPos(0, v.topline, v.buf)
-
-	for _, rule := range rules {
-		if rule.startend {
-			if indicies := rule.regex.FindAllStringIndex(str, -1); indicies != nil {
-				for _, value := range indicies {
-					value[0] += startNum
-					value[1] += startNum
-					for i := value[0]; i < value[1]; i++ {
-						if i < toplineNum {
-							continue
-						}
-						colNum, lineNum := FromCharPosStart(toplineNum, 0, v.topline, i, buf)
-						if lineNum == -1 || colNum == -1 {
-							continue
-						}
-						lineNum -= viewStart
-						if lineNum >= 0 && lineNum < v.height {
-							matches[lineNum][colNum] = rule.style
-						}
-					}
+	// Keep the styles of the lines which are still shown
+	if start != h.top || end-start != len(h.styles) {
+		styles := make([][]tcell.Style, end-start)
+		for i := range styles {
+			if y := start + i; y >= h.top && y < h.top+len(h.styles) {
+				styles[i] = h.styles[y-h.top]
+			}
+		}
+		h.top, h.styles = start, styles
+	}
+
+	for h.valid < end {
+		i := h.valid
+		if i == len(h.stale) {
+			h.stale = append(h.stale, true)
+			// The state of the next line is set once this one is highlighted
+			h.states = append(h.states, -1)
+		}
+		if h.stale[i] {
+			styles, next := h.highlightLine(h.buf.Line(i), h.states[i])
+			h.stale[i] = false
+			if i >= start {
+				h.styles[i-start] = styles
+			}
+			if h.states[i+1] != next {
+				// The next line starts in a different region now,
+				// so it must be highlighted again too
+				h.states[i+1] = next
+				if i+1 < len(h.stale) {
+					h.forget(i + 1)
 				}
 			}
-		} else {
-			for lineN, line := range lines {
-				if indicies := rule.regex.FindAllStringIndex(line, -1); indicies != nil {
-					for _, value := range indicies {
-						for i := value[0]; i < value[1]; i++ {
-							// matches[lineN+updateStart][i] = rule.style
-							matches[lineN][i] = rule.style
-						}
-					}
+		}
+		h.valid++
+	}
+
+	// The lines which scrolled into view only need their styles, since the
+	// region they start in is known
+	for i, styles := range h.styles {
+		if styles == nil {
+			h.styles[i], _ = h.highlightLine(h.buf.Line(start+i), h.states[start+i])
+		}
+	}
+	return h.styles
+}
+
+// highlightLine highlights a line which starts in the region of the given rule
+// (or -1 for none), and returns the styles and the region the next line starts in
+func (h *Highlighter) highlightLine(line string, state int) ([]tcell.Style, int) {
+	rules := h.buf.rules
+
+	// Find the regions in the line, as byte ranges for each start-end rule
+	// The end of a region which continues on the next line is past the end
+	// of the line, so that the newline is highlighted too
+	regions := make(map[int][][2]int)
+	pos := 0
+	for pos <= len(line) {
+		if state != -1 {
+			loc := rules[state].end.FindStringIndex(line[pos:])
+			if loc == nil {
+				regions[state] = append(regions[state], [2]int{pos, len(line) + 1})
+				break
+			}
+			regions[state] = append(regions[state], [2]int{pos, pos + loc[1]})
+			pos += loc[1]
+			state = -1
+			continue
+		}
+
+		// The region which starts first wins
+		var first []int
+		for i, r := range rules {
+			if !r.startend {
+				continue
+			}
+			// A region which starts with an empty match would never end
+			if loc := r.start.FindStringIndex(line[pos:]); loc != nil && loc[1] > loc[0] {
+				if first == nil || loc[0] < first[0] {
+					first = loc
+					state = i
 				}
 			}
 		}
+		if first == nil {
+			break
+		}
+		regions[state] = append(regions[state], [2]int{pos + first[0], pos + first[1]})
+		pos += first[1]
+	}
+
+	// The regexes work with bytes, but the styles are for runes
+	// Matches always start and end at the start of a rune
+	runeIdx := make([]int, len(line)+2)
+	n := 0
+	for i := range line {
+		runeIdx[i] = n
+		n++
+	}
+	runeIdx[len(line)] = n
+	runeIdx[len(line)+1] = n + 1
+
+	styles := make([]tcell.Style, n+1)
+	fill := func(start, end int, style tcell.Style) {
+		for i := runeIdx[start]; i < runeIdx[end]; i++ {
+			styles[i] = style
+		}
+	}
+
+	// Later rules take precedence over earlier ones
+	for i, r := range rules {
+		if r.startend {
+			for _, region := range regions[i] {
+				fill(region[0], region[1], r.style)
+			}
+		} else {
+			for _, loc := range r.regex.FindAllStringIndex(line, -1) {
+				fill(loc[0], loc[1], r.style)
+			}
+		}
 	}
 
-	return matches
+	return styles, state
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"strings"
+	"testing"
+)
+
+const testSyntax = `syntax "test" "\.test$"
+color red "\bint\b"
+color blue start="/\*" end="\*/"
+`
+
+func newTestHighlightBuffer(txt string) *Buffer {
+	b := NewBuffer(txt, "")
+	b.rules = LoadRulesFromFile(testSyntax, "test.micro")
+	b.highlighter.Reset()
+	return b
+}
+
+// checkHighlighting compares the cached highlighting of the buffer with
+// the highlighting of the same text from scratch
+func checkHighlighting(t *testing.T, b *Buffer) {
+	got := b.highlighter.Highlight(0, b.NumLines())
+	want := newTestHighlightBuffer(b.String()).highlighter.Highlight(0, b.NumLines())
+	for y := range want {
+		if len(got[y]) != len(want[y]) {
+			t.Fatalf("Line %d has %d styles, want %d", y, len(got[y]), len(want[y]))
+		}
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Fatalf("Line %d column %d has the wrong style", y, x)
+			}
+		}
+	}
+}
+
+func TestHighlightRegionAcrossLines(t *testing.T) {
+	// The comment starts far above the line we look at
+	lines := []string{"/* start"}
+	for i := 0; i < 200; i++ {
+		lines = append(lines, "int x")
+	}
+	lines = append(lines, "*/ int")
+	b := newTestHighlightBuffer(strings.Join(lines, "\n"))
+
+	blue := StringToStyle("blue")
+	red := StringToStyle("red")
+
+	styles := b.highlighter.Highlight(150, 152)
+	if styles[0][0] != blue {
+		t.Error("Line 150 is not highlighted as a comment")
+	}
+	styles = b.highlighter.Highlight(201, 202)
+	if styles[0][0] != blue || styles[0][1] != blue {
+		t.Error("The end of the comment is not highlighted as a comment")
+	}
+	if styles[0][3] != red {
+		t.Error("The code after the comment is not highlighted")
+	}
+}
+
+func TestHighlightEdits(t *testing.T) {
+	b := newTestHighlightBuffer("int a\nint b\n/* c\nd */\nint é\nint f")
+	b.highlighter.Highlight(0, b.NumLines())
+
+	// Opening a comment changes the lines after it
+	b.Insert(0, "/*")
+	checkHighlighting(t, b)
+	b.Remove(0, 2)
+	checkHighlighting(t, b)
+
+	// Lines which are added or joined
+	b.Insert(ToCharPos(3, 1, b), "\n/* x\ny\n")
+	checkHighlighting(t, b)
+	b.Remove(ToCharPos(0, 1, b), ToCharPos(2, 4, b))
+	checkHighlighting(t, b)
+	b.Remove(0, b.Len())
+	checkHighlighting(t, b)
+}
+
+func TestHighlightStabilizes(t *testing.T) {
+	lines := []string{"/*"}
+	for i := 0; i < 100; i++ {
+		lines = append(lines, "int x")
+	}
+	b := newTestHighlightBuffer(strings.Join(lines, "\n"))
+	before := make([][]tcell.Style, b.NumLines())
+	copy(before, b.highlighter.Highlight(0, b.NumLines()))
+
+	// An edit inside the comment doesn't change the region of the lines after it,
+	// so only the edited line is highlighted again
+	b.Insert(ToCharPos(0, 10, b), "y")
+	after := b.highlighter.Highlight(0, b.NumLines())
+	for y := range after {
+		recomputed := &after[y][0] != &before[y][0]
+		if recomputed != (y == 10) {
+			t.Errorf("Line %d recomputed = %v", y, recomputed)
+		}
+	}
+}
+
+func TestHighlightWindow(t *testing.T) {
+	lines := []string{"/* start"}
+	for i := 0; i < 200; i++ {
+		lines = append(lines, "int x")
+	}
+	b := newTestHighlightBuffer(strings.Join(lines, "\n"))
+
+	// Only the styles of the lines which are shown are kept
+	b.highlighter.Highlight(100, 110)
+	if n := len(b.highlighter.styles); n != 10 {
+		t.Errorf("The styles of %d lines are kept, want 10", n)
+	}
+
+	// Edits above and inside the shown lines move them
+	compare := func(start, end int) {
+		got := b.highlighter.Highlight(start, end)
+		want := newTestHighlightBuffer(b.String()).highlighter.Highlight(start, end)
+		for y := range want {
+			for x := range want[y] {
+				if got[y][x] != want[y][x] {
+					t.Fatalf("Line %d column %d has the wrong style", start+y, x)
+				}
+			}
+		}
+	}
+	b.Insert(ToCharPos(0, 105, b), "*/\n")
+	compare(100, 110)
+	b.Insert(ToCharPos(0, 50, b), "int\nint\n")
+	compare(100, 110)
+	b.Remove(0, 2)
+	compare(100, 110)
+	compare(0, 20)
+}
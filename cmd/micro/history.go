@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+)
+
+// maxHistory is the number of responses kept for each type of prompt
+const maxHistory = 100
+
+// history holds the responses to each type of prompt, oldest first
+// It is saved to $(configDir)/history, so that it is kept across sessions
+var history map[string][]string
+
+// HistoryFile returns the file where the history of the prompts is stored
+func HistoryFile() string {
+	return configDir + "/history"
+}
+
+// InitHistory loads the history of the prompts
+// A history which can't be read is simply not restored
+func InitHistory() {
+	history = make(map[string][]string)
+	file, err := os.Open(HistoryFile())
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	var saved map[string][]string
+	if gob.NewDecoder(file).Decode(&saved) == nil {
+		history = saved
+	}
+}
+
+// SaveHistory writes the history of the prompts to the history file
+func SaveHistory() error {
+	file, err := os.Create(HistoryFile())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewEncoder(file).Encode(history)
+}
+
+// AddToHistory adds a response to the history of a type of prompt, and saves
+// the history
+// An earlier copy of the same response is removed, so that it moves to the end
+func AddToHistory(historyType, response string) {
+	if historyType == "" || response == "" {
+		return
+	}
+	if history == nil {
+		history = make(map[string][]string)
+	}
+	var entries []string
+	for _, entry := range history[historyType] {
+		if entry != response {
+			entries = append(entries, entry)
+		}
+	}
+	entries = append(entries, response)
+	if len(entries) > maxHistory {
+		entries = entries[len(entries)-maxHistory:]
+	}
+	history[historyType] = entries
+	if configDir != "" {
+		SaveHistory()
+	}
+}
+
+// HistoryUp replaces the response of the prompt with the previous entry of its
+// history
+// The response which was being typed is kept, so that HistoryDown can go back to it
+func (m *Messenger) HistoryUp(historyType string) {
+	entries := history[historyType]
+	if m.historyPos >= len(entries) {
+		return
+	}
+	if m.historyPos == 0 {
+		m.draft = m.input.Text()
+	}
+	m.historyPos++
+	m.input.SetText(entries[len(entries)-m.historyPos])
+}
+
+// HistoryDown replaces the response of the prompt with the next entry of its
+// history, or with the response which was being typed after the last entry
+func (m *Messenger) HistoryDown(historyType string) {
+	if m.historyPos == 0 {
+		return
+	}
+	m.historyPos--
+	if m.historyPos == 0 {
+		m.input.SetText(m.draft)
+		return
+	}
+	entries := history[historyType]
+	m.input.SetText(entries[len(entries)-m.historyPos])
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configDir = dir
+	history = nil
+
+	AddToHistory("Command", "set tabsize 2")
+	AddToHistory("Command", "vsplit")
+	AddToHistory("Command", "set tabsize 2")
+	AddToHistory("Search", "foo")
+	AddToHistory("", "not kept")
+
+	// The history is kept across sessions
+	InitHistory()
+	want := map[string][]string{"Command": {"vsplit", "set tabsize 2"}, "Search": {"foo"}}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("The history is %q, want %q", history, want)
+	}
+
+	m := new(Messenger)
+	m.input.SetText("typing")
+	m.HistoryUp("Command")
+	m.HistoryUp("Command")
+	m.HistoryUp("Command")
+	if m.input.Text() != "vsplit" {
+		t.Errorf("Going up the history gave %q", m.input.Text())
+	}
+	m.HistoryDown("Command")
+	m.HistoryDown("Command")
+	if m.input.Text() != "typing" || m.input.Cursor() != len("typing") {
+		t.Errorf("Going back down the history gave %q with the cursor at %d", m.input.Text(), m.input.Cursor())
+	}
+
+	for i := 0; i < maxHistory+10; i++ {
+		AddToHistory("Search", strconv.Itoa(i))
+	}
+	if n := len(history["Search"]); n != maxHistory {
+		t.Errorf("The history has %d entries, want %d", n, maxHistory)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+)
+
+// indentOpeners lists, for each filetype, what a line can end with to open a block
+// The line after such a line is indented one more level
+// Filetypes which aren't listed use defaultIndentOpeners
+var indentOpeners = map[string][]string{
+	"Python": {":", "{", "[", "("},
+	"YAML":   {":"},
+	"Lua":    {"then", "do", "else", "repeat", "{", "("},
+	"Ruby":   {"do", "then", "else", "{", "[", "("},
+	"SH":     {"then", "do", "else", "{", "("},
+}
+
+var defaultIndentOpeners = []string{"{", "[", "("}
+
+// IndentString returns one level of indentation
+func IndentString() string {
+	if settings.TabsToSpaces {
+		return Spaces(settings.TabSize)
+	}
+	return "\t"
+}
+
+// OpensBlock returns whether a line of the given filetype ends with
+// something which opens a block
+// Openers which are words, like 'then', must not be the end of a longer word
+func OpensBlock(filetype, line string) bool {
+	openers, ok := indentOpeners[filetype]
+	if !ok {
+		openers = defaultIndentOpeners
+	}
+
+	line = strings.TrimRight(line, " \t")
+	for _, opener := range openers {
+		if !strings.HasSuffix(line, opener) {
+			continue
+		}
+		rest := line[:len(line)-len(opener)]
+		if IsWordChar(opener[:1]) && rest != "" && IsWordChar(rest[len(rest)-1:]) {
+			continue
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestOpensBlock(t *testing.T) {
+	tests := []struct {
+		filetype, line string
+		want           bool
+	}{
+		{"Go", "func main() {", true},
+		{"Go", "x := []int{1, 2}", false},
+		{"Python", "def f(x):  ", true},
+		{"Python", "x = 1", false},
+		{"Lua", "if x then", true},
+		{"Lua", "x = athen", false},
+		{"Unknown", "\tfoo(", true},
+	}
+	for _, test := range tests {
+		if got := OpensBlock(test.filetype, test.line); got != test.want {
+			t.Errorf("OpensBlock(%q, %q) = %v, want %v", test.filetype, test.line, got, test.want)
+		}
+	}
+}
+
+func TestAutoIndent(t *testing.T) {
+	settings = DefaultSettings()
+	defer func() { settings = DefaultSettings() }()
+
+	eh := newTestEventHandler("\tif x {")
+	v := eh.v
+	v.cursor.End()
+	v.InsertEnter()
+	if got := v.buf.String(); got != "\tif x {\n\t\t" {
+		t.Errorf("Enter after an opener gave %q", got)
+	}
+	if v.cursor.x != 2 || v.cursor.y != 1 {
+		t.Errorf("Cursor is at %d, %d, want 2, 1", v.cursor.x, v.cursor.y)
+	}
+
+	// The newline and the indentation are a single undo
+	eh.UndoGroup()
+	if got := v.buf.String(); got != "\tif x {" {
+		t.Errorf("Undo gave %q", got)
+	}
+	eh.RedoGroup()
+	if got := v.buf.String(); got != "\tif x {\n\t\t" {
+		t.Errorf("Redo gave %q", got)
+	}
+
+	// Backspace deletes a level of indentation made of spaces
+	settings.TabsToSpaces = true
+	eh = newTestEventHandler("      x")
+	v = eh.v
+	v.cursor.x = 6
+	v.Backspace()
+	if got := v.buf.String(); got != "    x" || v.cursor.x != 4 {
+		t.Errorf("Backspace in the indentation gave %q with the cursor at %d", got, v.cursor.x)
+	}
+	v.Backspace()
+	if got := v.buf.String(); got != "x" || v.cursor.x != 0 {
+		t.Errorf("Backspace in the indentation gave %q with the cursor at %d", got, v.cursor.x)
+	}
+}
+
+func TestUndoGroups(t *testing.T) {
+	eh := newTestEventHandler("")
+
+	eh.Insert(0, "a")
+	eh.BeginGroup()
+	eh.Insert(1, "b")
+	eh.BeginGroup()
+	eh.Insert(2, "c")
+	eh.EndGroup()
+	eh.Insert(3, "d")
+	eh.EndGroup()
+	eh.Insert(4, "e")
+
+	eh.UndoGroup()
+	eh.UndoGroup()
+	if got := eh.buf.String(); got != "a" {
+		t.Errorf("Undoing the group gave %q", got)
+	}
+	eh.RedoGroup()
+	if got := eh.buf.String(); got != "abcd" {
+		t.Errorf("Redoing the group gave %q", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Key is a key press along with the modifiers that were held
+// It can be used as a map key to bind actions to key presses
+type Key struct {
+	keyCode   tcell.Key
+	modifiers tcell.ModMask
+	// The character, if the keyCode is tcell.KeyRune
+	r rune
+}
+
+// keyCodes maps the names of the special keys to their codes
+// The names are the ones tcell uses, like "Enter", "PgUp" and "Ctrl-A"
+var keyCodes map[string]tcell.Key
+
+func init() {
+	keyCodes = make(map[string]tcell.Key)
+	for k, name := range tcell.KeyNames {
+		keyCodes[name] = k
+	}
+}
+
+// isControlKey returns whether the key code is a control character like Ctrl-A
+// Some terminals report these with the Ctrl modifier and some without
+func isControlKey(k tcell.Key) bool {
+	return k < ' ' || k == tcell.KeyDEL
+}
+
+// KeyFromEvent returns the Key of a key press
+func KeyFromEvent(e *tcell.EventKey) Key {
+	k := Key{keyCode: e.Key(), modifiers: e.Modifiers()}
+	if e.Key() == tcell.KeySpace {
+		// Some versions of tcell report the space bar as a special key
+		k.keyCode = tcell.KeyRune
+		k.r = ' '
+	} else if e.Key() == tcell.KeyRune {
+		k.r = e.Rune()
+	} else if isControlKey(e.Key()) {
+		k.modifiers &^= tcell.ModCtrl
+	}
+	return k
+}
+
+// ParseKey parses a key name like "Ctrl-S", "Alt-Up", "Shift-Home", "PgDn" or "x"
+// The modifiers "Ctrl-", "Alt-" and "Shift-" can be combined
+// It returns false if the name isn't a valid key
+func ParseKey(name string) (Key, bool) {
+	var k Key
+	for {
+		if name == "Space" {
+			k.keyCode = tcell.KeyRune
+			k.r = ' '
+			return k, true
+		}
+		if code, ok := keyCodes[name]; ok {
+			k.keyCode = code
+			if isControlKey(code) {
+				k.modifiers &^= tcell.ModCtrl
+			}
+			return k, true
+		}
+
+		if strings.HasPrefix(name, "Ctrl-") && len(name) == 6 {
+			// The names of the control characters use capital letters
+			if code, ok := keyCodes["Ctrl-"+strings.ToUpper(name[5:])]; ok {
+				k.keyCode = code
+				return k, true
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(name, "Ctrl-"):
+			k.modifiers |= tcell.ModCtrl
+			name = name[len("Ctrl-"):]
+		case strings.HasPrefix(name, "Alt-"):
+			k.modifiers |= tcell.ModAlt
+			name = name[len("Alt-"):]
+		case strings.HasPrefix(name, "Shift-"):
+			k.modifiers |= tcell.ModShift
+			name = name[len("Shift-"):]
+		case utf8.RuneCountInString(name) == 1:
+			k.keyCode = tcell.KeyRune
+			k.r, _ = utf8.DecodeRuneInString(name)
+			return k, true
+		default:
+			return k, false
+		}
+	}
+}
+
+// String returns the name of the key, in the format understood by ParseKey
+func (k Key) String() string {
+	var name string
+	if k.keyCode == tcell.KeyRune {
+		name = string(k.r)
+		if k.r == ' ' {
+			name = "Space"
+		}
+	} else {
+		name = tcell.KeyNames[k.keyCode]
+	}
+	if k.modifiers&tcell.ModShift != 0 {
+		name = "Shift-" + name
+	}
+	if k.modifiers&tcell.ModAlt != 0 {
+		name = "Alt-" + name
+	}
+	if k.modifiers&tcell.ModCtrl != 0 {
+		name = "Ctrl-" + name
+	}
+	return name
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"math/bits"
+	"math/rand"
+	"strings"
+)
+
+// A lineNode is a node of a LineRope, it holds a single line of text
+// Every node also keeps the number of lines and characters in its subtree,
+// which is what lets the rope find lines and character positions quickly
+type lineNode struct {
+	left  *lineNode
+	right *lineNode
+
+	// The line, without its newline
+	line string
+	// The length of the line in runes
+	count int
+	// The hash of the line and its newline, and hashBase to the power of its length
+	lineHash uint64
+	linePow  uint64
+
+	// Number of lines in the subtree
+	lines int
+	// Number of runes in the subtree, counting a newline after every line
+	chars int
+	// The hash of the text of the subtree, and hashBase to the power of its length
+	hash uint64
+	pow  uint64
+}
+
+// The text is hashed with a polynomial hash modulo the prime 2^61-1
+// Because the hash of two joined pieces of text can be calculated from the hashes
+// of the pieces, the hash of the whole rope is kept up to date as it changes
+const (
+	hashMod  = 1<<61 - 1
+	hashBase = 1000003
+)
+
+// mulMod returns a*b modulo hashMod
+func mulMod(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	// 2^61 is 1 modulo hashMod, so the bits above the 61st can be added to the rest
+	r := (hi<<3 | lo>>61) + lo&hashMod
+	for r >= hashMod {
+		r -= hashMod
+	}
+	return r
+}
+
+func newLineNode(line string) *lineNode {
+	n := &lineNode{line: line, linePow: 1}
+	for _, r := range line + "\n" {
+		n.lineHash = (mulMod(n.lineHash, hashBase) + uint64(r) + 1) % hashMod
+		n.linePow = mulMod(n.linePow, hashBase)
+		n.count++
+	}
+	// Don't count the newline
+	n.count--
+	n.update()
+	return n
+}
+
+func numLines(n *lineNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.lines
+}
+
+func numChars(n *lineNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.chars
+}
+
+func hashOf(n *lineNode) (uint64, uint64) {
+	if n == nil {
+		return 0, 1
+	}
+	return n.hash, n.pow
+}
+
+// update recalculates the totals of the subtree from the node's children
+func (n *lineNode) update() {
+	n.lines = 1 + numLines(n.left) + numLines(n.right)
+	n.chars = n.count + 1 + numChars(n.left) + numChars(n.right)
+
+	lh, lp := hashOf(n.left)
+	rh, rp := hashOf(n.right)
+	h := (mulMod(lh, n.linePow) + n.lineHash) % hashMod
+	n.hash = (mulMod(h, rp) + rh) % hashMod
+	n.pow = mulMod(mulMod(lp, n.linePow), rp)
+}
+
+// LineRope stores text as a balanced tree of lines
+// Looking up a line, converting between character positions and line/column
+// locations, and inserting or removing text are O(log n) in the number of lines
+// (plus the size of the lines which are edited), so the cost of editing doesn't
+// depend on the size of the file
+type LineRope struct {
+	root *lineNode
+}
+
+// NewLineRope returns a new rope holding the given text
+func NewLineRope(text string) *LineRope {
+	r := new(LineRope)
+	r.root = buildLines(strings.Split(text, "\n"))
+	return r
+}
+
+// buildLines builds a perfectly balanced tree out of the lines
+func buildLines(lines []string) *lineNode {
+	if len(lines) == 0 {
+		return nil
+	}
+	mid := len(lines) / 2
+	n := newLineNode(lines[mid])
+	n.left = buildLines(lines[:mid])
+	n.right = buildLines(lines[mid+1:])
+	n.update()
+	return n
+}
+
+// splitLines splits the tree into the first k lines and the rest
+func splitLines(n *lineNode, k int) (*lineNode, *lineNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if numLines(n.left) >= k {
+		l, r := splitLines(n.left, k)
+		n.left = r
+		n.update()
+		return l, n
+	}
+	l, r := splitLines(n.right, k-numLines(n.left)-1)
+	n.right = l
+	n.update()
+	return n, r
+}
+
+// mergeLines joins two trees, with the lines of a before the lines of b
+// The root is picked at random weighted by the size of each tree, which keeps
+// the tree balanced on average without having to store anything extra
+func mergeLines(a, b *lineNode) *lineNode {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	if rand.Intn(a.lines+b.lines) < a.lines {
+		a.right = mergeLines(a.right, b)
+		a.update()
+		return a
+	}
+	b.left = mergeLines(a, b.left)
+	b.update()
+	return b
+}
+
+// NumLines returns the number of lines in the rope
+func (r *LineRope) NumLines() int {
+	return numLines(r.root)
+}
+
+// Len returns the number of runes in the rope
+func (r *LineRope) Len() int {
+	// There is no newline after the last line
+	return numChars(r.root) - 1
+}
+
+// Hash returns a hash of the text in the rope
+// This is O(1), so it can be used to quickly check whether the text has changed
+func (r *LineRope) Hash() uint64 {
+	h, _ := hashOf(r.root)
+	return h
+}
+
+// Line returns line number y
+func (r *LineRope) Line(y int) string {
+	n := r.root
+	for n != nil {
+		left := numLines(n.left)
+		if y < left {
+			n = n.left
+		} else if y == left {
+			return n.line
+		} else {
+			y -= left + 1
+			n = n.right
+		}
+	}
+	return ""
+}
+
+// Lines returns the lines from start up to (but not including) end
+func (r *LineRope) Lines(start, end int) []string {
+	var lines []string
+	for y := start; y < end; y++ {
+		lines = append(lines, r.Line(y))
+	}
+	return lines
+}
+
+// LineStart returns the character position of the start of line y
+func (r *LineRope) LineStart(y int) int {
+	loc := 0
+	n := r.root
+	for n != nil {
+		left := numLines(n.left)
+		if y < left {
+			n = n.left
+		} else {
+			loc += numChars(n.left)
+			if y == left {
+				return loc
+			}
+			loc += n.count + 1
+			y -= left + 1
+			n = n.right
+		}
+	}
+	return loc
+}
+
+// Locate converts a character position to the x, y location of that character
+// Positions past the end of the rope are moved to the end
+func (r *LineRope) Locate(loc int) (int, int) {
+	y := 0
+	n := r.root
+	for n != nil {
+		left := numChars(n.left)
+		if loc < left {
+			n = n.left
+			continue
+		}
+		loc -= left
+		y += numLines(n.left)
+		if loc <= n.count || n.right == nil {
+			if loc > n.count {
+				loc = n.count
+			}
+			return loc, y
+		}
+		loc -= n.count + 1
+		y++
+		n = n.right
+	}
+	return 0, 0
+}
+
+// replaceLines replaces the lines from start up to (but not including) end
+// with the given lines
+func (r *LineRope) replaceLines(start, end int, lines []string) {
+	left, rest := splitLines(r.root, start)
+	_, right := splitLines(rest, end-start)
+	r.root = mergeLines(mergeLines(left, buildLines(lines)), right)
+}
+
+// Insert inserts the text at the character position loc
+func (r *LineRope) Insert(loc int, text string) {
+	x, y := r.Locate(loc)
+	line := []rune(r.Line(y))
+	before, after := string(line[:x]), string(line[x:])
+
+	lines := strings.Split(text, "\n")
+	lines[0] = before + lines[0]
+	lines[len(lines)-1] += after
+	r.replaceLines(y, y+1, lines)
+}
+
+// Remove removes the characters from start up to (but not including) end,
+// and returns the text that was removed
+func (r *LineRope) Remove(start, end int) string {
+	removed := r.Substr(start, end)
+
+	x1, y1 := r.Locate(start)
+	x2, y2 := r.Locate(end)
+	first := []rune(r.Line(y1))
+	last := []rune(r.Line(y2))
+	r.replaceLines(y1, y2+1, []string{string(first[:x1]) + string(last[x2:])})
+
+	return removed
+}
+
+// Substr returns the text from the character position start up to (but not
+// including) end
+func (r *LineRope) Substr(start, end int) string {
+	if start >= end {
+		return ""
+	}
+	x1, y1 := r.Locate(start)
+	x2, y2 := r.Locate(end)
+	if y1 == y2 {
+		return string([]rune(r.Line(y1))[x1:x2])
+	}
+
+	lines := r.Lines(y1, y2+1)
+	lines[0] = string([]rune(lines[0])[x1:])
+	last := len(lines) - 1
+	lines[last] = string([]rune(lines[last])[:x2])
+	return strings.Join(lines, "\n")
+}
+
+// String returns the whole text of the rope
+func (r *LineRope) String() string {
+	var b strings.Builder
+	b.Grow(numChars(r.root))
+	first := true
+	var walk func(n *lineNode)
+	walk = func(n *lineNode) {
+		if n == nil {
+			return
+		}
+		walk(n.left)
+		if !first {
+			b.WriteByte('\n')
+		}
+		first = false
+		b.WriteString(n.line)
+		walk(n.right)
+	}
+	walk(r.root)
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// linters maps filetypes to the shell command which lints their files
+// %f in the command is the name of the file, and the command runs in the
+// file's directory
+var linters map[string]string
+
+// DefaultLinters returns the linters micro runs when they are installed, by filetype
+func DefaultLinters() map[string]string {
+	return map[string]string{
+		"Go":         "go vet",
+		"SH":         "shellcheck -f gcc %f",
+		"Python":     "pyflakes %f",
+		"JavaScript": "eslint -f unix %f",
+	}
+}
+
+// InitLinters loads the default linters, and then the user's from
+// $(configDir)/linters.json, which maps filetypes to commands like this:
+// {"Python": "flake8 %f"}
+// Mapping a filetype to "" turns its linter off
+func InitLinters() {
+	linters = DefaultLinters()
+
+	filename := configDir + "/linters.json"
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		TermMessage("Error reading linters.json file: " + err.Error())
+		return
+	}
+	var parsed map[string]string
+	if err := json.Unmarshal(input, &parsed); err != nil {
+		TermMessage("Error reading linters.json: " + err.Error())
+		return
+	}
+	for filetype, command := range parsed {
+		linters[filetype] = command
+	}
+}
+
+// lintRegex matches a line of a linter's output, like
+// file:line:col: warning: message, where the column is optional
+var lintRegex = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?\s*(.*)$`)
+
+// ParseLintOutput returns the gutter messages for the file at path in the
+// output of a linter which ran in dir
+// A message which starts with "error:", "warning:", "info:" or "note:" is of
+// that kind, and the others are warnings
+func ParseLintOutput(output, dir, path string) []GutterMessage {
+	abs, _ := filepath.Abs(path)
+	var msgs []GutterMessage
+	for _, line := range strings.Split(output, "\n") {
+		m := lintRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if m == nil {
+			continue
+		}
+		file := m[1]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if filepath.Clean(file) != abs {
+			continue
+		}
+		lineNum, _ := strconv.Atoi(m[2])
+
+		kind, msg := GutterWarning, m[4]
+		for prefix, k := range map[string]int{"error:": GutterError, "warning:": GutterWarning, "info:": GutterInfo, "note:": GutterInfo} {
+			if strings.HasPrefix(msg, prefix) {
+				kind, msg = k, strings.TrimSpace(msg[len(prefix):])
+			}
+		}
+		msgs = append(msgs, GutterMessage{lineNum - 1, msg, kind})
+	}
+	return msgs
+}
+
+// Lint runs a linter command on the file at path, and returns its messages
+// about the file
+// Linters exit with an error when they find problems, so that isn't an error
+func Lint(path, command string) []GutterMessage {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	dir, file := filepath.Split(abs)
+	c := ShellCommand(strings.Replace(command, "%f", shellQuote(file), -1))
+	c.Dir = dir
+	output, _ := c.CombinedOutput()
+	return ParseLintOutput(string(output), dir, abs)
+}
+
+// shellQuote quotes a word for the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// linterFor returns the linter command for the buffer, or "" if it has none or
+// the linter isn't installed
+func linterFor(buf *Buffer) string {
+	command := linters[buf.filetype]
+	fields := strings.Fields(command)
+	if buf.path == "" || len(fields) == 0 {
+		return ""
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return ""
+	}
+	return command
+}
+
+// RunLinter lints the buffer's file in the background, and flags the lines it
+// finds problems on in the gutter when it is done
+func RunLinter(buf *Buffer) {
+	command := linterFor(buf)
+	if command == "" {
+		return
+	}
+	path := buf.path
+	go func() {
+		msgs := Lint(path, command)
+		screen.PostEvent(tcell.NewEventInterrupt(func() {
+			buf.SetGutterMessages("linter", msgs)
+		}))
+	}()
+}
+
+// LintCommand lints the buffer's file, and says how many problems were found
+func LintCommand(view *View, args []string) {
+	command := linterFor(view.buf)
+	if command == "" {
+		messenger.Error("There is no linter for " + view.buf.filetype + " files")
+		return
+	}
+	msgs := Lint(view.buf.path, command)
+	view.buf.SetGutterMessages("linter", msgs)
+	messenger.Message(command + " found " + strconv.Itoa(len(msgs)) + " problems")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseLintOutput(t *testing.T) {
+	dir := filepath.FromSlash("/project/pkg")
+	output := "# example\n" +
+		"./main.go:6:2: fmt.Printf call has arguments but no formatting directives\n" +
+		"main.go:9: error: undefined: x\r\n" +
+		"other.go:1:1: note: not this file\n" +
+		filepath.FromSlash("/project/pkg/main.go") + ":12:5: info: fine\n"
+	msgs := ParseLintOutput(output, dir, filepath.Join(dir, "main.go"))
+	want := []GutterMessage{
+		{5, "fmt.Printf call has arguments but no formatting directives", GutterWarning},
+		{8, "undefined: x", GutterError},
+		{11, "fine", GutterInfo},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("Parsing gave %v", msgs)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Errorf("Message %d is %v, want %v", i, msgs[i], want[i])
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The tests use sh")
+	}
+	dir, err := ioutil.TempDir("", "micro-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "it's.sh")
+	ioutil.WriteFile(path, []byte("echo $1\n"), 0644)
+
+	msgs := Lint(path, `printf '%s:1:6: warning: quote this\n' %f; exit 1`)
+	if len(msgs) != 1 || msgs[0] != (GutterMessage{0, "quote this", GutterWarning}) {
+		t.Errorf("Linting gave %v", msgs)
+	}
+}
+
+func TestGutterMessages(t *testing.T) {
+	messenger = new(Messenger)
+	eh := newTestEventHandler("a\nb\nc")
+	v := eh.v
+	v.buf.SetGutterMessages("linter", []GutterMessage{{1, "meh", GutterWarning}, {2, "hm", GutterInfo}})
+	v.buf.SetGutterMessages("plugin", []GutterMessage{{1, "bad", GutterError}})
+
+	// The most serious message of a line wins
+	msgs := v.buf.GutterMessages()
+	if len(msgs) != 2 || msgs[1].msg != "bad" || msgs[2].msg != "hm" {
+		t.Errorf("The gutter messages are %v", msgs)
+	}
+
+	// The message is shown when the cursor moves to its line, and taken away
+	// when it leaves
+	v.DisplayGutterMessage()
+	if messenger.message != "" {
+		t.Errorf("A message was shown for an unflagged line: %q", messenger.message)
+	}
+	v.cursor.y = 1
+	v.DisplayGutterMessage()
+	if messenger.message != "bad" {
+		t.Errorf("The message on a flagged line is %q", messenger.message)
+	}
+	v.cursor.y = 0
+	v.DisplayGutterMessage()
+	if messenger.message != "" {
+		t.Errorf("The message %q stayed after the cursor left", messenger.message)
+	}
+
+	// Other messages are left alone
+	v.cursor.y = 2
+	v.DisplayGutterMessage()
+	messenger.Message("Saved")
+	v.cursor.y = 0
+	v.DisplayGutterMessage()
+	if messenger.message != "Saved" {
+		t.Errorf("Leaving the line replaced the message with %q", messenger.message)
+	}
+}
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/gdamore/tcell"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lspServers maps filetypes to the command which runs their language server
+var lspServers map[string][]string
+
+// lspClients are the running language servers, by filetype
+var lspClients = make(map[string]*LSPClient)
+
+// How long micro waits for a language server to start, and to answer a request
+const (
+	lspStartTimeout   = 30 * time.Second
+	lspRequestTimeout = 5 * time.Second
+)
+
+var (
+	errLSPTimeout  = errors.New("the language server didn't answer")
+	errLSPNotReady = errors.New("the language server is still starting")
+	errLSPExited   = errors.New("the language server exited")
+)
+
+// DefaultLSPServers returns the language servers micro uses when they are
+// installed, by filetype
+func DefaultLSPServers() map[string][]string {
+	return map[string][]string{
+		"Go":         {"gopls"},
+		"Python":     {"pyright-langserver", "--stdio"},
+		"C":          {"clangd"},
+		"Rust":       {"rust-analyzer"},
+		"JavaScript": {"typescript-language-server", "--stdio"},
+		"SH":         {"bash-language-server", "start"},
+	}
+}
+
+// InitLSP loads the default language servers, and then the user's from
+// $(configDir)/lsp.json, which maps filetypes to commands like this:
+// {"Python": ["pylsp"], "Go": ["gopls", "-remote=auto"]}
+// Mapping a filetype to [] turns its language server off
+func InitLSP() {
+	lspServers = DefaultLSPServers()
+
+	filename := configDir + "/lsp.json"
+	if _, err := os.Stat(filename); err != nil {
+		return
+	}
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		TermMessage("Error reading lsp.json file: " + err.Error())
+		return
+	}
+	var parsed map[string][]string
+	if err := json.Unmarshal(input, &parsed); err != nil {
+		TermMessage("Error reading lsp.json: " + err.Error())
+		return
+	}
+	for filetype, command := range parsed {
+		lspServers[filetype] = command
+	}
+}
+
+// The types of the protocol which micro uses
+// Positions count the characters of a line in UTF-16 code units
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// An lspLocation is a Location, or a LocationLink which has its uri and range
+// in the target fields
+type lspLocation struct {
+	URI                  string   `json:"uri"`
+	Range                lspRange `json:"range"`
+	TargetURI            string   `json:"targetUri"`
+	TargetSelectionRange lspRange `json:"targetSelectionRange"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Message  string   `json:"message"`
+	Source   string   `json:"source"`
+}
+
+type lspCompletionItem struct {
+	Label            string       `json:"label"`
+	Detail           string       `json:"detail"`
+	FilterText       string       `json:"filterText"`
+	InsertText       string       `json:"insertText"`
+	InsertTextFormat int          `json:"insertTextFormat"`
+	TextEdit         *lspTextEdit `json:"textEdit"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes         map[string][]lspTextEdit `json:"changes"`
+	DocumentChanges []struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+		Edits []lspTextEdit `json:"edits"`
+	} `json:"documentChanges"`
+}
+
+// An lspMessage is a request, a response or a notification
+type lspMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+// readLSPMessage reads a message, which is JSON after a Content-Length header
+func readLSPMessage(r *bufio.Reader) (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("Content-Length:"):]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("a message has no Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	msg := new(lspMessage)
+	return msg, json.Unmarshal(body, msg)
+}
+
+// writeLSPMessage writes a message with its Content-Length header
+func writeLSPMessage(w io.Writer, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	return err
+}
+
+// fileURI returns the URI of a file
+func fileURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// A Windows path like C:/dir
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// uriPath returns the path of the file which a URI is for
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
+
+// lspLanguageID returns the language identifier of a filetype
+func lspLanguageID(filetype string) string {
+	if filetype == "SH" {
+		return "shellscript"
+	}
+	return strings.ToLower(filetype)
+}
+
+// utf16Len returns the length of the characters in UTF-16 code units
+func utf16Len(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// lspPos returns the position of the character at loc in the buffer
+func lspPos(b *Buffer, loc int) lspPosition {
+	x, y := FromCharPos(loc, b)
+	line := []rune(b.Line(y))
+	return lspPosition{y, utf16Len(line[:Min(x, len(line))])}
+}
+
+// bufferLoc returns the character in the buffer at a position, which is kept
+// in the buffer
+func bufferLoc(b *Buffer, pos lspPosition) int {
+	y := Max(0, Min(pos.Line, b.NumLines()-1))
+	x, n := 0, 0
+	for _, r := range b.Line(y) {
+		if n >= pos.Character {
+			break
+		}
+		n += utf16Len([]rune{r})
+		x++
+	}
+	return ToCharPos(x, y, b)
+}
+
+// An LSPClient talks to the language server of a filetype, which runs as a
+// child process and is sent messages on its standard input
+// Its output is read by a goroutine, so it must be locked while it is used
+type LSPClient struct {
+	sync.Mutex
+	// Only one message can be written at a time
+	writeLock sync.Mutex
+
+	filetype string
+	cmd      *exec.Cmd
+	in       io.WriteCloser
+
+	nextID  int
+	pending map[int]chan *lspMessage
+
+	// Notifications wait until the server is initialized
+	ready  bool
+	queued []interface{}
+	exited bool
+	// Whether the server can be sent only the changed part of a buffer
+	incremental bool
+
+	// The diagnostics of the server, by the absolute path of their file
+	diagnostics map[string][]lspDiagnostic
+}
+
+// StartLSPClient runs a language server and starts initializing it
+func StartLSPClient(filetype string, command []string) (*LSPClient, error) {
+	c := &LSPClient{
+		filetype:    filetype,
+		pending:     make(map[int]chan *lspMessage),
+		diagnostics: make(map[string][]lspDiagnostic),
+	}
+	c.cmd = exec.Command(command[0], command[1:]...)
+	var err error
+	if c.in, err = c.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	out, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	go c.read(bufio.NewReader(out))
+	go c.initialize()
+	return c, nil
+}
+
+// initialize tells the server what micro can do, and sends the notifications
+// which waited for it
+func (c *LSPClient) initialize() {
+	root, _ := os.Getwd()
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(root),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext", "markdown"}},
+				"completion":         map[string]interface{}{"completionItem": map[string]interface{}{"snippetSupport": false}},
+				"definition":         map[string]interface{}{},
+				"rename":             map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}
+	var result struct {
+		Capabilities struct {
+			TextDocumentSync json.RawMessage `json:"textDocumentSync"`
+		} `json:"capabilities"`
+	}
+	if err := c.call("initialize", params, &result, lspStartTimeout); err != nil {
+		c.Close()
+		return
+	}
+
+	// The sync kind is a number, or the change field of an object
+	var kind struct {
+		Change int `json:"change"`
+	}
+	if json.Unmarshal(result.Capabilities.TextDocumentSync, &kind.Change) != nil {
+		json.Unmarshal(result.Capabilities.TextDocumentSync, &kind)
+	}
+
+	// The queue is written before the writers which see ready can write, so
+	// that the server gets the notifications in order
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	writeLSPMessage(c.in, map[string]interface{}{"jsonrpc": "2.0", "method": "initialized", "params": map[string]interface{}{}})
+	c.Lock()
+	c.ready = true
+	c.incremental = kind.Change == 2
+	queued := c.queued
+	c.queued = nil
+	c.Unlock()
+	for _, msg := range queued {
+		writeLSPMessage(c.in, msg)
+	}
+}
+
+// read reads the messages of the server until it exits
+func (c *LSPClient) read(r *bufio.Reader) {
+	for {
+		msg, err := readLSPMessage(r)
+		if err != nil {
+			break
+		}
+		switch {
+		case msg.Method != "" && len(msg.ID) > 0:
+			c.answer(msg)
+		case msg.Method != "":
+			c.notified(msg)
+		default:
+			id, _ := strconv.Atoi(string(msg.ID))
+			c.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.Unlock()
+			if ok {
+				ch <- msg
+			}
+		}
+	}
+
+	c.Lock()
+	c.exited = true
+	for id, ch := range c.pending {
+		ch <- &lspMessage{Error: &lspError{Message: errLSPExited.Error()}}
+		delete(c.pending, id)
+	}
+	c.Unlock()
+	c.cmd.Wait()
+}
+
+// answer answers a request of the server
+// micro has nothing to configure or register, so the answers are empty
+func (c *LSPClient) answer(msg *lspMessage) {
+	var result interface{}
+	if msg.Method == "workspace/configuration" {
+		var params struct {
+			Items []interface{} `json:"items"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		result = make([]interface{}, len(params.Items))
+	}
+	c.write(map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+}
+
+// notified handles a notification of the server
+func (c *LSPClient) notified(msg *lspMessage) {
+	if msg.Method != "textDocument/publishDiagnostics" {
+		return
+	}
+	var params struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if json.Unmarshal(msg.Params, &params) != nil {
+		return
+	}
+	c.Lock()
+	c.diagnostics[uriPath(params.URI)] = params.Diagnostics
+	c.Unlock()
+	if screen != nil {
+		screen.PostEvent(tcell.NewEventInterrupt(c))
+	}
+}
+
+// write sends a message to the server
+func (c *LSPClient) write(msg interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return writeLSPMessage(c.in, msg)
+}
+
+// call sends a request to the server, and waits for its result
+func (c *LSPClient) call(method string, params, result interface{}, timeout time.Duration) error {
+	c.Lock()
+	if c.exited {
+		c.Unlock()
+		return errLSPExited
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan *lspMessage, 1)
+	c.pending[id] = ch
+	c.Unlock()
+
+	err := c.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	if err == nil {
+		select {
+		case msg := <-ch:
+			if msg.Error != nil {
+				return msg.Error
+			}
+			if result != nil && len(msg.Result) > 0 {
+				return json.Unmarshal(msg.Result, result)
+			}
+			return nil
+		case <-time.After(timeout):
+			err = errLSPTimeout
+		}
+	}
+	c.Lock()
+	delete(c.pending, id)
+	c.Unlock()
+	return err
+}
+
+// Request sends a request to the server once it is initialized, and waits for
+// its result
+func (c *LSPClient) Request(method string, params, result interface{}) error {
+	c.Lock()
+	ready := c.ready
+	c.Unlock()
+	if !ready {
+		return errLSPNotReady
+	}
+	return c.call(method, params, result, lspRequestTimeout)
+}
+
+// Notify sends a notification to the server, or keeps it until the server is
+// initialized
+func (c *LSPClient) Notify(method string, params interface{}) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+	c.Lock()
+	if !c.ready {
+		c.queued = append(c.queued, msg)
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+	c.write(msg)
+}
+
+// Exited returns whether the server has exited
+func (c *LSPClient) Exited() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.exited
+}
+
+// Close stops the server without asking it
+func (c *LSPClient) Close() {
+	c.in.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+}
+
+// Shutdown asks the server to exit
+func (c *LSPClient) Shutdown() {
+	if c.Exited() {
+		return
+	}
+	c.call("shutdown", nil, nil, time.Second)
+	c.write(map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	c.in.Close()
+}
+
+// textDocument returns the identifier of the buffer for the server
+func textDocument(b *Buffer) map[string]interface{} {
+	return map[string]interface{}{"uri": fileURI(b.path)}
+}
+
+// DidOpen tells the server that the buffer was opened
+func (c *LSPClient) DidOpen(b *Buffer) {
+	b.lspVersion = 1
+	c.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":        fileURI(b.path),
+			"languageId": lspLanguageID(c.filetype),
+			"version":    b.lspVersion,
+			"text":       b.String(),
+		},
+	})
+}
+
+// DidChange tells the server that the text from start to end (before the
+// change) was replaced with text
+// Servers which can't take the changed part are sent the whole buffer
+func (c *LSPClient) DidChange(b *Buffer, start, end lspPosition, text string) {
+	b.lspVersion++
+	c.Lock()
+	incremental := c.ready && c.incremental
+	c.Unlock()
+	change := map[string]interface{}{"text": b.String()}
+	if incremental {
+		change = map[string]interface{}{"range": lspRange{start, end}, "text": text}
+	}
+	c.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": fileURI(b.path), "version": b.lspVersion},
+		"contentChanges": []interface{}{change},
+	})
+}
+
+// DidSave tells the server that the buffer was saved
+func (c *LSPClient) DidSave(b *Buffer) {
+	c.Notify("textDocument/didSave", map[string]interface{}{"textDocument": textDocument(b)})
+}
+
+// DidClose tells the server that the buffer was closed
+func (c *LSPClient) DidClose(b *Buffer) {
+	c.Notify("textDocument/didClose", map[string]interface{}{"textDocument": textDocument(b)})
+	c.Lock()
+	delete(c.diagnostics, uriPath(fileURI(b.path)))
+	c.Unlock()
+}
+
+// positionParams returns the parameters of a request about the character at
+// loc in the buffer
+func positionParams(b *Buffer, loc int) map[string]interface{} {
+	return map[string]interface{}{"textDocument": textDocument(b), "position": lspPos(b, loc)}
+}
+
+// Definition returns where the symbol at loc in the buffer is defined
+func (c *LSPClient) Definition(b *Buffer, loc int) ([]lspLocation, error) {
+	var result json.RawMessage
+	if err := c.Request("textDocument/definition", positionParams(b, loc), &result); err != nil {
+		return nil, err
+	}
+	// The result is a location, a list of locations or a list of links
+	var locations []lspLocation
+	if json.Unmarshal(result, &locations) != nil {
+		var location lspLocation
+		if err := json.Unmarshal(result, &location); err != nil {
+			return nil, err
+		}
+		locations = []lspLocation{location}
+	}
+	for i, l := range locations {
+		if l.URI == "" {
+			locations[i].URI, locations[i].Range = l.TargetURI, l.TargetSelectionRange
+		}
+	}
+	return locations, nil
+}
+
+// Hover returns the information about the symbol at loc in the buffer, as
+// plain text
+func (c *LSPClient) Hover(b *Buffer, loc int) (string, error) {
+	var result struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := c.Request("textDocument/hover", positionParams(b, loc), &result); err != nil {
+		return "", err
+	}
+	return hoverText(result.Contents), nil
+}
+
+// hoverText returns the text of the contents of a hover, which are a string,
+// a marked string, markup, or a list of strings and marked strings
+// The lines of markdown code blocks which only mark the code are left out
+func hoverText(contents json.RawMessage) string {
+	var parts []json.RawMessage
+	if json.Unmarshal(contents, &parts) != nil {
+		parts = []json.RawMessage{contents}
+	}
+	var text []string
+	for _, part := range parts {
+		var s string
+		if json.Unmarshal(part, &s) != nil {
+			var markup struct {
+				Value string `json:"value"`
+			}
+			json.Unmarshal(part, &markup)
+			s = markup.Value
+		}
+		for _, line := range strings.Split(s, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "```") {
+				text = append(text, line)
+			}
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+// Completion returns the completions at loc in the buffer
+func (c *LSPClient) Completion(b *Buffer, loc int) ([]lspCompletionItem, error) {
+	var result json.RawMessage
+	if err := c.Request("textDocument/completion", positionParams(b, loc), &result); err != nil {
+		return nil, err
+	}
+	// The result is a list of items, or a completion list
+	var items []lspCompletionItem
+	if json.Unmarshal(result, &items) != nil {
+		var list struct {
+			Items []lspCompletionItem `json:"items"`
+		}
+		if err := json.Unmarshal(result, &list); err != nil {
+			return nil, err
+		}
+		items = list.Items
+	}
+	return items, nil
+}
+
+// Rename returns the edits which rename the symbol at loc in the buffer
+func (c *LSPClient) Rename(b *Buffer, loc int, name string) (lspWorkspaceEdit, error) {
+	params := positionParams(b, loc)
+	params["newName"] = name
+	var edit lspWorkspaceEdit
+	err := c.Request("textDocument/rename", params, &edit)
+	return edit, err
+}
+
+// GutterMessages returns the diagnostics of the buffer as gutter messages
+func (c *LSPClient) GutterMessages(b *Buffer) []GutterMessage {
+	c.Lock()
+	defer c.Unlock()
+	diagnostics := c.diagnostics[uriPath(fileURI(b.path))]
+	msgs := make([]GutterMessage, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		kind := GutterInfo
+		switch d.Severity {
+		case 0, 1:
+			kind = GutterError
+		case 2:
+			kind = GutterWarning
+		}
+		msg := d.Message
+		if d.Source != "" {
+			msg = d.Source + ": " + msg
+		}
+		msgs = append(msgs, GutterMessage{d.Range.Start.Line, msg, kind})
+	}
+	return msgs
+}
+
+// StartLSP connects the buffer to the language server of its filetype, and
+// starts the server if it isn't running yet
+// Nothing happens if there is no server for the filetype, or if it isn't installed
+func StartLSP(b *Buffer) {
+	command := lspServers[b.filetype]
+	if b.path == "" || b.lsp != nil || len(command) == 0 {
+		return
+	}
+	c, ok := lspClients[b.filetype]
+	if !ok || c.Exited() {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			return
+		}
+		var err error
+		if c, err = StartLSPClient(b.filetype, command); err != nil {
+			return
+		}
+		lspClients[b.filetype] = c
+	}
+	b.lsp = c
+	c.DidOpen(b)
+}
+
+// StopLSP disconnects the buffer from its language server
+func StopLSP(b *Buffer) {
+	if b.lsp != nil {
+		b.lsp.DidClose(b)
+		b.lsp = nil
+	}
+}
+
+// ShutdownLSP asks all the language servers to exit
+func ShutdownLSP() {
+	for filetype, c := range lspClients {
+		c.Shutdown()
+		delete(lspClients, filetype)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFakeLSPServer isn't a test: it is the fake language server which the
+// tests run, as another process of the test binary
+// It keeps the documents it is sent, flags the lines with "bad" as errors,
+// and answers the requests by looking at the words of the documents
+func TestFakeLSPServer(t *testing.T) {
+	if os.Getenv("MICRO_FAKE_LSP") != "1" {
+		return
+	}
+	docs := make(map[string][]string)
+	r := bufio.NewReader(os.Stdin)
+
+	reply := func(msg *lspMessage, result interface{}) {
+		writeLSPMessage(os.Stdout, map[string]interface{}{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+	}
+	publish := func(uri string) {
+		var diagnostics []lspDiagnostic
+		for i, line := range docs[uri] {
+			if strings.Contains(line, "bad") {
+				diagnostics = append(diagnostics, lspDiagnostic{Range: lspRange{Start: lspPosition{i, 0}}, Severity: 1, Message: "bad line"})
+			}
+		}
+		writeLSPMessage(os.Stdout, map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "textDocument/publishDiagnostics",
+			"params":  map[string]interface{}{"uri": uri, "diagnostics": diagnostics},
+		})
+	}
+	wordRegex := regexp.MustCompile(`\w+`)
+	wordAt := func(uri string, pos lspPosition) string {
+		for _, m := range wordRegex.FindAllStringIndex(docs[uri][pos.Line], -1) {
+			if m[0] <= pos.Character && pos.Character <= m[1] {
+				return docs[uri][pos.Line][m[0]:m[1]]
+			}
+		}
+		return ""
+	}
+
+	for {
+		msg, err := readLSPMessage(r)
+		if err != nil {
+			os.Exit(1)
+		}
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Range *lspRange `json:"range"`
+				Text  string    `json:"text"`
+			} `json:"contentChanges"`
+			Position lspPosition `json:"position"`
+			NewName  string      `json:"newName"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		uri := params.TextDocument.URI
+
+		switch msg.Method {
+		case "initialize":
+			reply(msg, map[string]interface{}{"capabilities": map[string]interface{}{"textDocumentSync": map[string]interface{}{"change": 2}}})
+		case "textDocument/didOpen":
+			docs[uri] = strings.Split(params.TextDocument.Text, "\n")
+			publish(uri)
+		case "textDocument/didChange":
+			for _, c := range params.ContentChanges {
+				text := strings.Join(docs[uri], "\n")
+				if c.Range == nil {
+					text = c.Text
+				} else {
+					// The documents of the tests are ASCII, where UTF-16 code
+					// units are bytes
+					offset := func(p lspPosition) int {
+						return len(strings.Join(docs[uri][:p.Line], "\n")) + Min(p.Line, 1) + p.Character
+					}
+					text = text[:offset(c.Range.Start)] + c.Text + text[offset(c.Range.End):]
+				}
+				docs[uri] = strings.Split(text, "\n")
+			}
+			publish(uri)
+		case "textDocument/hover":
+			reply(msg, map[string]interface{}{"contents": map[string]string{
+				"kind":  "markdown",
+				"value": "```go\n" + docs[uri][params.Position.Line] + "\n```\n\nthe line",
+			}})
+		case "textDocument/definition":
+			word := wordAt(uri, params.Position)
+			var locations []lspLocation
+			for i, line := range docs[uri] {
+				if j := strings.Index(line, "func "+word+"("); j >= 0 {
+					pos := lspPosition{i, j + len("func ")}
+					locations = append(locations, lspLocation{URI: uri, Range: lspRange{pos, pos}})
+				}
+			}
+			reply(msg, locations)
+		case "textDocument/completion":
+			reply(msg, map[string]interface{}{"isIncomplete": false, "items": []map[string]interface{}{
+				{"label": "alpha"},
+				{"label": "alpine", "detail": "a pine"},
+				{"label": "beta", "insertText": "beta(${1:x})", "insertTextFormat": 2},
+			}})
+		case "textDocument/rename":
+			word := wordAt(uri, params.Position)
+			changes := make(map[string][]lspTextEdit)
+			for docURI, lines := range docs {
+				for i, line := range lines {
+					for _, m := range wordRegex.FindAllStringIndex(line, -1) {
+						if line[m[0]:m[1]] == word {
+							r := lspRange{lspPosition{i, m[0]}, lspPosition{i, m[1]}}
+							changes[docURI] = append(changes[docURI], lspTextEdit{r, params.NewName})
+						}
+					}
+				}
+			}
+			reply(msg, map[string]interface{}{"changes": changes})
+		case "shutdown":
+			reply(msg, nil)
+		case "exit":
+			os.Exit(0)
+		}
+	}
+}
+
+// startFakeLSP opens the file in a buffer connected to the fake language server
+func startFakeLSP(t *testing.T, path string) *Buffer {
+	os.Setenv("MICRO_FAKE_LSP", "1")
+	lspServers = map[string][]string{"Unknown": {os.Args[0], "-test.run=TestFakeLSPServer"}}
+	buf, err := NewBufferFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddBuffer(buf)
+	if buf.lsp == nil {
+		t.Fatal("The buffer has no language server")
+	}
+	v := &View{buf: buf}
+	v.cursor.v = v
+	buf.eh.v = v
+	return buf
+}
+
+// stopFakeLSP closes the buffer and stops the fake language server
+func stopFakeLSP(buf *Buffer) {
+	CloseBuffer(buf)
+	ShutdownLSP()
+	lspServers = nil
+	os.Unsetenv("MICRO_FAKE_LSP")
+}
+
+// waitFor waits until cond is true, and fails the test if it never is
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for " + what)
+}
+
+func TestLSP(t *testing.T) {
+	messenger = new(Messenger)
+	dir, err := ioutil.TempDir("", "micro-lsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(path, []byte("package main\n\nfunc target() {}\n\nfunc main() {\n\ttarget()\n}\n"), 0644)
+
+	buf := startFakeLSP(t, path)
+	defer stopFakeLSP(buf)
+	v := buf.eh.v
+	waitFor(t, "the server to start", func() bool {
+		buf.lsp.Lock()
+		defer buf.lsp.Unlock()
+		return buf.lsp.ready
+	})
+
+	// The edits are synced, and the server's diagnostics end up in the gutter
+	buf.Insert(0, "// bad é\n")
+	waitFor(t, "the diagnostics", func() bool {
+		return buf.GutterMessages()[0].msg == "bad line"
+	})
+	if msgs := buf.GutterMessages(); len(msgs) != 1 || msgs[0].kind != GutterError {
+		t.Errorf("The gutter messages are %v", msgs)
+	}
+
+	// The server sees the line where the call moved to
+	call := ToCharPos(2, 6, buf)
+	if text, err := buf.lsp.Hover(buf, call); err != nil || text != "target() the line" {
+		t.Errorf("Hover gave %q, %v", text, err)
+	}
+
+	v.cursor.SetLoc(call)
+	v.GotoDefinition()
+	if v.cursor.x != 5 || v.cursor.y != 3 {
+		t.Errorf("The definition is at %d,%d, want 5,3", v.cursor.x, v.cursor.y)
+	}
+
+	// Renaming changes every occurrence as one undo
+	v.cursor.SetLoc(call)
+	RenameCommand(v, []string{"aim"})
+	if got := buf.Line(3) + buf.Line(6); got != "func aim() {}\taim()" {
+		t.Errorf("The renamed lines are %q", got)
+	}
+	buf.eh.Undo()
+	if got := buf.Line(6); got != "\ttarget()" {
+		t.Errorf("Undoing the rename gave %q", got)
+	}
+
+	// The completions starting with what was typed are shown, and picking one
+	// replaces the word
+	buf.eh.Insert(ToCharPos(0, 7, buf), "al")
+	v.cursor.SetLoc(ToCharPos(2, 7, buf))
+	items, err := buf.lsp.Completion(buf, v.cursor.Loc())
+	if err != nil || len(items) != 3 {
+		t.Fatalf("The completions are %v, %v", items, err)
+	}
+	p := &CompletionPopup{v: v, items: items, start: ToCharPos(0, 7, buf)}
+	if !p.filter() || len(p.shown) != 2 {
+		t.Errorf("%d completions match al, want 2", len(p.shown))
+	}
+	p.Accept(p.shown[1])
+	if got := buf.Line(7); got != "alpine}" {
+		t.Errorf("Completing gave %q", got)
+	}
+	p.start = v.cursor.Loc()
+	p.Accept(items[2])
+	if got := buf.Line(7); got != "alpinebeta(x)}" {
+		t.Errorf("Completing a snippet gave %q", got)
+	}
+}
+
+func TestLSPPositions(t *testing.T) {
+	buf := NewBuffer("a😀b\néx", "")
+	// The emoji takes two UTF-16 code units
+	if pos := lspPos(buf, 2); pos != (lspPosition{0, 3}) {
+		t.Errorf("The position of b is %v", pos)
+	}
+	if loc := bufferLoc(buf, lspPosition{0, 3}); loc != 2 {
+		t.Errorf("The location of 0:3 is %d", loc)
+	}
+	if loc := bufferLoc(buf, lspPosition{1, 1}); loc != ToCharPos(1, 1, buf) {
+		t.Errorf("The location of 1:1 is %d", loc)
+	}
+	if path := uriPath(fileURI("/tmp/a b.go")); path != filepath.FromSlash("/tmp/a b.go") {
+		t.Errorf("The path of the URI is %q", path)
+	}
+}
+
+func TestHoverText(t *testing.T) {
+	tests := map[string]string{
+		`"plain"`: "plain",
+		`{"kind": "markdown", "value": "` + "```go\\nfunc f()\\n```\\n\\ndoc" + `"}`: "func f() doc",
+		`[{"language": "go", "value": "var x int"}, "doc"]`:                          "var x int doc",
+	}
+	for contents, want := range tests {
+		if got := hoverText(json.RawMessage(contents)); got != want {
+			t.Errorf("hoverText(%s) = %q, want %q", contents, got, want)
+		}
+	}
+}
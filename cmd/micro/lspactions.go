@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNoLSP is the error for a language feature in a buffer which has no
+// language server
+var errNoLSP = errors.New("there is no language server for this buffer")
+
+// relativePath returns the path relative to the current directory, if it is
+// under it
+func relativePath(path string) string {
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// GotoDefinition opens the file where the symbol under the cursor is defined,
+// with the cursor on the definition
+func (v *View) GotoDefinition() bool {
+	if v.buf.lsp == nil {
+		messenger.Error(errNoLSP.Error())
+		return true
+	}
+	locations, err := v.buf.lsp.Definition(v.buf, v.cursor.Loc())
+	if err != nil {
+		messenger.Error(err.Error())
+		return true
+	}
+	if len(locations) == 0 {
+		messenger.Message("No definition found")
+		return true
+	}
+
+	l := locations[0]
+	path := uriPath(l.URI)
+	buf := openBufferAt(path)
+	if buf == nil {
+		if buf, err = OpenBuffer(relativePath(path)); err != nil {
+			messenger.Error(err.Error())
+			return true
+		}
+	}
+	if buf != v.buf {
+		v.SetBuffer(buf)
+	}
+	v.cursor.ResetSelection()
+	v.cursor.SetLoc(bufferLoc(buf, l.Range.Start))
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+	v.matches = Match(v)
+	return true
+}
+
+// Hover shows the information about the symbol under the cursor, like its type
+// and documentation, in the messenger
+func (v *View) Hover() bool {
+	if v.buf.lsp == nil {
+		messenger.Error(errNoLSP.Error())
+		return false
+	}
+	text, err := v.buf.lsp.Hover(v.buf, v.cursor.Loc())
+	if err != nil {
+		messenger.Error(err.Error())
+	} else if text == "" {
+		messenger.Message("No information")
+	} else {
+		messenger.Message(text)
+	}
+	return false
+}
+
+// Autocomplete opens a popup with the completions of the word before the cursor
+func (v *View) Autocomplete() bool {
+	if v.buf.lsp == nil {
+		messenger.Error(errNoLSP.Error())
+		return true
+	}
+	items, err := v.buf.lsp.Completion(v.buf, v.cursor.Loc())
+	if err != nil {
+		messenger.Error(err.Error())
+		return true
+	}
+	if len(items) == 0 {
+		messenger.Message("No completions")
+		return true
+	}
+	line := []rune(v.buf.Line(v.cursor.y))
+	x := Min(v.cursor.x, len(line))
+	for x > 0 && isWordRune(line[x-1]) {
+		x--
+	}
+	p := &CompletionPopup{v: v, items: items, start: ToCharPos(x, v.cursor.y, v.buf)}
+	p.Run()
+	return true
+}
+
+// A CompletionPopup lists the completions at the cursor while the user picks one
+type CompletionPopup struct {
+	v     *View
+	items []lspCompletionItem
+	// Where the word being completed starts in the buffer
+	start int
+
+	// The items which match what has been typed of the word
+	shown    []lspCompletionItem
+	selected int
+	top      int
+
+	// Where the popup was last drawn on the screen
+	x, y, width, height int
+}
+
+// maxCompletionRows is the number of completions the popup shows at once
+const maxCompletionRows = 10
+
+// Run shows the popup until the user picks a completion or goes on typing
+// Typing more of the word narrows the completions down, and any other key
+// closes the popup and does what it normally does
+// Clicking a completion picks it, the mouse wheel moves the selection, and a
+// click anywhere else closes the popup and is handled by the main loop
+// It blocks the main loop
+func (p *CompletionPopup) Run() {
+	v := p.v
+	for {
+		if !p.filter() {
+			return
+		}
+		RedrawAll()
+		p.Display()
+		screen.Show()
+
+		switch e := PollEvent().(type) {
+		case *tcell.EventKey:
+			switch e.Key() {
+			case tcell.KeyUp, tcell.KeyCtrlP:
+				p.selected--
+			case tcell.KeyDown, tcell.KeyCtrlN:
+				p.selected++
+			case tcell.KeyEnter, tcell.KeyTab:
+				p.Accept(p.shown[p.selected])
+				return
+			case tcell.KeyEscape, tcell.KeyCtrlC:
+				return
+			case tcell.KeyBackspace, tcell.KeyBackspace2:
+				v.HandleEvent(e)
+			case tcell.KeyRune:
+				v.HandleEvent(e)
+				if !isWordRune(e.Rune()) {
+					return
+				}
+			default:
+				v.HandleEvent(e)
+				return
+			}
+		case *tcell.EventMouse:
+			x, y := e.Position()
+			switch e.Buttons() {
+			case tcell.WheelUp:
+				p.selected--
+			case tcell.WheelDown:
+				p.selected++
+			case tcell.Button1:
+				if x >= p.x && x < p.x+p.width && y >= p.y && y < p.y+p.height {
+					p.Accept(p.shown[p.top+y-p.y])
+					return
+				}
+				screen.PostEvent(e)
+				return
+			case tcell.ButtonNone:
+				// The mouse moved or a button was released
+			default:
+				screen.PostEvent(e)
+				return
+			}
+		case *tcell.EventResize:
+			ResizeTabs()
+		}
+	}
+}
+
+// filter narrows the completions down to the ones which start with what has
+// been typed of the word, and keeps the selection on one of them
+// It returns false if the popup should close
+func (p *CompletionPopup) filter() bool {
+	loc := p.v.cursor.Loc()
+	if loc < p.start || p.v.cursor.HasSelection() {
+		return false
+	}
+	prefix := strings.ToLower(p.v.buf.Substr(p.start, loc))
+	p.shown = p.shown[:0]
+	for _, item := range p.items {
+		text := item.FilterText
+		if text == "" {
+			text = item.Label
+		}
+		if strings.HasPrefix(strings.ToLower(text), prefix) {
+			p.shown = append(p.shown, item)
+		}
+	}
+	p.selected = Max(0, Min(p.selected, len(p.shown)-1))
+	if p.selected < p.top {
+		p.top = p.selected
+	}
+	if p.selected >= p.top+maxCompletionRows {
+		p.top = p.selected - maxCompletionRows + 1
+	}
+	return len(p.shown) > 0
+}
+
+// snippetRegex matches the tab stops and placeholders of a snippet
+var snippetRegex = regexp.MustCompile(`\$\{\d+:?([^}]*)\}|\$\d+`)
+
+// Accept replaces the word before the cursor with a completion
+func (p *CompletionPopup) Accept(item lspCompletionItem) {
+	v := p.v
+	text := item.InsertText
+	if text == "" {
+		text = item.Label
+	}
+	start := p.start
+	if item.TextEdit != nil {
+		text = item.TextEdit.NewText
+		start = Min(bufferLoc(v.buf, item.TextEdit.Range.Start), start)
+	}
+	if item.InsertTextFormat == 2 {
+		text = snippetRegex.ReplaceAllString(text, "$1")
+	}
+
+	end := v.cursor.Loc()
+	v.buf.eh.BeginGroup()
+	if end > start {
+		v.buf.eh.Remove(start, end)
+	}
+	v.buf.eh.Insert(start, text)
+	v.buf.eh.EndGroup()
+	v.cursor.SetLoc(start + Count(text))
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+	v.Relocate()
+	v.UpdateLines(v.cursor.y, v.cursor.y)
+}
+
+// Display draws the popup under the start of the word, or over it if there is
+// no room below
+func (p *CompletionPopup) Display() {
+	p.width, p.height = 0, 0
+	x, y, ok := p.v.cursor.ScreenPos()
+	if !ok {
+		return
+	}
+	x -= Count(p.v.buf.Substr(p.start, p.v.cursor.Loc()))
+
+	menuStyle := defStyle.Reverse(true)
+	if style, ok := colorscheme["completion"]; ok {
+		menuStyle = style
+	}
+	selectedStyle := defStyle.Bold(true)
+	if style, ok := colorscheme["completion-selected"]; ok {
+		selectedStyle = style
+	}
+
+	rows := Min(len(p.shown), maxCompletionRows)
+	width := 0
+	lines := make([]string, rows)
+	for i := range lines {
+		item := p.shown[p.top+i]
+		lines[i] = " " + item.Label + " "
+		if item.Detail != "" {
+			lines[i] += " " + item.Detail + " "
+		}
+		width = Max(width, Count(lines[i]))
+	}
+	w, h := screen.Size()
+	width = Min(width, 60)
+	x = Max(0, Min(x, w-width))
+	top := y + 1
+	if top+rows > h-1 && y-rows >= 0 {
+		top = y - rows
+	}
+	p.x, p.y, p.width, p.height = x, top, width, rows
+
+	for i, line := range lines {
+		style := menuStyle
+		if p.top+i == p.selected {
+			style = selectedStyle
+		}
+		runes := []rune(line)
+		for j := 0; j < width; j++ {
+			ch := ' '
+			if j < len(runes) {
+				ch = runes[j]
+			}
+			screen.SetContent(x+j, top+i, ch, nil, style)
+		}
+	}
+}
+
+// RenameCommand renames the symbol under the cursor everywhere, with the help
+// of the language server
+func RenameCommand(view *View, args []string) {
+	if len(args) != 1 {
+		messenger.Error("Invalid rename statement, please use rename name")
+		return
+	}
+	if view.buf.lsp == nil {
+		messenger.Error(errNoLSP.Error())
+		return
+	}
+	edit, err := view.buf.lsp.Rename(view.buf, view.cursor.Loc(), args[0])
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	n, errs := ApplyWorkspaceEdit(edit)
+	if len(errs) > 0 {
+		messenger.Error(errs[0].Error())
+		return
+	}
+	messenger.Message("Renamed in " + strconv.Itoa(n) + " files")
+}
+
+// ApplyWorkspaceEdit makes the edits of a language server in the files
+// A file which is open is changed in its buffer, which is not saved, and the
+// others are written
+// It returns the number of files which were changed, and the errors of the others
+func ApplyWorkspaceEdit(edit lspWorkspaceEdit) (int, []error) {
+	files := make(map[string][]lspTextEdit)
+	for uri, edits := range edit.Changes {
+		files[uriPath(uri)] = append(files[uriPath(uri)], edits...)
+	}
+	for _, change := range edit.DocumentChanges {
+		path := uriPath(change.TextDocument.URI)
+		files[path] = append(files[path], change.Edits...)
+	}
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	changed := 0
+	var errs []error
+	for _, path := range paths {
+		if buf := openBufferAt(path); buf != nil {
+			applyTextEdits(buf, files[path], true)
+		} else if err := applyTextEditsToFile(path, files[path]); err != nil {
+			errs = append(errs, errors.New(relativePath(path)+": "+err.Error()))
+			continue
+		}
+		changed++
+	}
+	return changed, errs
+}
+
+// applyTextEdits makes the edits in the buffer, as a single undo if undoable
+// The positions of the edits are all in the text before the edits
+func applyTextEdits(buf *Buffer, edits []lspTextEdit, undoable bool) {
+	// Go up from the bottom, so that the positions of the other edits stay right
+	sorted := append([]lspTextEdit(nil), edits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Range.Start, sorted[j].Range.Start
+		return a.Line > b.Line || a.Line == b.Line && a.Character > b.Character
+	})
+
+	var views []*View
+	if undoable {
+		views = editViews(buf)
+		buf.eh.BeginGroup()
+	}
+	for _, e := range sorted {
+		start, end := bufferLoc(buf, e.Range.Start), bufferLoc(buf, e.Range.End)
+		if undoable {
+			if end > start {
+				buf.eh.Remove(start, end)
+			}
+			if e.NewText != "" {
+				buf.eh.Insert(start, e.NewText)
+			}
+		} else {
+			buf.Remove(start, end)
+			buf.Insert(start, e.NewText)
+		}
+	}
+	if undoable {
+		buf.eh.EndGroup()
+	}
+	for _, v := range views {
+		v.Clamp()
+		v.UpdateLines(v.topline, v.topline+v.height)
+	}
+}
+
+// applyTextEditsToFile makes the edits in a file which isn't open
+func applyTextEditsToFile(path string, edits []lspTextEdit) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	buf := NewBuffer(string(data), "")
+	applyTextEdits(buf, edits, false)
+	return ioutil.WriteFile(path, []byte(buf.String()), info.Mode())
+}
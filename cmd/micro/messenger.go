@@ -38,13 +38,24 @@ type Messenger struct {
 
 	// Message to print
 	message string
-	// The user's response to a prompt
-	response string
+	// The user's response to a prompt, which is edited after the message
+	input TextInput
 	// style to use when drawing the message
 	style tcell.Style
 
-	// We have to keep track of the cursor for prompting
-	cursorx int
+	// How far back in the history of the prompt the response is (0 when the
+	// user hasn't gone back), and the response the user was typing before
+	historyPos int
+	draft      string
+
+	// The completions which are listed above the prompt, and the one which
+	// is in the response
+	suggestions []string
+	suggestion  int
+	// Where the completed word starts in the response, and whether the last
+	// key was tab, so that tab again goes to the next completion
+	completionStart int
+	completing      bool
 }
 
 // Message sends a message to the user
@@ -79,7 +90,7 @@ func (m *Messenger) YesNoPrompt(prompt string) bool {
 		m.Clear()
 		m.Display()
 		screen.Show()
-		event := screen.PollEvent()
+		event := PollEvent()
 
 		switch e := event.(type) {
 		case *tcell.EventKey:
@@ -94,20 +105,50 @@ func (m *Messenger) YesNoPrompt(prompt string) bool {
 	}
 }
 
+// LetterPrompt asks the user to press one of the letters, and returns the letter
+// It returns false if the user cancels with Escape, Ctrl-q or Ctrl-c
+// Unlike YesNoPrompt, the whole screen is redrawn while waiting, so the user can
+// see what the question is about
+func (m *Messenger) LetterPrompt(prompt string, letters ...rune) (rune, bool) {
+	m.Message(prompt)
+
+	for {
+		RedrawAll()
+		event := PollEvent()
+
+		if e, ok := event.(*tcell.EventKey); ok {
+			switch e.Key() {
+			case tcell.KeyRune:
+				for _, letter := range letters {
+					if e.Rune() == letter {
+						m.Reset()
+						return letter, true
+					}
+				}
+			case tcell.KeyCtrlQ, tcell.KeyCtrlC, tcell.KeyEscape:
+				m.Reset()
+				return 0, false
+			}
+		}
+	}
+}
+
 // Prompt sends the user a message and waits for a response to be typed in
+// Up and down go through the earlier responses to prompts of the same
+// historyType, and tab completes the word before the cursor
 // This function blocks the main loop while waiting for input
-func (m *Messenger) Prompt(prompt string) (string, bool) {
+func (m *Messenger) Prompt(prompt, historyType string, completion Completion) (string, bool) {
 	m.hasPrompt = true
 	m.Message(prompt)
 
 	response, canceled := "", true
 
 	for m.hasPrompt {
-		m.Clear()
-		m.Display()
+		RedrawAll()
 
-		event := screen.PollEvent()
+		event := PollEvent()
 
+		completing := false
 		switch e := event.(type) {
 		case *tcell.EventKey:
 			switch e.Key() {
@@ -117,13 +158,24 @@ func (m *Messenger) Prompt(prompt string) (string, bool) {
 			case tcell.KeyEnter:
 				// User is done entering their response
 				m.hasPrompt = false
-				response, canceled = m.response, false
+				response, canceled = m.input.Text(), false
+				AddToHistory(historyType, response)
+			case tcell.KeyUp:
+				m.HistoryUp(historyType)
+			case tcell.KeyDown:
+				m.HistoryDown(historyType)
+			case tcell.KeyTab:
+				if completion != NoCompletion {
+					m.Complete(completion)
+					completing = m.completing
+				}
 			}
 		}
+		if !completing {
+			m.suggestions, m.completing = nil, false
+		}
 
-		m.HandleEvent(event)
-
-		if m.cursorx < 0 {
+		if m.HandleEvent(event) {
 			// Cancel
 			m.hasPrompt = false
 		}
@@ -133,39 +185,39 @@ func (m *Messenger) Prompt(prompt string) (string, bool) {
 	return response, canceled
 }
 
-// HandleEvent handles an event for the prompter
-func (m *Messenger) HandleEvent(event tcell.Event) {
+// HandleEvent edits the response to the prompt, see TextInput.HandleEvent
+// for the keys, and moves the cursor for a click in the response
+// It returns true if the user pressed backspace with nothing typed, which
+// cancels the prompt
+func (m *Messenger) HandleEvent(event tcell.Event) bool {
 	switch e := event.(type) {
 	case *tcell.EventKey:
 		switch e.Key() {
-		case tcell.KeyLeft:
-			if m.cursorx > 0 {
-				m.cursorx--
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if len(m.input.text) == 0 {
+				return true
 			}
-		case tcell.KeyRight:
-			if m.cursorx < Count(m.response) {
-				m.cursorx++
-			}
-		case tcell.KeyBackspace2:
-			if m.cursorx > 0 {
-				m.response = string([]rune(m.response)[:m.cursorx-1]) + string(m.response[m.cursorx:])
-			}
-			m.cursorx--
-		case tcell.KeySpace:
-			m.response += " "
-			m.cursorx++
-		case tcell.KeyRune:
-			m.response = Insert(m.response, m.cursorx, string(e.Rune()))
-			m.cursorx++
+		}
+		m.input.HandleEvent(e)
+	case *tcell.EventMouse:
+		x, y := e.Position()
+		_, h := screen.Size()
+		if y == h-1 {
+			// The input gets the position from where the response starts
+			m.input.HandleEvent(tcell.NewEventMouse(x-stringWidth(m.message), 0, e.Buttons(), e.Modifiers()))
 		}
 	}
+	return false
 }
 
 // Reset resets the messenger's cursor, message and response
 func (m *Messenger) Reset() {
-	m.cursorx = 0
 	m.message = ""
-	m.response = ""
+	m.input.Clear()
+	m.historyPos = 0
+	m.draft = ""
+	m.suggestions = nil
+	m.completing = false
 }
 
 // Clear clears the line at the bottom of the editor
@@ -177,16 +229,57 @@ func (m *Messenger) Clear() {
 }
 
 // Display displays messages or prompts
+// A response which is too long for the screen scrolls sideways
 func (m *Messenger) Display() {
-	_, h := screen.Size()
+	w, h := screen.Size()
+	cursorx := 0
 	if m.hasMessage {
-		runes := []rune(m.message + m.response)
-		for x := 0; x < len(runes); x++ {
-			screen.SetContent(x, h-1, runes[x], nil, m.style)
+		x := 0
+		for _, ch := range m.message {
+			if x >= w {
+				break
+			}
+			screen.SetContent(x, h-1, ch, nil, m.style)
+			x += runeWidth(ch)
 		}
+		cursorx = m.input.Display(x, h-1, w-x, m.style)
+	}
+	if m.hasPrompt && len(m.suggestions) > 0 {
+		m.DisplaySuggestions()
 	}
 	if m.hasPrompt {
-		screen.ShowCursor(Count(m.message)+m.cursorx, h-1)
+		screen.ShowCursor(cursorx, h-1)
 		screen.Show()
 	}
 }
+
+// DisplaySuggestions lists the completions on the line above the prompt, with
+// the one which is in the response highlighted
+func (m *Messenger) DisplaySuggestions() {
+	w, h := screen.Size()
+	style := defStyle.Reverse(true)
+	if s, ok := colorscheme["statusline"]; ok {
+		style = s
+	}
+	for x := 0; x < w; x++ {
+		screen.SetContent(x, h-2, ' ', nil, style)
+	}
+	x := 0
+	for i, suggestion := range m.suggestions {
+		st := style
+		if i == m.suggestion {
+			st = style.Reverse(false)
+			if s, ok := colorscheme["suggestion-selected"]; ok {
+				st = s
+			}
+		}
+		for _, ch := range suggestion {
+			if x >= w {
+				return
+			}
+			screen.SetContent(x, h-2, ch, nil, st)
+			x++
+		}
+		x++
+	}
+}
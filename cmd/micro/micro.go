@@ -12,8 +12,6 @@ import (
 )
 
 const (
-	synLinesUp           = 75  // How many lines up to look to do syntax highlighting
-	synLinesDown         = 75  // How many lines down to look to do syntax highlighting
 	doubleClickThreshold = 400 // How many milliseconds to wait before a second click is not a double click
 	undoThreshold        = 500 // If two events are less than n milliseconds apart, undo both of them
 )
@@ -34,10 +32,11 @@ var (
 	configDir string
 )
 
-// LoadInput loads the file input for the editor
-func LoadInput() (string, []byte, error) {
+// LoadInput loads the file input for the editor, and returns the buffers to open
+func LoadInput() ([]*Buffer, error) {
 	// There are a number of ways micro should start given its input
-	// 1. If it is given a file in os.Args, it should open that
+	// 1. If it is given files in os.Args, it should open those
+	// With -diff, it is given the two files to compare
 
 	// 2. If there is no input file and the input is not a terminal, that means
 	// something is being piped in and the stdin should be opened in an
@@ -46,28 +45,88 @@ func LoadInput() (string, []byte, error) {
 	// 3. If there is no input file and the input is a terminal, an empty buffer
 	// should be opened
 
-	// These are empty by default so if we get to option 3, we can just returns the
-	// default values
-	var filename string
-	var input []byte
-	var err error
+	var bufs []*Buffer
 
-	if len(os.Args) > 1 {
+	files := os.Args[1:]
+	if diffMode() {
+		if len(files) != 3 {
+			return nil, errors.New("Usage: micro -diff a b")
+		}
+		files = files[1:]
+	}
+
+	if len(files) > 0 {
 		// Option 1
-		filename = os.Args[1]
-		// Check that the file exists
-		if _, e := os.Stat(filename); e == nil {
-			input, err = ioutil.ReadFile(filename)
+		// Files which don't exist yet are opened in empty buffers
+		for _, filename := range files {
+			buf, err := NewBufferFromFile(filename)
+			if err != nil {
+				return nil, err
+			}
+			bufs = append(bufs, buf)
 		}
 	} else if !isatty.IsTerminal(os.Stdin.Fd()) {
 		// Option 2
 		// The input is not a terminal, so something is being piped in
 		// and we should read from stdin
-		input, err = ioutil.ReadAll(os.Stdin)
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, NewBuffer(string(input), ""))
+	} else {
+		// Option 3
+		bufs = append(bufs, NewBuffer("", ""))
 	}
 
-	// Option 3, or just return whatever we got
-	return filename, input, err
+	return bufs, nil
+}
+
+// diffMode returns whether micro was started with -diff, to compare two files
+func diffMode() bool {
+	return len(os.Args) > 1 && os.Args[1] == "-diff"
+}
+
+// PollEvent waits for the next event, for the main loop or for the loop of a
+// prompt which blocks it
+// An interrupt with a function is what work done in the background has to do
+// on the main goroutine, like showing its results, so the function is run here
+// whichever loop is waiting
+func PollEvent() tcell.Event {
+	event := screen.PollEvent()
+	if e, ok := event.(*tcell.EventInterrupt); ok {
+		if f, ok := e.Data().(func()); ok {
+			f()
+		}
+	}
+	return event
+}
+
+// QuitAll exits micro, after making sure that every buffer with unsaved changes
+// can be closed
+func QuitAll() {
+	for _, b := range buffers {
+		if !b.CanClose("Quit anyway? ") {
+			return
+		}
+	}
+	for _, b := range buffers {
+		b.SaveUndo()
+	}
+	ShutdownLSP()
+	screen.Fini()
+	os.Exit(0)
+}
+
+// RedrawAll displays everything: the tabs, the current tab and the messenger
+func RedrawAll() {
+	screen.Clear()
+
+	DisplayTabs()
+	CurTab().Display()
+	messenger.Display()
+
+	screen.Show()
 }
 
 // InitConfigDir finds the configuration directory for micro according to the
@@ -101,20 +160,33 @@ func InitConfigDir() {
 }
 
 func main() {
-	filename, input, err := LoadInput()
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
 	encoding.Register()
 
 	// Find the user's configuration directory (probably $XDG_CONFIG_HOME/micro)
 	InitConfigDir()
 	// Load the user's settings
 	InitSettings()
+	// Load the history of the prompts
+	InitHistory()
 	// Load the syntax files, including the colorscheme
 	LoadSyntaxFiles()
+	InitCommands()
+	// Load the key bindings
+	InitBindings()
+	// Load the language servers, which are started when their files are opened
+	InitLSP()
+	InitLinters()
+	InitFormatters()
+
+	messenger = new(Messenger)
+	// Load the plugins, which can add commands and key bindings
+	LoadPlugins()
+
+	bufs, err := LoadInput()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// Should we enable true color?
 	truecolor := os.Getenv("MICRO_TRUECOLOR") == "1"
@@ -167,48 +239,70 @@ func main() {
 	screen.SetStyle(defStyle)
 	screen.EnableMouse()
 
-	messenger = new(Messenger)
-	view := NewView(NewBuffer(string(input), filename))
+	// Every file is opened in its own tab, unless they are compared
+	if diffMode() {
+		OpenDiff(bufs[0], bufs[1])
+	} else {
+		for _, buf := range bufs {
+			AddTab(buf)
+		}
+	}
+	SetCurTab(0)
 
 	for {
-		// Display everything
-		screen.Clear()
-
-		view.Display()
-		messenger.Display()
-
-		screen.Show()
+		RedrawAll()
 
 		// Wait for the user's action
-		event := screen.PollEvent()
+		event := PollEvent()
+		if _, ok := event.(*tcell.EventInterrupt); ok {
+			// Something running in the background, like a terminal, has
+			// new output, so the screen only needs to be redrawn
+			continue
+		}
+
+		// Views of the same buffer share its undo history, so make sure
+		// the edits are attributed to the view the user is working in
+		view := CurView()
+		view.buf.eh.v = view
 
 		if searching {
 			HandleSearchEvent(event, view)
-		} else {
-			// Check if we should quit
-			switch e := event.(type) {
-			case *tcell.EventKey:
-				switch e.Key() {
-				case tcell.KeyCtrlQ:
-					// Make sure not to quit if there are unsaved changes
-					if view.CanClose("Quit anyway? ") {
-						screen.Fini()
-						os.Exit(0)
-					}
-				case tcell.KeyCtrlE:
-					input, canceled := messenger.Prompt("> ")
-					if !canceled {
-						HandleCommand(input, view)
+			continue
+		}
+
+		switch e := event.(type) {
+		case *tcell.EventResize:
+			ResizeTabs()
+			continue
+		case *tcell.EventKey:
+			if HandlePluginKey(e) {
+				continue
+			}
+		case *tcell.EventMouse:
+			if view.mouseReleased && HandleTabBarEvent(event) {
+				continue
+			}
+			x, y := e.Position()
+			switch e.Buttons() {
+			case tcell.Button1:
+				// Clicking in another view gives it the focus, unless the user
+				// is dragging a selection out of the current one
+				if view.mouseReleased {
+					if v := CurTab().ViewAt(x, y); v != nil && v != view {
+						CurTab().SetCurView(v)
+						view = v
+						view.buf.eh.v = view
 					}
-				case tcell.KeyCtrlG:
-					DisplayHelp()
-					// Make sure to resize the view if the user resized the terminal while looking at the help text
-					view.Resize(screen.Size())
+				}
+			case tcell.WheelUp, tcell.WheelDown:
+				// Scroll the view under the mouse
+				if v := CurTab().ViewAt(x, y); v != nil {
+					view = v
 				}
 			}
-
-			// Send it to the view
-			view.HandleEvent(event)
 		}
+
+		// Send it to the view
+		view.HandleEvent(event)
 	}
 }
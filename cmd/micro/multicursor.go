@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+)
+
+// A view can have extra cursors besides its main one, which are stored in v.cursors
+// The actions in multiCursorActions, and typing, happen at every cursor
+// Only the main cursor is moved by the other actions, and the view follows it
+
+// multiCursorActions are the actions which are run at every cursor
+var multiCursorActions = map[string]bool{
+	"CursorUp":    true,
+	"CursorDown":  true,
+	"CursorLeft":  true,
+	"CursorRight": true,
+	"InsertEnter": true,
+	"InsertSpace": true,
+	"InsertTab":   true,
+	"Backspace":   true,
+	"Paste":       true,
+}
+
+// shift returns where the character at loc is after the event has been executed
+func (t *TextEvent) shift(loc int) int {
+	n := t.end - t.start
+	if t.eventType == TextEventInsert {
+		if loc >= t.start {
+			return loc + n
+		}
+	} else if loc >= t.end {
+		return loc - n
+	} else if loc > t.start {
+		return t.start
+	}
+	return loc
+}
+
+// executeAtCursors executes the event, and moves the extra cursors of the view
+// along with the text around them
+func (v *View) executeAtCursors(t *TextEvent) {
+	locs := make([]int, len(v.cursors))
+	for i := range v.cursors {
+		locs[i] = v.cursors[i].Loc()
+	}
+	ExecuteTextEvent(t)
+	for i := range v.cursors {
+		c := &v.cursors[i]
+		c.SetLoc(t.shift(locs[i]))
+		if c.HasSelection() {
+			c.curSelection[0] = t.shift(c.curSelection[0])
+			c.curSelection[1] = t.shift(c.curSelection[1])
+		}
+	}
+}
+
+// RunAtCursors runs f once for every cursor of the view, with the cursor as v.cursor
+// The edits made at all the cursors are undone together
+func (v *View) RunAtCursors(f func()) {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	f()
+	for i := range v.cursors {
+		// The main cursor waits in the list, so that it is moved by the edits
+		v.cursor, v.cursors[i] = v.cursors[i], v.cursor
+		f()
+		v.cursor, v.cursors[i] = v.cursors[i], v.cursor
+	}
+	v.MergeCursors()
+}
+
+// MergeCursors removes the extra cursors which are at the same place as another cursor
+func (v *View) MergeCursors() {
+	seen := map[int]bool{v.cursor.Loc(): true}
+	cursors := v.cursors[:0]
+	for _, c := range v.cursors {
+		if loc := c.Loc(); !seen[loc] {
+			seen[loc] = true
+			cursors = append(cursors, c)
+		}
+	}
+	v.cursors = cursors
+}
+
+// AddCursor adds a cursor at the location x, y, which becomes the main cursor
+// The old main cursor becomes an extra cursor
+func (v *View) AddCursor(x, y int) {
+	v.cursors = append(v.cursors, v.cursor)
+	v.cursor.x, v.cursor.y = x, y
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+	v.cursor.ResetSelection()
+	v.MergeCursors()
+}
+
+// IsSelected returns whether the character at loc is in the selection of any cursor
+func (v *View) IsSelected(loc int) bool {
+	if v.cursor.IsSelected(loc) {
+		return true
+	}
+	for i := range v.cursors {
+		if v.cursors[i].IsSelected(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpawnMultiCursor selects the next occurrence of the selected text with a new cursor
+// Without a selection, it selects the word under the cursor first
+func (v *View) SpawnMultiCursor() bool {
+	if !v.cursor.HasSelection() {
+		v.cursor.SelectWord()
+		return true
+	}
+
+	sel := v.cursor.GetSelection()
+	end := Max(v.cursor.curSelection[0], v.cursor.curSelection[1])
+	// Look after the main cursor first, and then wrap around to the start
+	text := v.buf.String()
+	runes := []rune(text)
+	after := string(runes[end:])
+	start := -1
+	if i := strings.Index(after, sel); i >= 0 {
+		start = end + Count(after[:i])
+	} else if i := strings.Index(text, sel); i >= 0 {
+		start = Count(text[:i])
+	}
+	if start < 0 || start == Min(v.cursor.curSelection[0], v.cursor.curSelection[1]) {
+		return true
+	}
+
+	for _, c := range v.cursors {
+		if c.HasSelection() && Min(c.curSelection[0], c.curSelection[1]) == start {
+			messenger.Message("Every occurrence already has a cursor")
+			return true
+		}
+	}
+
+	x, y := FromCharPos(start+Count(sel), v.buf)
+	v.AddCursor(x, y)
+	v.cursor.curSelection[0] = start
+	v.cursor.curSelection[1] = start + Count(sel)
+	return true
+}
+
+// RemoveAllMultiCursors removes the extra cursors, leaving only the main one
+func (v *View) RemoveAllMultiCursors() bool {
+	v.cursors = nil
+	return true
+}
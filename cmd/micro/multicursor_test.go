@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"testing"
+)
+
+func TestMultiCursor(t *testing.T) {
+	settings = DefaultSettings()
+	messenger = new(Messenger)
+	eh := newTestEventHandler("foo bar\nfoo baz foo")
+	v := eh.v
+
+	// The first spawn selects the word, and the next ones add cursors
+	v.SpawnMultiCursor()
+	v.SpawnMultiCursor()
+	v.SpawnMultiCursor()
+	if len(v.cursors) != 2 {
+		t.Fatalf("There are %d extra cursors, want 2", len(v.cursors))
+	}
+	// Every occurrence has a cursor, so nothing more is added
+	v.SpawnMultiCursor()
+	if len(v.cursors) != 2 {
+		t.Fatalf("There are %d extra cursors, want 2", len(v.cursors))
+	}
+
+	// Typing replaces the selections, and the cursors after an edit move along
+	for _, r := range "ab" {
+		v.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	if got := v.buf.String(); got != "ab bar\nab baz ab" {
+		t.Errorf("Typing at every cursor gave %q", got)
+	}
+	v.RunAtCursors(func() { v.Backspace() })
+	if got := v.buf.String(); got != "a bar\na baz a" {
+		t.Errorf("Backspace at every cursor gave %q", got)
+	}
+
+	// Cursors which end up at the same place are merged
+	v.RunAtCursors(func() { v.CursorUp() })
+	if len(v.cursors) != 1 {
+		t.Errorf("There are %d extra cursors after moving up, want 1", len(v.cursors))
+	}
+
+	// All the edits are a single undo
+	eh.UndoGroup()
+	if got := v.buf.String(); got != "ab bar\nab baz ab" {
+		t.Errorf("Undoing the backspaces gave %q", got)
+	}
+}
+
+func TestTextEventShift(t *testing.T) {
+	insert := &TextEvent{eventType: TextEventInsert, start: 5, end: 8}
+	remove := &TextEvent{eventType: TextEventRemove, start: 5, end: 8}
+	tests := []struct {
+		t         *TextEvent
+		loc, want int
+	}{
+		{insert, 4, 4},
+		{insert, 5, 8},
+		{insert, 10, 13},
+		{remove, 4, 4},
+		{remove, 6, 5},
+		{remove, 8, 5},
+		{remove, 10, 7},
+	}
+	for _, test := range tests {
+		if got := test.t.shift(test.loc); got != test.want {
+			t.Errorf("Shifting %d by %v gave %d, want %d", test.loc, *test.t, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A Plugin is a Lua script loaded from $(configDir)/plugins
+// Every plugin runs in its own environment, so that plugins can define
+// hooks and helper functions with the same names
+type Plugin struct {
+	name string
+	env  *lua.LTable
+}
+
+var (
+	// The Lua interpreter which runs every plugin
+	luaState *lua.LState
+	// The loaded plugins, in the order they were loaded
+	plugins []*Plugin
+	// The Lua functions bound to keys by plugins
+	pluginBindings map[Key]*lua.LFunction
+)
+
+// LoadPlugins starts the Lua interpreter and loads the plugins from $(configDir)/plugins
+// A plugin is either a file plugins/name.lua or a directory plugins/name containing name.lua
+// This must be called before the screen is initialized, because errors are reported with TermMessage
+// Loading them again starts over with a new interpreter
+func LoadPlugins() {
+	if luaState != nil {
+		luaState.Close()
+	}
+	plugins = nil
+	luaState = lua.NewState()
+	luaState.SetGlobal("micro", luaState.SetFuncs(luaState.NewTable(), pluginAPI))
+	pluginBindings = make(map[Key]*lua.LFunction)
+
+	dir := configDir + "/plugins"
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		name := f.Name()
+		path := filepath.Join(dir, name)
+		if f.IsDir() {
+			path = filepath.Join(path, name+".lua")
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+		} else if filepath.Ext(name) == ".lua" {
+			name = strings.TrimSuffix(name, ".lua")
+		} else {
+			continue
+		}
+
+		if err := LoadPlugin(name, path); err != nil {
+			TermMessage("Error loading plugin " + name + ": " + err.Error())
+		}
+	}
+}
+
+// LoadPlugin runs the Lua file at path as the plugin called name
+func LoadPlugin(name, path string) error {
+	fn, err := luaState.LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Globals which the plugin defines go into its environment, but it can
+	// still see the real globals (like the micro table)
+	env := luaState.NewTable()
+	mt := luaState.NewTable()
+	mt.RawSetString("__index", luaState.Get(lua.GlobalsIndex))
+	luaState.SetMetatable(env, mt)
+	fn.Env = env
+
+	luaState.Push(fn)
+	if err := luaState.PCall(0, 0, nil); err != nil {
+		return err
+	}
+
+	plugins = append(plugins, &Plugin{name, env})
+	// Other plugins can use the functions of this one through a global with its name
+	luaState.SetGlobal(name, env)
+	return nil
+}
+
+// callLua calls a Lua function with the given arguments, and returns whether it returned true
+// Errors are shown to the user
+func callLua(fn *lua.LFunction, args ...lua.LValue) bool {
+	err := luaState.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...)
+	if err != nil {
+		messenger.Error("Plugin error: " + err.Error())
+		return false
+	}
+	ret := luaState.Get(-1)
+	luaState.Pop(1)
+	return lua.LVAsBool(ret)
+}
+
+// RunHook calls the function called hook in every plugin that defines it, with the given arguments
+// The hooks are onSave(path), onOpen(path) and onKey(key)
+// It returns true if any of the hooks returned true, which for onKey means that
+// the key should not be handled by micro
+func RunHook(hook string, args ...string) bool {
+	if luaState == nil {
+		return false
+	}
+
+	var luaArgs []lua.LValue
+	for _, arg := range args {
+		luaArgs = append(luaArgs, lua.LString(arg))
+	}
+
+	handled := false
+	for _, p := range plugins {
+		if fn, ok := p.env.RawGetString(hook).(*lua.LFunction); ok {
+			if callLua(fn, luaArgs...) {
+				handled = true
+			}
+		}
+	}
+	return handled
+}
+
+// hookBuffer is the buffer the plugin API works on while the onOpen hooks run
+// It isn't shown in a view yet, so it is edited without a cursor
+var hookBuffer *Buffer
+
+// RunOpenHook runs the onOpen hooks for a buffer which is being opened, with
+// the plugin API working on that buffer rather than on the focused view
+func RunOpenHook(buf *Buffer) {
+	if luaState == nil {
+		return
+	}
+	if NumViews(buf) == 0 {
+		buf.eh.v = nil
+	}
+	hookBuffer = buf
+	defer func() { hookBuffer = nil }()
+	RunHook("onOpen", buf.path)
+}
+
+// HandlePluginKey runs the onKey hooks and the plugin bindings for a key press
+// It returns true if a plugin handled the key
+func HandlePluginKey(e *tcell.EventKey) bool {
+	if luaState == nil {
+		return false
+	}
+	k := KeyFromEvent(e)
+	if RunHook("onKey", k.String()) {
+		return true
+	}
+	if fn, ok := pluginBindings[k]; ok {
+		callLua(fn)
+		return true
+	}
+	return false
+}
+
+// pluginAPI is the micro table which plugins use to control the editor
+// Locations in the buffer are 0 based, like everywhere else in micro
+var pluginAPI = map[string]lua.LGFunction{
+	"message": func(L *lua.LState) int {
+		messenger.Message(L.CheckString(1))
+		return 0
+	},
+	"error": func(L *lua.LState) int {
+		messenger.Error(L.CheckString(1))
+		return 0
+	},
+	// command(name, fn) adds a command which calls fn with a table of the arguments
+	"command": func(L *lua.LState) int {
+		name := L.CheckString(1)
+		fn := L.CheckFunction(2)
+		MakeCommand(name, func(view *View, args []string) {
+			t := luaState.NewTable()
+			for _, arg := range args {
+				t.Append(lua.LString(arg))
+			}
+			callLua(fn, t)
+		})
+		return 0
+	},
+	// bind(key, fn) calls fn when the key is pressed, instead of what micro does
+	"bind": func(L *lua.LState) int {
+		k, ok := ParseKey(L.CheckString(1))
+		if !ok {
+			L.ArgError(1, "invalid key "+L.CheckString(1))
+		}
+		pluginBindings[k] = L.CheckFunction(2)
+		return 0
+	},
+	// run(command) runs a command as if the user had typed it in the command prompt
+	// It runs in the view which has the focus, even in an onOpen hook
+	"run": func(L *lua.LState) int {
+		if len(tabs) == 0 {
+			L.RaiseError("there is no view yet")
+		}
+		HandleCommand(L.CheckString(1), CurView())
+		return 0
+	},
+	"path": func(L *lua.LState) int {
+		L.Push(lua.LString(pluginBuffer(L).path))
+		return 1
+	},
+	"filetype": func(L *lua.LState) int {
+		L.Push(lua.LString(pluginBuffer(L).filetype))
+		return 1
+	},
+	"text": func(L *lua.LState) int {
+		L.Push(lua.LString(pluginBuffer(L).String()))
+		return 1
+	},
+	"line": func(L *lua.LState) int {
+		L.Push(lua.LString(pluginBuffer(L).Line(L.CheckInt(1))))
+		return 1
+	},
+	"numlines": func(L *lua.LState) int {
+		L.Push(lua.LNumber(pluginBuffer(L).NumLines()))
+		return 1
+	},
+	// insert(x, y, text) inserts text at the location x, y
+	"insert": func(L *lua.LState) int {
+		buf := pluginBuffer(L)
+		buf.eh.Insert(ToCharPos(L.CheckInt(1), L.CheckInt(2), buf), L.CheckString(3))
+		return 0
+	},
+	// remove(x1, y1, x2, y2) removes the text from x1, y1 up to (but not including) x2, y2
+	"remove": func(L *lua.LState) int {
+		buf := pluginBuffer(L)
+		start := ToCharPos(L.CheckInt(1), L.CheckInt(2), buf)
+		end := ToCharPos(L.CheckInt(3), L.CheckInt(4), buf)
+		buf.eh.Remove(start, end)
+		return 0
+	},
+	// cursor() returns the x, y location of the cursor
+	"cursor": func(L *lua.LState) int {
+		v := pluginView(L)
+		L.Push(lua.LNumber(v.cursor.x))
+		L.Push(lua.LNumber(v.cursor.y))
+		return 2
+	},
+	"setcursor": func(L *lua.LState) int {
+		v := pluginView(L)
+		v.cursor.x, v.cursor.y = L.CheckInt(1), L.CheckInt(2)
+		v.Clamp()
+		v.cursor.ResetSelection()
+		v.Relocate()
+		return 0
+	},
+	"selection": func(L *lua.LState) int {
+		L.Push(lua.LString(pluginView(L).cursor.GetSelection()))
+		return 1
+	},
+}
+
+// pluginBuffer returns the buffer the plugin API functions work on: the buffer
+// of the view which has the focus, or the buffer being opened in an onOpen hook
+func pluginBuffer(L *lua.LState) *Buffer {
+	if hookBuffer != nil {
+		return hookBuffer
+	}
+	return pluginView(L).buf
+}
+
+// pluginView returns the view which has the focus, for the plugin API functions
+// There are no views while the plugins are being loaded, and the buffer of an
+// onOpen hook isn't shown yet, so this raises a Lua error then
+func pluginView(L *lua.LState) *View {
+	if len(tabs) == 0 || hookBuffer != nil {
+		L.RaiseError("there is no view yet")
+	}
+	return CurView()
+}
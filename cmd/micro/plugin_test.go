@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := `
+saved = {}
+function onSave(path)
+	table.insert(saved, path)
+end
+function onOpen(path)
+	opened = micro.numlines()
+	micro.insert(0, 0, "x")
+end
+function onKey(key)
+	return key == "Ctrl-K"
+end
+micro.command("greet", function(args)
+	micro.message("hello " .. args[1])
+end)
+micro.bind("Alt-x", function()
+	micro.message("bound")
+end)
+`
+	os.Mkdir(filepath.Join(dir, "plugins"), 0755)
+	path := filepath.Join(dir, "plugins", "test.lua")
+	if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	messenger = new(Messenger)
+	InitCommands()
+	oldConfigDir := configDir
+	configDir = dir
+	defer func() {
+		configDir = oldConfigDir
+		luaState.Close()
+		luaState, plugins, pluginBindings = nil, nil, nil
+	}()
+	LoadPlugins()
+	// Loading the plugins again doesn't keep the old ones
+	LoadPlugins()
+	if len(plugins) != 1 || plugins[0].name != "test" {
+		t.Fatalf("Loaded %d plugins", len(plugins))
+	}
+
+	HandleCommand("greet world", nil)
+	if messenger.message != "hello world" {
+		t.Errorf("The plugin command gave the message %q", messenger.message)
+	}
+
+	if !HandlePluginKey(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt)) || messenger.message != "bound" {
+		t.Error("The plugin binding wasn't run")
+	}
+	if !HandlePluginKey(tcell.NewEventKey(tcell.KeyCtrlK, 0, tcell.ModCtrl)) {
+		t.Error("The onKey hook didn't handle Ctrl-K")
+	}
+	if HandlePluginKey(tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone)) {
+		t.Error("A key without a binding was handled")
+	}
+
+	// onOpen works on the buffer being opened, which isn't in a view yet
+	buf := NewBuffer("a\nb\nc", "")
+	AddBuffer(buf)
+	CloseBuffer(buf)
+	if n := plugins[0].env.RawGetString("opened"); n.String() != "3" {
+		t.Errorf("onOpen saw %s lines, want 3", n)
+	}
+	if buf.Line(0) != "xa" || buf.eh.v != nil {
+		t.Errorf("onOpen changed the first line to %q", buf.Line(0))
+	}
+
+	RunHook("onSave", "a.txt")
+	if n := luaState.ObjLen(plugins[0].env.RawGetString("saved")); n != 1 {
+		t.Errorf("onSave was called %d times", n)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	names := []string{"Ctrl-S", "Alt-Up", "Shift-Home", "PgDn", "x", "Alt-,", "Ctrl-Alt-Left", "Space", "Enter", "F5"}
+	for _, name := range names {
+		k, ok := ParseKey(name)
+		if !ok {
+			t.Errorf("ParseKey(%q) failed", name)
+			continue
+		}
+		if k.String() != name {
+			t.Errorf("ParseKey(%q).String() = %q", name, k.String())
+		}
+	}
+
+	if k, _ := ParseKey("Ctrl-s"); k.keyCode != tcell.KeyCtrlS {
+		t.Errorf("ParseKey(\"Ctrl-s\") = %v", k)
+	}
+	if _, ok := ParseKey("NotAKey"); ok {
+		t.Error("ParseKey accepted NotAKey")
+	}
+}
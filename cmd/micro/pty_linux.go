@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl runs an ioctl request on the file descriptor
+func ioctl(fd, request, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPty opens a new pseudo-terminal, and returns its master side, which the
+// terminal pane reads and writes, and its slave side, which the shell runs in
+func openPty() (*os.File, *os.File, error) {
+	pty, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var n uint32
+	if err := ioctl(pty.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+	var unlock int32
+	if err := ioctl(pty.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+
+	tty, err := os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		pty.Close()
+		return nil, nil, err
+	}
+	return pty, tty, nil
+}
+
+// setPtySize tells the pseudo-terminal how many columns and rows it has, so
+// that the programs running in it can lay out their output
+func setPtySize(pty *os.File, w, h int) error {
+	size := struct {
+		rows, cols, x, y uint16
+	}{uint16(h), uint16(w), 0, 0}
+	return ioctl(pty.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+// ptyAttr returns the attributes for a process which runs in a pseudo-terminal
+// It gets a session of its own, with the terminal as its controlling terminal
+func ptyAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true}
+}
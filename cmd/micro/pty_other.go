@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errPtyUnsupported is the error for opening a terminal on a system where
+// micro can't make pseudo-terminals
+var errPtyUnsupported = errors.New("the terminal is not supported on this system")
+
+// openPty is not supported on this system
+func openPty() (*os.File, *os.File, error) {
+	return nil, nil, errPtyUnsupported
+}
+
+// setPtySize is not supported on this system
+func setPtySize(pty *os.File, w, h int) error {
+	return errPtyUnsupported
+}
+
+// ptyAttr is not supported on this system
+func ptyAttr() *syscall.SysProcAttr {
+	return nil
+}
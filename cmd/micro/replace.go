@@ -0,0 +1,87 @@
+package main
+
+import (
+	"regexp"
+)
+
+// A Replacement is a match of a search, and the text which replaces it
+// The locations are character positions in the buffer as it was before any
+// of the replacements were made
+type Replacement struct {
+	start int
+	end   int
+	text  string
+}
+
+// FindReplacements finds the matches of the regex in the buffer between the
+// characters start and end, along with what they are replaced with
+// The replacement is a template where $1, ${1} or $name are replaced with the
+// text of a group of the match, like in regexp.Expand
+// All the matches are found before anything is replaced, so a replacement can
+// never be matched again (replacing 'a' with 'aa' terminates)
+func FindReplacements(buf *Buffer, regex *regexp.Regexp, replace string, start, end int) []Replacement {
+	text := buf.Substr(start, end)
+
+	var replacements []Replacement
+	// The regex gives byte indices, but the buffer is indexed by runes
+	// The matches are in order, so the rune offsets are counted as we go
+	charPos, bytePos := start, 0
+	toChars := func(i int) int {
+		charPos += Count(text[bytePos:i])
+		bytePos = i
+		return charPos
+	}
+	for _, m := range regex.FindAllStringSubmatchIndex(text, -1) {
+		r := Replacement{
+			start: toChars(m[0]),
+			end:   toChars(m[1]),
+			text:  string(regex.ExpandString(nil, replace, text, m)),
+		}
+		replacements = append(replacements, r)
+	}
+	return replacements
+}
+
+// ReplaceMatches makes the replacements in the view's buffer, from the first to the last
+// If confirm is true, each match is selected and the user is asked whether to
+// replace it: yes, no, all (the rest without asking) or quit
+// All the replacements are undone together
+// It returns how many matches were replaced
+func ReplaceMatches(v *View, replacements []Replacement, confirm bool) int {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	// How far the replacements so far have moved the rest of the text
+	shift := 0
+	replaced := 0
+	for _, r := range replacements {
+		start, end := r.start+shift, r.end+shift
+		if confirm {
+			v.cursor.SetLoc(start)
+			v.cursor.curSelection = [2]int{start, end}
+			v.Relocate()
+			answer, ok := messenger.LetterPrompt("Replace? (y,n,a,q)", 'y', 'n', 'a', 'q')
+			v.cursor.ResetSelection()
+			if !ok || answer == 'q' {
+				break
+			}
+			if answer == 'n' {
+				continue
+			}
+			if answer == 'a' {
+				confirm = false
+			}
+		}
+
+		if end > start {
+			v.buf.eh.Remove(start, end)
+		}
+		if r.text != "" {
+			v.buf.eh.Insert(start, r.text)
+		}
+		shift += Count(r.text) - (r.end - r.start)
+		replaced++
+	}
+	v.UpdateLines(v.topline, v.topline+v.height)
+	return replaced
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestReplace(t *testing.T) {
+	messenger = new(Messenger)
+	eh := newTestEventHandler("aé a\nb a")
+	v := eh.v
+
+	// The replacement is never matched again
+	Replace(v, []string{"a", "aa"})
+	if got := v.buf.String(); got != "aaé aa\nb aa" {
+		t.Errorf("Replacing a with aa gave %q", got)
+	}
+
+	// The whole replace is undone at once
+	eh.UndoGroup()
+	if got := v.buf.String(); got != "aé a\nb a" {
+		t.Errorf("Undo gave %q", got)
+	}
+
+	// Groups of the match can be used in the replacement
+	Replace(v, []string{`"(\w)é"`, `"<${1}>"`})
+	if got := v.buf.String(); got != "<a> a\nb a" {
+		t.Errorf("Replacing with a group gave %q", got)
+	}
+
+	// Only the selection is replaced
+	v.cursor.curSelection = [2]int{ToCharPos(0, 1, v.buf), v.buf.Len()}
+	Replace(v, []string{"a", "c"})
+	if got := v.buf.String(); got != "<a> a\nb c" {
+		t.Errorf("Replacing in the selection gave %q", got)
+	}
+}
+
+func TestFindReplacements(t *testing.T) {
+	b := NewBuffer("x1é y22 z", "")
+	got := FindReplacements(b, regexp.MustCompile(`(\d+)`), "[$1]", 0, b.Len())
+	want := []Replacement{{1, 2, "[1]"}, {5, 7, "[22]"}}
+	if len(got) != len(want) {
+		t.Fatalf("Found %d replacements, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Replacement %d is %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Empty matches don't repeat forever
+	if got := FindReplacements(b, regexp.MustCompile(`q*`), "-", 0, 3); len(got) != 4 {
+		t.Errorf("Found %d empty matches in 3 characters, want 4", len(got))
+	}
+}
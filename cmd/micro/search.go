@@ -3,6 +3,9 @@ package main
 import (
 	"github.com/gdamore/tcell"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 var (
@@ -14,13 +17,16 @@ var (
 
 	// Is there currently a search in progress
 	searching bool
+
+	// Are the matches of the last search highlighted
+	searchHighlight bool
 )
 
 // BeginSearch starts a search
 func BeginSearch() {
 	searching = true
 	messenger.hasPrompt = true
-	messenger.Message("Find: ")
+	messenger.Message(SearchPrompt())
 }
 
 // EndSearch stops the current search
@@ -31,86 +37,224 @@ func EndSearch() {
 	messenger.Reset()
 }
 
+// SearchPrompt returns the prompt of the search, which shows the search options that are on
+func SearchPrompt() string {
+	var options []string
+	if settings.IgnoreCase && settings.SmartCase {
+		options = append(options, "smart case")
+	} else if settings.IgnoreCase {
+		options = append(options, "ignore case")
+	}
+	if settings.LiteralSearch {
+		options = append(options, "literal")
+	}
+	if settings.WholeWord {
+		options = append(options, "whole word")
+	}
+	if len(options) == 0 {
+		return "Find: "
+	}
+	return "Find (" + strings.Join(options, ", ") + "): "
+}
+
+// SearchRegex compiles a search with the search options
+// With ignorecase the search ignores case, unless smartcase is on too and the
+// search has capital letters in it
+func SearchRegex(search string) (*regexp.Regexp, error) {
+	ignoreCase := settings.IgnoreCase && !(settings.SmartCase && strings.ToLower(search) != search)
+	if settings.LiteralSearch {
+		search = regexp.QuoteMeta(search)
+	}
+	if settings.WholeWord {
+		search = `\b(?:` + search + `)\b`
+	}
+	if ignoreCase {
+		search = "(?i)" + search
+	}
+	return regexp.Compile(search)
+}
+
 // HandleSearchEvent takes an event and a view and will do a real time match from the messenger's output
 // to the current buffer. It searches down the buffer.
+// Alt-c, Alt-r and Alt-w toggle ignoring case, literal search and whole word search
+// Up and down go through the earlier searches
 func HandleSearchEvent(event tcell.Event, v *View) {
+	toggled := false
 	switch e := event.(type) {
 	case *tcell.EventKey:
 		switch e.Key() {
-		case tcell.KeyCtrlQ, tcell.KeyCtrlC, tcell.KeyEscape, tcell.KeyEnter:
+		case tcell.KeyEnter:
 			// Done
+			AddToHistory("Search", messenger.input.Text())
 			EndSearch()
 			return
+		case tcell.KeyCtrlQ, tcell.KeyCtrlC, tcell.KeyEscape:
+			// Canceled, so the matches don't need to be highlighted anymore
+			searchHighlight = false
+			EndSearch()
+			return
+		case tcell.KeyUp:
+			messenger.HistoryUp("Search")
+		case tcell.KeyDown:
+			messenger.HistoryDown("Search")
+		case tcell.KeyRune:
+			if e.Modifiers()&tcell.ModAlt != 0 {
+				switch e.Rune() {
+				case 'c':
+					settings.IgnoreCase = !settings.IgnoreCase
+				case 'r':
+					settings.LiteralSearch = !settings.LiteralSearch
+				case 'w':
+					settings.WholeWord = !settings.WholeWord
+				}
+				toggled = true
+			}
 		}
 	}
 
-	messenger.HandleEvent(event)
-
-	if messenger.cursorx < 0 {
-		// Done
+	if toggled {
+		messenger.Message(SearchPrompt())
+	} else if messenger.HandleEvent(event) {
+		// Backspace with nothing typed cancels the search
+		searchHighlight = false
 		EndSearch()
 		return
 	}
 
-	if messenger.response == "" {
+	search := messenger.input.Text()
+	if search == "" {
 		v.cursor.ResetSelection()
 		// We don't end the search though
 		return
 	}
 
-	Search(messenger.response, v, true)
-
-	return
+	if err := Search(search, v, true); err != nil {
+		messenger.Error(strings.TrimSuffix(SearchPrompt(), ": ") + " [invalid regex]: ")
+	} else {
+		messenger.Message(SearchPrompt())
+	}
 }
 
 // Search searches in the view for the given regex. The down bool
 // specifies whether it should search down from the searchStart position
 // or up from there
-func Search(searchStr string, v *View, down bool) {
+// It returns an error if the search is not a valid regex
+func Search(searchStr string, v *View, down bool) error {
 	if searchStr == "" {
-		return
-	}
-	var str string
-	var charPos int
-	if down {
-		str = v.buf.text[searchStart:]
-		charPos = searchStart
-	} else {
-		str = v.buf.text[:searchStart]
+		return nil
 	}
-	r, err := regexp.Compile(searchStr)
+	r, err := SearchRegex(searchStr)
 	if err != nil {
-		return
+		return err
 	}
-	matches := r.FindAllStringIndex(str, -1)
-	var match []int
-	if matches == nil {
-		// Search the entire buffer now
-		matches = r.FindAllStringIndex(v.buf.text, -1)
-		charPos = 0
-		if matches == nil {
-			v.cursor.ResetSelection()
-			return
-		}
+	lastSearch = searchStr
+	searchHighlight = true
 
-		if !down {
-			match = matches[len(matches)-1]
-		} else {
-			match = matches[0]
-		}
+	matches := v.buf.SearchMatches(r)
+	if len(matches) == 0 {
+		v.cursor.ResetSelection()
+		return nil
 	}
 
-	if !down {
-		match = matches[len(matches)-1]
+	// Take the first match after searchStart, or the last one before it,
+	// and wrap around the buffer if there is none
+	var match [2]int
+	if down {
+		i := sort.Search(len(matches), func(i int) bool { return matches[i][0] >= searchStart })
+		if i == len(matches) {
+			i = 0
+		}
+		match = matches[i]
 	} else {
-		match = matches[0]
+		i := sort.Search(len(matches), func(i int) bool { return matches[i][1] > searchStart }) - 1
+		if i < 0 {
+			i = len(matches) - 1
+		}
+		match = matches[i]
 	}
 
-	v.cursor.curSelection[0] = charPos + match[0]
-	v.cursor.curSelection[1] = charPos + match[1]
-	v.cursor.x, v.cursor.y = FromCharPos(charPos+match[1]-1, v.buf)
+	v.cursor.curSelection[0] = match[0]
+	v.cursor.curSelection[1] = match[1]
+	v.cursor.x, v.cursor.y = FromCharPos(match[1]-1, v.buf)
 	if v.Relocate() {
 		v.matches = Match(v)
 	}
-	lastSearch = searchStr
+	return nil
+}
+
+// SearchMatches returns the matches of the regex in the buffer as ranges of characters
+// Empty matches are left out, because there is nothing to select or highlight
+// The matches are kept until the text or the regex changes, so this can be
+// called for every frame
+func (b *Buffer) SearchMatches(r *regexp.Regexp) [][2]int {
+	if r.String() == b.searchRegex && b.r.Hash() == b.searchHash {
+		return b.searchMatches
+	}
+
+	text := b.String()
+	var matches [][2]int
+	// The regex gives byte indices, but the buffer is indexed by runes
+	// The matches are in order, so the rune offsets are counted as we go
+	charPos, bytePos := 0, 0
+	toChars := func(i int) int {
+		charPos += Count(text[bytePos:i])
+		bytePos = i
+		return charPos
+	}
+	for _, m := range r.FindAllStringIndex(text, -1) {
+		if m[1] > m[0] {
+			matches = append(matches, [2]int{toChars(m[0]), toChars(m[1])})
+		}
+	}
+
+	b.searchMatches = matches
+	b.searchRegex = r.String()
+	b.searchHash = b.r.Hash()
+	return matches
+}
+
+// HighlightedMatches returns the matches of the last search in the view's
+// buffer, if they are highlighted
+func HighlightedMatches(v *View) [][2]int {
+	if !searchHighlight || lastSearch == "" {
+		return nil
+	}
+	r, err := SearchRegex(lastSearch)
+	if err != nil {
+		return nil
+	}
+	return v.buf.SearchMatches(r)
+}
+
+// InMatch returns whether the character at loc is in one of the matches,
+// which must be sorted
+func InMatch(matches [][2]int, loc int) bool {
+	i := sort.Search(len(matches), func(i int) bool { return matches[i][1] > loc })
+	return i < len(matches) && matches[i][0] <= loc
+}
+
+// SearchStatus returns which match of the last search is selected, like
+// "match 2 of 5", for the statusline
+// It returns "" if the matches aren't highlighted
+func SearchStatus(v *View) string {
+	matches := HighlightedMatches(v)
+	if matches == nil {
+		if searchHighlight && lastSearch != "" {
+			return "no matches"
+		}
+		return ""
+	}
+
+	sel := [2]int{
+		Min(v.cursor.curSelection[0], v.cursor.curSelection[1]),
+		Max(v.cursor.curSelection[0], v.cursor.curSelection[1]),
+	}
+	i := sort.Search(len(matches), func(i int) bool { return matches[i][0] >= sel[0] })
+	if i < len(matches) && matches[i] == sel {
+		return "match " + strconv.Itoa(i+1) + " of " + strconv.Itoa(len(matches))
+	}
+	if len(matches) == 1 {
+		return "1 match"
+	}
+	return strconv.Itoa(len(matches)) + " matches"
 }
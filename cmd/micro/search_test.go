@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSearchRegex(t *testing.T) {
+	defer func() { settings = DefaultSettings() }()
+
+	tests := []struct {
+		ignoreCase, smartCase, literal, wholeWord bool
+		search, text                              string
+		want                                      bool
+	}{
+		{false, false, false, false, "foo", "FOO", false},
+		{true, false, false, false, "foo", "FOO", true},
+		{true, true, false, false, "foo", "FOO", true},
+		{true, true, false, false, "Foo", "FOO", false},
+		{false, false, true, false, "a.c", "abc", false},
+		{false, false, true, false, "a.c", "a.c", true},
+		{false, false, false, true, "foo", "foobar", false},
+		{false, false, false, true, "foo|bar", "x bar", true},
+	}
+	for _, test := range tests {
+		settings.IgnoreCase = test.ignoreCase
+		settings.SmartCase = test.smartCase
+		settings.LiteralSearch = test.literal
+		settings.WholeWord = test.wholeWord
+		r, err := SearchRegex(test.search)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.MatchString(test.text); got != test.want {
+			t.Errorf("%+v matched %v", test, got)
+		}
+	}
+
+	settings = DefaultSettings()
+	if _, err := SearchRegex("a("); err == nil {
+		t.Error("An invalid regex gave no error")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	settings = DefaultSettings()
+	defer func() { searchHighlight = false }()
+	eh := newTestEventHandler("é ab\nab é ab")
+	v := eh.v
+
+	searchStart = 3
+	Search("ab", v, true)
+	if got := SearchStatus(v); got != "match 2 of 3" {
+		t.Errorf("Searching down gave %q", got)
+	}
+	if v.cursor.curSelection != [2]int{5, 7} {
+		t.Errorf("The selection is %v, want [5 7]", v.cursor.curSelection)
+	}
+
+	// The search wraps around the buffer
+	searchStart = 2
+	Search("ab", v, false)
+	if got := SearchStatus(v); got != "match 3 of 3" {
+		t.Errorf("Searching up gave %q", got)
+	}
+
+	// The matches are found again when the text changes
+	eh.Insert(0, "ab")
+	if got := SearchStatus(v); got != "4 matches" {
+		t.Errorf("After an edit the status is %q", got)
+	}
+	if !InMatch(HighlightedMatches(v), 1) || InMatch(HighlightedMatches(v), 2) {
+		t.Error("InMatch is wrong")
+	}
+}
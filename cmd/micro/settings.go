@@ -12,7 +12,8 @@ import (
 var settings Settings
 
 // All the possible settings
-var possibleSettings = []string{"colorscheme", "tabsize", "autoindent", "syntax", "tabsToSpaces"}
+var possibleSettings = []string{"colorscheme", "tabsize", "autoindent", "syntax", "tabsToSpaces", "persistentundo", "softwrap",
+	"ignorecase", "smartcase", "literalsearch", "wholeword", "formatonsave"}
 
 // The Settings struct contains the settings for micro
 type Settings struct {
@@ -21,6 +22,16 @@ type Settings struct {
 	AutoIndent   bool   `json:"autoindent"`
 	Syntax       bool   `json:"syntax"`
 	TabsToSpaces bool   `json:"tabsToSpaces"`
+
+	PersistentUndo bool `json:"persistentundo"`
+	Softwrap       bool `json:"softwrap"`
+
+	IgnoreCase    bool `json:"ignorecase"`
+	SmartCase     bool `json:"smartcase"`
+	LiteralSearch bool `json:"literalsearch"`
+	WholeWord     bool `json:"wholeword"`
+
+	FormatOnSave bool `json:"formatonsave"`
 }
 
 // InitSettings initializes the options map and sets all options to their default values
@@ -33,6 +44,9 @@ func InitSettings() {
 			return
 		}
 
+		// Start from the defaults so that options which are missing from
+		// the file (because they are newer than it) get a sensible value
+		settings = DefaultSettings()
 		json.Unmarshal(input, &settings)
 	} else {
 		settings = DefaultSettings()
@@ -61,6 +75,16 @@ func DefaultSettings() Settings {
 		AutoIndent:   true,
 		Syntax:       true,
 		TabsToSpaces: false,
+
+		PersistentUndo: true,
+		Softwrap:       false,
+
+		IgnoreCase:    false,
+		SmartCase:     true,
+		LiteralSearch: false,
+		WholeWord:     false,
+
+		FormatOnSave: false,
 	}
 }
 
@@ -82,7 +106,9 @@ func SetOption(view *View, args []string) {
 			} else if option == "colorscheme" {
 				settings.Colorscheme = value
 				LoadSyntaxFiles()
-				view.buf.UpdateRules()
+				for _, b := range buffers {
+					b.UpdateRules()
+				}
 			} else if option == "syntax" {
 				if value == "on" {
 					settings.Syntax = true
@@ -93,7 +119,18 @@ func SetOption(view *View, args []string) {
 					return
 				}
 				LoadSyntaxFiles()
-				view.buf.UpdateRules()
+				for _, b := range buffers {
+					b.UpdateRules()
+				}
+			} else if option == "autoindent" {
+				if value == "on" {
+					settings.AutoIndent = true
+				} else if value == "off" {
+					settings.AutoIndent = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
 			} else if option == "tabsToSpaces" {
 				if value == "on" {
 					settings.TabsToSpaces = true
@@ -103,6 +140,69 @@ func SetOption(view *View, args []string) {
 					messenger.Error("Invalid value for " + option)
 					return
 				}
+			} else if option == "persistentundo" {
+				if value == "on" {
+					settings.PersistentUndo = true
+				} else if value == "off" {
+					settings.PersistentUndo = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "softwrap" {
+				if value == "on" {
+					settings.Softwrap = true
+				} else if value == "off" {
+					settings.Softwrap = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "ignorecase" {
+				if value == "on" {
+					settings.IgnoreCase = true
+				} else if value == "off" {
+					settings.IgnoreCase = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "smartcase" {
+				if value == "on" {
+					settings.SmartCase = true
+				} else if value == "off" {
+					settings.SmartCase = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "literalsearch" {
+				if value == "on" {
+					settings.LiteralSearch = true
+				} else if value == "off" {
+					settings.LiteralSearch = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "wholeword" {
+				if value == "on" {
+					settings.WholeWord = true
+				} else if value == "off" {
+					settings.WholeWord = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
+			} else if option == "formatonsave" {
+				if value == "on" {
+					settings.FormatOnSave = true
+				} else if value == "off" {
+					settings.FormatOnSave = false
+				} else {
+					messenger.Error("Invalid value for " + option)
+					return
+				}
 			}
 			err := WriteSettings(filename)
 			if err != nil {
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// errShellTimeout is the error for a command which was stopped because it
+// took too long
+var errShellTimeout = errors.New("the command took too long, and was stopped")
+
+// ShellCommand returns the command which runs cmd in the shell
+func ShellCommand(cmd string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", cmd)
+	}
+	return exec.Command("sh", "-c", cmd)
+}
+
+// RunShell runs cmd in the shell with input as its standard input, and
+// returns what it wrote to its standard output
+// If the command fails, the error is what it wrote to its standard error
+func RunShell(cmd, input string) (string, error) {
+	return RunShellIn("", cmd, input)
+}
+
+// RunShellIn is like RunShell, but runs cmd in the directory dir, or the
+// current directory if dir is ""
+func RunShellIn(dir, cmd, input string) (string, error) {
+	return RunShellTimeout(dir, cmd, input, 0)
+}
+
+// RunShellTimeout is like RunShellIn, but stops cmd if it runs for longer
+// than timeout, unless timeout is 0
+func RunShellTimeout(dir, cmd, input string, timeout time.Duration) (string, error) {
+	c := ShellCommand(cmd)
+	c.Dir = dir
+	var stdout, stderr bytes.Buffer
+	c.Stdin = strings.NewReader(input)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	var err error
+	select {
+	case err = <-done:
+	case <-expired:
+		// The commands it started may keep its output open, so it isn't
+		// waited for
+		c.Process.Kill()
+		return "", errShellTimeout
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.String(), errors.New(msg)
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
+}
+
+// RunCommand runs a shell command
+// Output which fits on one line is shown as a message, and longer output is
+// shown in a split below the view
+func RunCommand(view *View, args []string) {
+	cmd := strings.Join(args, " ")
+	if strings.TrimSpace(cmd) == "" {
+		messenger.Error("Invalid run statement, please use run command")
+		return
+	}
+	output, err := RunShell(cmd, "")
+	if err != nil {
+		messenger.Error("Error running " + cmd + ": " + err.Error())
+		return
+	}
+
+	output = strings.TrimSuffix(output, "\n")
+	w, _ := screen.Size()
+	if output == "" {
+		messenger.Message("Ran " + cmd)
+	} else if !strings.Contains(output, "\n") && Count(output) < w {
+		messenger.Message(output)
+	} else {
+		buf := NewBuffer(output, "")
+		buf.name = "run " + cmd
+		view.HSplit(buf)
+	}
+}
+
+// FilterCommand pipes the selection, or the whole buffer if nothing is
+// selected, through a shell command and replaces it with the output
+func FilterCommand(view *View, args []string) {
+	cmd := strings.Join(args, " ")
+	if strings.TrimSpace(cmd) == "" {
+		messenger.Error("Invalid filter statement, please use filter command")
+		return
+	}
+	view.Filter(cmd)
+}
+
+// Filter replaces the selection (or the whole buffer) with the output of the
+// shell command, which is given the selection as its input
+// The replacement is a single undo, and the output stays selected
+// If the command fails, the text is left alone
+func (v *View) Filter(cmd string) {
+	start, end := 0, v.buf.Len()
+	if v.cursor.HasSelection() {
+		start = Min(v.cursor.curSelection[0], v.cursor.curSelection[1])
+		end = Max(v.cursor.curSelection[0], v.cursor.curSelection[1])
+	}
+	input := v.buf.Substr(start, end)
+	output, err := RunShell(cmd, input)
+	if err != nil {
+		messenger.Error("Error running " + cmd + ": " + err.Error())
+		return
+	}
+	// Most commands end their output with a newline, which the text may not have
+	if !strings.HasSuffix(input, "\n") {
+		output = strings.TrimSuffix(output, "\n")
+	}
+	if output == input {
+		return
+	}
+
+	v.buf.eh.BeginGroup()
+	if end > start {
+		v.buf.eh.Remove(start, end)
+	}
+	if output != "" {
+		v.buf.eh.Insert(start, output)
+	}
+	v.buf.eh.EndGroup()
+
+	if v.cursor.HasSelection() {
+		v.cursor.curSelection = [2]int{start, start + Count(output)}
+		v.cursor.SetLoc(start + Count(output))
+	}
+	v.Clamp()
+	v.Relocate()
+	v.UpdateLines(0, v.buf.NumLines())
+}
+
+// ShellBang runs the shell command after a ! in the command prompt
+// With a selection, the selection is filtered through the command, otherwise
+// the command is run like with run
+func ShellBang(view *View, cmd string) {
+	if view.cursor.HasSelection() {
+		FilterCommand(view, []string{cmd})
+	} else {
+		RunCommand(view, []string{cmd})
+	}
+}
+
+// ReadCommand inserts the output of a shell command (read !command), or the
+// contents of a file (read file), at the cursor
+func ReadCommand(view *View, args []string) {
+	arg := strings.TrimSpace(strings.Join(args, " "))
+	var text string
+	if strings.HasPrefix(arg, "!") {
+		output, err := RunShell(arg[1:], "")
+		if err != nil {
+			messenger.Error("Error running " + arg[1:] + ": " + err.Error())
+			return
+		}
+		text = strings.TrimSuffix(output, "\n")
+	} else if arg != "" {
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			messenger.Error(err.Error())
+			return
+		}
+		text = string(data)
+	} else {
+		messenger.Error("Invalid read statement, please use read !command or read file")
+		return
+	}
+	view.InsertText(text)
+}
+
+// InsertText inserts text at the cursor, replacing the selection, and moves
+// the cursor after it
+func (v *View) InsertText(text string) {
+	v.buf.eh.BeginGroup()
+	defer v.buf.eh.EndGroup()
+
+	if v.cursor.HasSelection() {
+		v.cursor.DeleteSelection()
+		v.cursor.ResetSelection()
+	}
+	if text == "" {
+		return
+	}
+	v.buf.eh.Insert(v.cursor.Loc(), text)
+	v.cursor.SetLoc(v.cursor.Loc() + Count(text))
+	v.Relocate()
+	v.UpdateLines(0, v.buf.NumLines())
+}
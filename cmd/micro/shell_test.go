@@ -0,0 +1,78 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunShell(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The tests use sh")
+	}
+	out, err := RunShell("tr a-z A-Z", "héllo\n")
+	if err != nil || out != "HéLLO\n" {
+		t.Errorf("RunShell gave %q, %v", out, err)
+	}
+	if _, err := RunShell("echo oops >&2; exit 3", ""); err == nil || err.Error() != "oops" {
+		t.Errorf("A failing command gave the error %v, want oops", err)
+	}
+}
+
+func TestRunShellTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The tests use sh")
+	}
+	start := time.Now()
+	if _, err := RunShellTimeout("", "sleep 10", "", 100*time.Millisecond); err != errShellTimeout {
+		t.Errorf("The command which took too long gave %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("The command was stopped after %v", d)
+	}
+	if out, err := RunShellTimeout("", "echo a", "", time.Second); out != "a\n" || err != nil {
+		t.Errorf("The quick command gave %q, %v", out, err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The tests use sh")
+	}
+	messenger = new(Messenger)
+	eh := newTestEventHandler("c\nb\na\nz")
+	v := eh.v
+
+	// The selection is filtered, and the output stays selected
+	v.cursor.curSelection = [2]int{0, ToCharPos(1, 2, v.buf)}
+	v.Filter("sort")
+	if got := v.buf.String(); got != "a\nb\nc\nz" {
+		t.Errorf("Filtering the selection gave %q", got)
+	}
+	if got := v.cursor.GetSelection(); got != "a\nb\nc" {
+		t.Errorf("The selection is %q after filtering", got)
+	}
+
+	// The whole buffer is filtered without a selection, in one undo
+	v.cursor.ResetSelection()
+	v.Filter("tr a-z A-Z")
+	if got := v.buf.String(); got != "A\nB\nC\nZ" {
+		t.Errorf("Filtering the buffer gave %q", got)
+	}
+	eh.UndoGroup()
+	if got := v.buf.String(); got != "a\nb\nc\nz" {
+		t.Errorf("Undoing the filter gave %q", got)
+	}
+
+	// A failing command changes nothing
+	v.Filter("false")
+	if got := v.buf.String(); got != "a\nb\nc\nz" {
+		t.Errorf("A failing filter gave %q", got)
+	}
+
+	v.cursor.x, v.cursor.y = 1, 3
+	ReadCommand(v, []string{"!printf", "'x\\ny\\n'"})
+	if got := v.buf.String(); got != "a\nb\nc\nzx\ny" || v.cursor.Loc() != v.buf.Len() {
+		t.Errorf("Reading the output of a command gave %q with the cursor at %d", got, v.cursor.Loc())
+	}
+}
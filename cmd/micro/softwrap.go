@@ -0,0 +1,146 @@
+package main
+
+// With the softwrap option, a line which is wider than the view is wrapped
+// across several rows of the screen
+// A position on the screen is then a line along with a row of that line,
+// and the view scrolls by rows instead of by lines
+
+// textWidth returns the number of columns the view has for text, after the line numbers
+func (v *View) textWidth() int {
+	w := v.width - v.lineNumOffset
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+// visualLength returns the width of line y on the screen, with the tabs expanded
+func (v *View) visualLength(y int) int {
+	line := v.buf.Line(y)
+	return Count(line) + NumOccurences(line, '\t')*(settings.TabSize-1)
+}
+
+// lineRows returns how many rows of the screen line y takes up
+// Without softwrap every line takes up one row
+// A wrapped line always has room for the cursor after its last character
+func (v *View) lineRows(y int) int {
+	if !settings.Softwrap {
+		return 1
+	}
+	return v.visualLength(y)/v.textWidth() + 1
+}
+
+// rowBefore returns whether row1 of line y1 is above row2 of line y2
+func rowBefore(y1, row1, y2, row2 int) bool {
+	return y1 < y2 || y1 == y2 && row1 < row2
+}
+
+// moveRows returns the line and row which are n rows below row of line y
+// (or above it if n is negative)
+// The result is clamped to the first and last rows of the buffer
+func (v *View) moveRows(y, row, n int) (int, int) {
+	for n > 0 {
+		rows := v.lineRows(y)
+		if row+n < rows {
+			return y, row + n
+		}
+		if y+1 >= v.buf.NumLines() {
+			return y, rows - 1
+		}
+		n -= rows - row
+		y, row = y+1, 0
+	}
+	for n < 0 {
+		if row+n >= 0 {
+			return y, row + n
+		}
+		if y == 0 {
+			return 0, 0
+		}
+		n += row + 1
+		y--
+		row = v.lineRows(y) - 1
+	}
+	return y, row
+}
+
+// rowsBetween returns how many rows row2 of line y2 is below row1 of line y1
+// It stops counting once it reaches max, so it is cheap even when the rows are far apart
+func (v *View) rowsBetween(y1, row1, y2, row2, max int) int {
+	n := -row1
+	for y := y1; y < y2; y++ {
+		n += v.lineRows(y)
+		if n >= max {
+			return max
+		}
+	}
+	return n + row2
+}
+
+// maxScroll returns the top line and row of the view when it is scrolled
+// so that the last row of the buffer is at the bottom
+func (v *View) maxScroll() (int, int) {
+	y := v.buf.NumLines() - 1
+	return v.moveRows(y, v.lineRows(y)-1, -(v.height - 1))
+}
+
+// scrollRows scrolls the view down n rows (or up if n is negative)
+// It doesn't scroll down past the point where the last row is at the bottom
+func (v *View) scrollRows(n int) {
+	y, row := v.moveRows(v.topline, v.topRow, n)
+	if n > 0 {
+		maxY, maxRow := v.maxScroll()
+		if rowBefore(maxY, maxRow, y, row) {
+			y, row = maxY, maxRow
+			// The buffer may have become shorter since the view was scrolled
+			if rowBefore(y, row, v.topline, v.topRow) {
+				return
+			}
+		}
+	}
+	v.topline, v.topRow = y, row
+}
+
+// relocateRows is Relocate for when the lines are wrapped
+func (v *View) relocateRows() bool {
+	ret := false
+	if v.leftCol != 0 {
+		v.leftCol = 0
+		ret = true
+	}
+
+	cy, crow := v.cursor.y, v.cursor.VisualRow()
+	if rowBefore(cy, crow, v.topline, v.topRow) {
+		v.topline, v.topRow = cy, crow
+		return true
+	}
+	if v.rowsBetween(v.topline, v.topRow, cy, crow, v.height) >= v.height {
+		v.topline, v.topRow = v.moveRows(cy, crow, -(v.height - 1))
+		return true
+	}
+	return ret
+}
+
+// moveToWrappedClick is MoveToMouseClick for when the lines are wrapped
+// x is the column in the text area and sy is the row of the view which was clicked
+func (v *View) moveToWrappedClick(x, sy int) {
+	if sy > v.height-1 {
+		v.ScrollDown(1)
+		sy = v.height - 1
+	}
+	if sy < 0 {
+		sy = 0
+	}
+	if x < 0 {
+		x = 0
+	}
+
+	y, row := v.moveRows(v.topline, v.topRow, sy)
+	x = v.cursor.GetCharPosInLine(y, row*v.textWidth()+x)
+	if x > Count(v.buf.Line(y)) {
+		x = Count(v.buf.Line(y))
+	}
+	v.cursor.x = x
+	v.cursor.y = y
+	v.cursor.lastVisualX = v.cursor.GetVisualX()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// newTestWrapView returns a view of txt with 10 columns for text and 3 rows
+func newTestWrapView(txt string) *View {
+	v := &View{buf: NewBuffer(txt, ""), width: 12, height: 3, lineNumOffset: 2}
+	v.cursor = Cursor{v: v}
+	return v
+}
+
+func TestSoftwrapRows(t *testing.T) {
+	settings = DefaultSettings()
+	settings.Softwrap = true
+	defer func() { settings = DefaultSettings() }()
+
+	// Line 1 takes up 3 rows, and a tab is 4 columns wide
+	v := newTestWrapView("ab\n" + strings.Repeat("x", 25) + "\n\tcdefgh\nend")
+	wantRows := []int{1, 3, 2, 1}
+	for y, want := range wantRows {
+		if rows := v.lineRows(y); rows != want {
+			t.Errorf("Line %d has %d rows, want %d", y, rows, want)
+		}
+	}
+
+	if y, row := v.moveRows(0, 0, 3); y != 1 || row != 2 {
+		t.Errorf("3 rows down from the top is %d, %d, want 1, 2", y, row)
+	}
+	if y, row := v.moveRows(2, 1, -3); y != 1 || row != 1 {
+		t.Errorf("3 rows up from 2, 1 is %d, %d, want 1, 1", y, row)
+	}
+	if y, row := v.moveRows(3, 0, 10); y != 3 || row != 0 {
+		t.Errorf("Moving past the end gives %d, %d, want 3, 0", y, row)
+	}
+	if n := v.rowsBetween(0, 0, 2, 1, 10); n != 5 {
+		t.Errorf("There are %d rows between 0, 0 and 2, 1, want 5", n)
+	}
+
+	// The cursor keeps its column when it moves by rows
+	v.cursor.x, v.cursor.y = 2, 0
+	v.cursor.lastVisualX = 2
+	v.cursor.Down()
+	if v.cursor.x != 2 || v.cursor.y != 1 {
+		t.Errorf("Cursor moved down to %d, %d, want 2, 1", v.cursor.x, v.cursor.y)
+	}
+	v.cursor.Down()
+	if v.cursor.x != 12 || v.cursor.y != 1 {
+		t.Errorf("Cursor moved down to %d, %d, want 12, 1", v.cursor.x, v.cursor.y)
+	}
+
+	// The view scrolls by rows to show the cursor
+	v.cursor.Down()
+	v.cursor.Down()
+	v.Relocate()
+	if v.topline != 1 || v.topRow != 1 {
+		t.Errorf("The view starts at %d, %d, want 1, 1", v.topline, v.topRow)
+	}
+
+	// The view doesn't scroll past the last row
+	v.ScrollDown(10)
+	if v.topline != 2 || v.topRow != 0 {
+		t.Errorf("The view scrolled down to %d, %d, want 2, 0", v.topline, v.topRow)
+	}
+	v.ScrollUp(2)
+	if v.topline != 1 || v.topRow != 1 {
+		t.Errorf("The view scrolled up to %d, %d, want 1, 1", v.topline, v.topRow)
+	}
+
+	// A click on the second row of the view is on the third row of line 1
+	v.MoveToMouseClick(3, v.topline+1)
+	if v.cursor.x != 23 || v.cursor.y != 1 {
+		t.Errorf("Click moved the cursor to %d, %d, want 23, 1", v.cursor.x, v.cursor.y)
+	}
+}
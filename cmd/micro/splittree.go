@@ -0,0 +1,322 @@
+package main
+
+// SplitType specifies whether the children of a split tree are laid
+// out side by side or on top of each other
+type SplitType bool
+
+const (
+	// VerticalSplit lays out the children side by side (like :vsplit in vim)
+	VerticalSplit SplitType = false
+	// HorizontalSplit lays out the children on top of each other
+	HorizontalSplit SplitType = true
+)
+
+// minSplitPercent is the smallest percentage of its parent a split can be resized to
+const minSplitPercent = 5
+
+// A Node is an element of the split tree
+// It is either a LeafNode which holds a view, or a SplitTree which holds other nodes
+type Node interface {
+	// Resize lays the node out in the given rectangle of the screen
+	Resize(x, y, width, height int)
+	// Parent returns the split tree this node is in
+	Parent() *SplitTree
+	// Percents returns pointers to the width and height percentages
+	// of the parent that this node takes up
+	Percents() (*int, *int)
+
+	setParent(*SplitTree)
+}
+
+// LeafNode is a node of the split tree which holds a view
+type LeafNode struct {
+	view   *View
+	parent *SplitTree
+}
+
+// NewLeafNode returns a new leaf node for the view, and links the view to it
+func NewLeafNode(v *View, parent *SplitTree) *LeafNode {
+	l := new(LeafNode)
+	l.view = v
+	l.parent = parent
+	v.splitNode = l
+	return l
+}
+
+// Resize resizes the leaf's view
+func (l *LeafNode) Resize(x, y, width, height int) {
+	l.view.x = x
+	l.view.y = y
+	l.view.Resize(width, height)
+}
+
+// Parent returns the split tree the leaf is in
+func (l *LeafNode) Parent() *SplitTree {
+	return l.parent
+}
+
+// Percents returns the view's width and height percentages
+func (l *LeafNode) Percents() (*int, *int) {
+	return &l.view.widthPercent, &l.view.heightPercent
+}
+
+func (l *LeafNode) setParent(t *SplitTree) {
+	l.parent = t
+}
+
+// VSplit splits the leaf so that the given view is placed to the right of it
+func (l *LeafNode) VSplit(v *View) {
+	l.split(v, VerticalSplit)
+}
+
+// HSplit splits the leaf so that the given view is placed below it
+func (l *LeafNode) HSplit(v *View) {
+	l.split(v, HorizontalSplit)
+}
+
+// split places the view next to the leaf, in the given direction
+// If the parent is already split in that direction, the new leaf becomes a sibling
+// and otherwise the leaf is replaced by a new split tree holding both leaves
+func (l *LeafNode) split(v *View, kind SplitType) {
+	t := l.parent
+	if len(t.children) == 1 {
+		t.kind = kind
+	}
+
+	if t.kind == kind {
+		newLeaf := NewLeafNode(v, t)
+		// The new view takes half of the space of the old one
+		p := t.percentOf(l)
+		half := *p / 2
+		*p -= half
+		w, h := newLeaf.Percents()
+		*w, *h = 100, 100
+		if kind == VerticalSplit {
+			*w = half
+		} else {
+			*h = half
+		}
+		t.insertAfter(l, newLeaf)
+		return
+	}
+
+	sub := new(SplitTree)
+	sub.kind = kind
+	sub.parent = t
+	sub.widthPercent, sub.heightPercent = l.view.widthPercent, l.view.heightPercent
+	t.replace(l, sub)
+
+	l.parent = sub
+	l.view.widthPercent, l.view.heightPercent = 100, 100
+	newLeaf := NewLeafNode(v, sub)
+	if kind == VerticalSplit {
+		l.view.widthPercent = 50
+		v.widthPercent, v.heightPercent = 50, 100
+	} else {
+		l.view.heightPercent = 50
+		v.widthPercent, v.heightPercent = 100, 50
+	}
+	sub.children = []Node{l, newLeaf}
+}
+
+// Delete removes the leaf from the tree, and gives its space to a neighbour
+func (l *LeafNode) Delete() {
+	t := l.parent
+	idx := t.indexOf(l)
+	if idx == -1 {
+		return
+	}
+	freed := *t.percentOf(l)
+	t.children = append(t.children[:idx], t.children[idx+1:]...)
+	if len(t.children) == 0 {
+		return
+	}
+
+	// Give the freed space to the neighbour before the leaf, or after it if there is none
+	neighbour := idx - 1
+	if neighbour < 0 {
+		neighbour = 0
+	}
+	*t.percentOf(t.children[neighbour]) += freed
+
+	t.collapse()
+}
+
+// ResizeBy grows (or shrinks, if n is negative) the leaf by n percent of its parent,
+// taking the space from the next sibling (or the previous one for the last node)
+func (l *LeafNode) ResizeBy(n int) bool {
+	var node Node = l
+	t := l.parent
+	// Find the closest ancestor which actually has siblings to trade space with
+	for t != nil && len(t.children) < 2 {
+		node = t
+		t = t.parent
+	}
+	if t == nil {
+		return false
+	}
+
+	idx := t.indexOf(node)
+	other := idx + 1
+	if other >= len(t.children) {
+		other = idx - 1
+	}
+
+	p := t.percentOf(node)
+	op := t.percentOf(t.children[other])
+	if *p+n < minSplitPercent || *op-n < minSplitPercent {
+		return false
+	}
+	*p += n
+	*op -= n
+	return true
+}
+
+// SplitTree is a node of the split tree which holds other nodes
+type SplitTree struct {
+	kind     SplitType
+	parent   *SplitTree
+	children []Node
+
+	x      int
+	y      int
+	width  int
+	height int
+
+	// Percentage of the parent that this tree takes up
+	widthPercent  int
+	heightPercent int
+}
+
+// NewSplitTree returns a new root split tree holding one view
+func NewSplitTree(v *View) *SplitTree {
+	t := new(SplitTree)
+	t.kind = VerticalSplit
+	t.widthPercent, t.heightPercent = 100, 100
+	v.widthPercent, v.heightPercent = 100, 100
+	t.children = []Node{NewLeafNode(v, t)}
+	return t
+}
+
+// Parent returns the split tree this tree is in
+func (t *SplitTree) Parent() *SplitTree {
+	return t.parent
+}
+
+// Percents returns the width and height percentages of the tree
+func (t *SplitTree) Percents() (*int, *int) {
+	return &t.widthPercent, &t.heightPercent
+}
+
+func (t *SplitTree) setParent(p *SplitTree) {
+	t.parent = p
+}
+
+// Resize lays out the tree's children in the given rectangle
+// The children of a vertical split are separated by a one column divider
+// which is drawn by the view to the right of it
+func (t *SplitTree) Resize(x, y, width, height int) {
+	t.x, t.y = x, y
+	t.width, t.height = width, height
+
+	for i, child := range t.children {
+		wp, hp := child.Percents()
+		if t.kind == VerticalSplit {
+			w := width * *wp / 100
+			if i == len(t.children)-1 {
+				// Give the rounding error to the last child
+				w = t.x + t.width - x
+			}
+			if i > 0 {
+				// Make room for the divider
+				child.Resize(x+1, y, w-1, height)
+			} else {
+				child.Resize(x, y, w, height)
+			}
+			x += w
+		} else {
+			h := height * *hp / 100
+			if i == len(t.children)-1 {
+				h = t.y + t.height - y
+			}
+			child.Resize(x, y, width, h)
+			y += h
+		}
+	}
+}
+
+// Views returns every view in the tree, from left to right and top to bottom
+func (t *SplitTree) Views() []*View {
+	var views []*View
+	for _, child := range t.children {
+		switch n := child.(type) {
+		case *LeafNode:
+			views = append(views, n.view)
+		case *SplitTree:
+			views = append(views, n.Views()...)
+		}
+	}
+	return views
+}
+
+// percentOf returns the percentage of the tree that the child takes up,
+// in the direction of the split
+func (t *SplitTree) percentOf(child Node) *int {
+	w, h := child.Percents()
+	if t.kind == VerticalSplit {
+		return w
+	}
+	return h
+}
+
+func (t *SplitTree) indexOf(child Node) int {
+	for i, c := range t.children {
+		if c == child {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *SplitTree) insertAfter(child, n Node) {
+	idx := t.indexOf(child)
+	t.children = append(t.children, nil)
+	copy(t.children[idx+2:], t.children[idx+1:])
+	t.children[idx+1] = n
+}
+
+func (t *SplitTree) replace(child, n Node) {
+	if idx := t.indexOf(child); idx != -1 {
+		t.children[idx] = n
+	}
+}
+
+// collapse removes unnecessary levels from the tree after a node has been deleted
+// A tree with only one child is replaced by that child
+func (t *SplitTree) collapse() {
+	if len(t.children) != 1 {
+		return
+	}
+	child := t.children[0]
+
+	if t.parent == nil {
+		// This is the root, so we can't replace it, but we can take over
+		// the children of a lone subtree
+		if sub, ok := child.(*SplitTree); ok {
+			t.kind = sub.kind
+			t.children = sub.children
+			for _, c := range t.children {
+				c.setParent(t)
+			}
+		} else {
+			w, h := child.Percents()
+			*w, *h = 100, 100
+		}
+		return
+	}
+
+	w, h := child.Percents()
+	*w, *h = t.widthPercent, t.heightPercent
+	child.setParent(t.parent)
+	t.parent.replace(t, child)
+}
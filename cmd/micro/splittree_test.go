@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"reflect"
+	"testing"
+)
+
+// newTestTab opens a tab with an empty buffer on a simulated 80x25 screen
+// closeTestTabs must be called when the test is done
+func newTestTab() *Tab {
+	s := tcell.NewSimulationScreen("")
+	s.Init()
+	s.SetSize(80, 25)
+	screen = s
+	messenger = new(Messenger)
+	buffers, tabs, curTab = nil, nil, 0
+	AddTab(NewBuffer("", ""))
+	return CurTab()
+}
+
+// closeTestTabs throws away the tabs and the screen of newTestTab
+func closeTestTabs() {
+	buffers, tabs, curTab = nil, nil, 0
+	screen = nil
+}
+
+// viewRects returns where the views of the tab are on the screen
+func viewRects(t *Tab) [][4]int {
+	var rects [][4]int
+	for _, v := range t.views {
+		rects = append(rects, [4]int{v.x, v.y, v.width, v.height})
+	}
+	return rects
+}
+
+func TestSplitTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		split func(v *View)
+		// The x, y, width and height of the views, without their statuslines
+		want [][4]int
+	}{
+		{"vsplit", func(v *View) {
+			v.VSplit(v.buf)
+		}, [][4]int{{0, 0, 40, 23}, {41, 0, 39, 23}}},
+		{"hsplit", func(v *View) {
+			v.HSplit(v.buf)
+		}, [][4]int{{0, 0, 80, 11}, {0, 12, 80, 11}}},
+		// The second split of a view in the same direction halves its space
+		{"vsplit twice", func(v *View) {
+			v.VSplit(v.buf)
+			v.VSplit(v.buf)
+		}, [][4]int{{0, 0, 20, 23}, {21, 0, 19, 23}, {41, 0, 39, 23}}},
+		// A split in the other direction makes a subtree
+		{"vsplit and hsplit", func(v *View) {
+			v.VSplit(v.buf)
+			CurView().HSplit(v.buf)
+		}, [][4]int{{0, 0, 40, 23}, {41, 0, 39, 11}, {41, 12, 39, 11}}},
+	}
+	for _, test := range tests {
+		tab := newTestTab()
+		test.split(tab.CurView())
+		if got := viewRects(tab); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: the views are at %v, want %v", test.name, got, test.want)
+		}
+		closeTestTabs()
+	}
+}
+
+func TestSplitTreeDelete(t *testing.T) {
+	tab := newTestTab()
+	defer closeTestTabs()
+	v := tab.CurView()
+	v.VSplit(v.buf)
+	CurView().HSplit(v.buf)
+
+	// Deleting one of the two views of the subtree leaves the other one in
+	// its place, directly in the root
+	bottom := tab.views[2]
+	bottom.splitNode.Delete()
+	tab.Update()
+	if got, want := viewRects(tab), [][4]int{{0, 0, 40, 23}, {41, 0, 39, 23}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("The views are at %v, want %v", got, want)
+	}
+	for i, child := range tab.tree.children {
+		if _, ok := child.(*LeafNode); !ok {
+			t.Errorf("Child %d of the root is not a view", i)
+		}
+		if child.Parent() != tab.tree {
+			t.Errorf("The parent of child %d is not the root", i)
+		}
+	}
+
+	// The last view takes up the whole tab
+	tab.views[1].splitNode.Delete()
+	tab.Update()
+	if got, want := viewRects(tab), [][4]int{{0, 0, 80, 23}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("The view is at %v, want %v", got, want)
+	}
+}
+
+func TestSplitTreeResize(t *testing.T) {
+	tab := newTestTab()
+	defer closeTestTabs()
+	v := tab.CurView()
+	if v.splitNode.ResizeBy(10) {
+		t.Error("The only view was resized")
+	}
+	v.VSplit(v.buf)
+	CurView().HSplit(v.buf)
+
+	tests := []struct {
+		view, n int
+		ok      bool
+		// The width and height percentages of the views afterwards
+		want [][2]int
+	}{
+		{0, 40, true, [][2]int{{90, 100}, {100, 50}, {100, 50}}},
+		// The other side can't get smaller than minSplitPercent
+		{0, 10, false, [][2]int{{90, 100}, {100, 50}, {100, 50}}},
+		{0, -86, false, [][2]int{{90, 100}, {100, 50}, {100, 50}}},
+		{0, -85, true, [][2]int{{5, 100}, {100, 50}, {100, 50}}},
+		// A view in the subtree trades space with its sibling
+		{2, 20, true, [][2]int{{5, 100}, {100, 30}, {100, 70}}},
+		{1, -26, false, [][2]int{{5, 100}, {100, 30}, {100, 70}}},
+	}
+	for _, test := range tests {
+		if ok := tab.views[test.view].splitNode.ResizeBy(test.n); ok != test.ok {
+			t.Errorf("Resizing view %d by %d gave %v", test.view, test.n, ok)
+		}
+		var got [][2]int
+		for _, v := range tab.views {
+			got = append(got, [2]int{v.widthPercent, v.heightPercent})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("After resizing view %d by %d the percentages are %v, want %v", test.view, test.n, got, test.want)
+		}
+	}
+
+	tab.Update()
+	if got, want := viewRects(tab), [][4]int{{0, 0, 4, 23}, {5, 0, 75, 6}, {5, 7, 75, 16}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("The views are at %v, want %v", got, want)
+	}
+}
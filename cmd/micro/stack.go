@@ -41,3 +41,14 @@ func (s *Stack) Peek() interface{} {
 	}
 	return nil
 }
+
+// Values returns the values in the stack, from the bottom to the top
+func (s *Stack) Values() []interface{} {
+	values := make([]interface{}, s.size)
+	i := s.size - 1
+	for e := s.top; e != nil; e = e.next {
+		values[i] = e.value
+		i--
+	}
+	return values
+}
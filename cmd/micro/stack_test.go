@@ -36,4 +36,12 @@ func TestStack(t *testing.T) {
 	if popped != nil {
 		t.Errorf("Pop failed")
 	}
+
+	stack.Push(1)
+	stack.Push(2)
+	stack.Push(3)
+	values := stack.Values()
+	if len(values) != 3 || values[0] != 1 || values[1] != 2 || values[2] != 3 {
+		t.Errorf("Values failed")
+	}
 }
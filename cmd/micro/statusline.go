@@ -16,12 +16,9 @@ type Statusline struct {
 func (sline *Statusline) Display() {
 	// We'll draw the line at the lowest line in the view
 	y := sline.view.height
+	v := sline.view
 
-	file := sline.view.buf.name
-	// If the name is empty, use 'No name'
-	if file == "" {
-		file = "No name"
-	}
+	file := sline.view.buf.GetName()
 
 	// If the buffer is dirty (has been modified) write a little '+'
 	if sline.view.buf.IsDirty() {
@@ -40,6 +37,20 @@ func (sline *Statusline) Display() {
 	// Add the filetype
 	file += " " + sline.view.buf.filetype
 
+	// The git branch, with a * if there are changes which aren't committed
+	if branch := v.buf.GitStatusLine(); branch != "" {
+		file += " git:" + branch
+	}
+
+	// Which match of the search is selected
+	if status := SearchStatus(v); status != "" {
+		file += " [" + status + "]"
+	}
+
+	if v.term != nil {
+		file = v.term.Status()
+	}
+
 	centerText := "Press Ctrl-g for help"
 
 	statusLineStyle := defStyle.Reverse(true)
@@ -51,11 +62,11 @@ func (sline *Statusline) Display() {
 	fileRunes := []rune(file)
 	for x := 0; x < sline.view.width; x++ {
 		if x < len(fileRunes) {
-			screen.SetContent(x, y, fileRunes[x], nil, statusLineStyle)
+			v.drawCell(x, y, fileRunes[x], statusLineStyle)
 		} else if x >= sline.view.width/2-len(centerText)/2 && x < len(centerText)+sline.view.width/2-len(centerText)/2 {
-			screen.SetContent(x, y, []rune(centerText)[x-sline.view.width/2+len(centerText)/2], nil, statusLineStyle)
+			v.drawCell(x, y, []rune(centerText)[x-sline.view.width/2+len(centerText)/2], statusLineStyle)
 		} else {
-			screen.SetContent(x, y, ' ', nil, statusLineStyle)
+			v.drawCell(x, y, ' ', statusLineStyle)
 		}
 	}
 }
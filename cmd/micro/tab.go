@@ -0,0 +1,240 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+)
+
+// A Tab holds a split tree of views
+// Only the views of the current tab are shown on the screen
+type Tab struct {
+	// The views in the tab, from left to right and top to bottom
+	views []*View
+	// The index of the view which has focus
+	curView int
+	// The split tree which lays out the views
+	tree *SplitTree
+}
+
+var (
+	// The open tabs, and the index of the tab shown on the screen
+	tabs   []*Tab
+	curTab int
+)
+
+// NewTabFromView returns a new tab holding the given view
+func NewTabFromView(v *View) *Tab {
+	t := new(Tab)
+	t.tree = NewSplitTree(v)
+	t.Update()
+	return t
+}
+
+// AddTab adds a new tab for the buffer after the current one, and switches to it
+func AddTab(buf *Buffer) {
+	t := NewTabFromView(NewView(buf))
+	if len(tabs) == 0 {
+		tabs = append(tabs, t)
+	} else {
+		curTab++
+		tabs = append(tabs, nil)
+		copy(tabs[curTab+1:], tabs[curTab:])
+		tabs[curTab] = t
+	}
+	// Adding the second tab makes the tab bar appear
+	ResizeTabs()
+}
+
+// CloseTab closes the tab at index i
+func CloseTab(i int) {
+	tabs = append(tabs[:i], tabs[i+1:]...)
+	if curTab >= i && curTab > 0 {
+		curTab--
+	}
+	ResizeTabs()
+}
+
+// SetCurTab switches to the tab at index i
+func SetCurTab(i int) {
+	if i < 0 || i >= len(tabs) {
+		return
+	}
+	curTab = i
+}
+
+// CurTab returns the tab shown on the screen
+func CurTab() *Tab {
+	return tabs[curTab]
+}
+
+// CurView returns the view which has focus
+func CurView() *View {
+	return CurTab().CurView()
+}
+
+// NumViews returns the number of views showing the buffer, in all tabs
+func NumViews(buf *Buffer) int {
+	n := 0
+	for _, t := range tabs {
+		for _, v := range t.views {
+			if v.buf == buf {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// ResizeTabs lays out the views of every tab on the screen again
+func ResizeTabs() {
+	for _, t := range tabs {
+		t.Resize()
+	}
+}
+
+// TabBarHeight returns the number of lines used by the tab bar
+// The tab bar is hidden when there is only one tab
+func TabBarHeight() int {
+	if len(tabs) > 1 {
+		return 1
+	}
+	return 0
+}
+
+// CurView returns the view of the tab which has focus
+func (t *Tab) CurView() *View {
+	return t.views[t.curView]
+}
+
+// SetCurView gives the focus to the given view
+func (t *Tab) SetCurView(v *View) {
+	for i, view := range t.views {
+		if view == v {
+			t.curView = i
+		}
+	}
+}
+
+// NextView gives the focus to the next view in the tab
+func (t *Tab) NextView() {
+	t.curView = (t.curView + 1) % len(t.views)
+}
+
+// Resize lays out the views of the tab on the screen, leaving room for the
+// tab bar at the top and the messenger at the bottom
+func (t *Tab) Resize() {
+	w, h := screen.Size()
+	top := TabBarHeight()
+	t.tree.Resize(0, top, w, h-1-top)
+	for _, v := range t.views {
+		v.Relocate()
+	}
+}
+
+// Update refreshes the list of views after the split tree has changed,
+// and lays the views out on the screen again
+// The focus stays on the same view if it is still open
+func (t *Tab) Update() {
+	var cur *View
+	if t.curView < len(t.views) {
+		cur = t.views[t.curView]
+	}
+	t.views = t.tree.Views()
+	t.curView = 0
+	t.SetCurView(cur)
+	t.Resize()
+}
+
+// ViewAt returns the view which contains the screen location x, y
+// or nil if there isn't one
+func (t *Tab) ViewAt(x, y int) *View {
+	for _, v := range t.views {
+		// The divider on the left of a view and its statusline belong to it
+		if x >= v.x-1 && x < v.x+v.width && y >= v.y && y <= v.y+v.height {
+			return v
+		}
+	}
+	return nil
+}
+
+// Display draws every view in the tab
+func (t *Tab) Display() {
+	for _, v := range t.views {
+		v.Display()
+	}
+}
+
+// TabName returns the name shown for the tab in the tab bar
+func (t *Tab) TabName() string {
+	buf := t.CurView().buf
+	name := buf.GetName()
+	if buf.IsDirty() {
+		name += " +"
+	}
+	return name
+}
+
+// TabAt returns the index of the tab whose name is drawn at column x of
+// the tab bar, or -1 if there isn't one
+func TabAt(x int) int {
+	start := 0
+	for i, t := range tabs {
+		// Every name is padded with a space on either side
+		end := start + Count(t.TabName()) + 2
+		if x >= start && x < end {
+			return i
+		}
+		start = end
+	}
+	return -1
+}
+
+// DisplayTabs draws the tab bar at the top of the screen
+func DisplayTabs() {
+	if TabBarHeight() == 0 {
+		return
+	}
+
+	tabBarStyle := defStyle.Reverse(true)
+	if style, ok := colorscheme["tabbar"]; ok {
+		tabBarStyle = style
+	}
+	activeStyle := defStyle
+	if style, ok := colorscheme["tabbar-active"]; ok {
+		activeStyle = style
+	}
+
+	w, _ := screen.Size()
+	x := 0
+	for i, t := range tabs {
+		style := tabBarStyle
+		if i == curTab {
+			style = activeStyle
+		}
+		for _, ch := range " " + t.TabName() + " " {
+			if x < w {
+				screen.SetContent(x, 0, ch, nil, style)
+			}
+			x++
+		}
+	}
+	for ; x < w; x++ {
+		screen.SetContent(x, 0, ' ', nil, tabBarStyle)
+	}
+}
+
+// HandleTabBarEvent switches tabs when the user clicks on the tab bar
+// It returns whether the event was handled
+func HandleTabBarEvent(event tcell.Event) bool {
+	e, ok := event.(*tcell.EventMouse)
+	if !ok || TabBarHeight() == 0 || e.Buttons() != tcell.Button1 {
+		return false
+	}
+	x, y := e.Position()
+	if y != 0 {
+		return false
+	}
+	if i := TabAt(x); i != -1 {
+		SetCurTab(i)
+	}
+	return true
+}
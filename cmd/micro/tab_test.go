@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenBuffer(t *testing.T) {
+	newTestTab()
+	defer closeTestTabs()
+	dir, err := ioutil.TempDir("", "micro-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("a\n"), 0644)
+
+	buf, err := OpenBuffer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file which is already open is shown in the same buffer
+	again, err := OpenBuffer(path)
+	if err != nil || again != buf {
+		t.Errorf("Opening the file again gave another buffer")
+	}
+	if len(buffers) != 2 {
+		t.Errorf("%d buffers are open, want 2", len(buffers))
+	}
+
+	// Splitting with the file shows its buffer too
+	v := CurView()
+	Split(v, []string{path}, VerticalSplit)
+	if CurView().buf != buf || NumViews(buf) != 1 || len(buffers) != 2 {
+		t.Errorf("Splitting with the open file opened it again")
+	}
+
+	CloseBuffer(buf)
+	if BufferIndex(buf) != -1 || len(buffers) != 1 {
+		t.Errorf("The closed buffer is still open")
+	}
+}
+
+func TestNextBuffer(t *testing.T) {
+	tab := newTestTab()
+	defer closeTestTabs()
+	a := tab.CurView().buf
+	b, c := NewBuffer("b", ""), NewBuffer("c", "")
+	AddBuffer(b)
+	AddBuffer(c)
+
+	v := tab.CurView()
+	tests := []struct {
+		n    int
+		want *Buffer
+	}{
+		{1, b},
+		{1, c},
+		// The list of buffers wraps around
+		{1, a},
+		{-1, c},
+		{-2, a},
+		{5, c},
+	}
+	for i, test := range tests {
+		v.NextBuffer(test.n)
+		if v.buf != test.want {
+			t.Errorf("Step %d: going %d buffers on showed buffer %d", i, test.n, BufferIndex(v.buf))
+		}
+	}
+}
+
+func TestCloseTab(t *testing.T) {
+	newTestTab()
+	defer closeTestTabs()
+	AddTab(NewBuffer("b", ""))
+	AddTab(NewBuffer("c", ""))
+	first := tabs[0]
+	CurView().VSplit(CurView().buf)
+
+	if curTab != 2 || len(CurTab().views) != 2 || CurTab().curView != 1 {
+		t.Fatalf("The current tab is %d, with view %d of %d", curTab, CurTab().curView, len(CurTab().views))
+	}
+	// Closing a tab before the current one keeps the same tab current
+	third := CurTab()
+	CloseTab(0)
+	if len(tabs) != 2 || curTab != 1 || CurTab() != third || CurTab().curView != 1 {
+		t.Errorf("After closing the first tab the current tab is %d, with view %d", curTab, CurTab().curView)
+	}
+	// Closing the current tab makes the one before it current
+	CloseTab(1)
+	if len(tabs) != 1 || curTab != 0 || CurTab() == first {
+		t.Errorf("After closing the current tab the current tab is %d", curTab)
+	}
+	CloseTab(0)
+	if len(tabs) != 0 || curTab != 0 {
+		t.Errorf("After closing the last tab the current tab is %d", curTab)
+	}
+}
+
+func TestTabAt(t *testing.T) {
+	newTestTab()
+	defer closeTestTabs()
+	buf := NewBuffer("", "")
+	buf.name = "ab"
+	AddTab(buf)
+
+	// The tab bar shows " No name  ab "
+	tests := map[int]int{0: 0, 8: 0, 9: 1, 12: 1, 13: -1, -1: -1}
+	for x, want := range tests {
+		if got := TabAt(x); got != want {
+			t.Errorf("TabAt(%d) = %d, want %d", x, got, want)
+		}
+	}
+	if TabBarHeight() != 1 {
+		t.Error("The tab bar is hidden with two tabs")
+	}
+}
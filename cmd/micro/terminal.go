@@ -0,0 +1,791 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// maxScrollback is the number of lines which scrolled off the top of a
+// terminal that are kept, so that the user can scroll back to them
+const maxScrollback = 1000
+
+// A termCell is a character on the screen of a terminal, with its style
+type termCell struct {
+	ch    rune
+	style tcell.Style
+}
+
+// The states of the parser of the escape sequences
+const (
+	termGround = iota
+	termEscape
+	termCSI
+	termOSC
+	termOSCEscape
+	termCharset
+)
+
+// A Terminal runs a shell in a pseudo-terminal, and emulates a VT100 to keep
+// the screen which the shell draws, so that a view can show it
+// Its output is read by a goroutine, so it must be locked while it is used
+type Terminal struct {
+	sync.Mutex
+
+	cmd *exec.Cmd
+	pty *os.File
+	// The command which runs in the terminal
+	name string
+	// Whether termEscapeKey was pressed, so that the next key goes to the editor
+	escaped bool
+
+	w, h  int
+	lines [][]termCell
+	// The lines which scrolled off the top of the screen, oldest first
+	scrollback [][]termCell
+	// How many lines the user scrolled back
+	scroll int
+
+	// The cursor, which is at x == w when the next character wraps the line
+	cx, cy     int
+	hideCursor bool
+	// The style of the characters which are written
+	style tcell.Style
+	// The cursor and style saved by ESC 7
+	savedX, savedY int
+	savedStyle     tcell.Style
+	// The scrolling region, from the top line to the bottom line
+	top, bottom int
+
+	state  int
+	params []byte
+	// The start of a UTF-8 character which was cut off at the end of a read
+	pending []byte
+
+	exited bool
+}
+
+// newTerminal returns a terminal of the given size which isn't running anything
+func newTerminal(w, h int) *Terminal {
+	t := &Terminal{style: defStyle}
+	t.resize(w, h)
+	return t
+}
+
+// NewTerminal runs cmd in the shell in a new terminal of the given size, or
+// the user's $SHELL if cmd is empty
+func NewTerminal(cmd string, w, h int) (*Terminal, error) {
+	t := newTerminal(w, h)
+
+	var c *exec.Cmd
+	if cmd == "" {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "sh"
+		}
+		c = exec.Command(shell)
+		t.name = shell
+	} else {
+		c = ShellCommand(cmd)
+		t.name = cmd
+	}
+
+	pty, tty, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	setPtySize(pty, t.w, t.h)
+
+	c.Env = append(os.Environ(), "TERM=vt100")
+	c.Stdin, c.Stdout, c.Stderr = tty, tty, tty
+	c.SysProcAttr = ptyAttr()
+	if err := c.Start(); err != nil {
+		pty.Close()
+		return nil, err
+	}
+	t.cmd, t.pty = c, pty
+
+	go t.read(screen)
+	return t, nil
+}
+
+// read reads the output of the shell until it exits
+// An interrupt event is posted to the screen for every read, so that the main
+// loop redraws the terminal
+// The screen is passed in, as there is none in the tests
+func (t *Terminal) read(screen tcell.Screen) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := t.pty.Read(buf)
+		if n > 0 {
+			t.Lock()
+			t.feed(buf[:n])
+			t.Unlock()
+			if screen != nil {
+				screen.PostEvent(tcell.NewEventInterrupt(t))
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	t.cmd.Wait()
+
+	t.Lock()
+	t.exited = true
+	t.hideCursor = true
+	t.Unlock()
+	if screen != nil {
+		screen.PostEvent(tcell.NewEventInterrupt(t))
+	}
+}
+
+// Close kills the shell and closes the pseudo-terminal
+func (t *Terminal) Close() {
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+	if t.pty != nil {
+		t.pty.Close()
+	}
+}
+
+// Exited returns whether the shell has exited
+func (t *Terminal) Exited() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.exited
+}
+
+// Status returns what the statusline of the terminal shows
+func (t *Terminal) Status() string {
+	t.Lock()
+	defer t.Unlock()
+	status := "Terminal: " + t.name
+	if t.exited {
+		status += " [exited, press any key to close]"
+	} else if t.scroll > 0 {
+		status += " [" + strconv.Itoa(t.scroll) + " lines back]"
+	}
+	return status
+}
+
+// Resize changes the size of the terminal, and tells the shell about it
+func (t *Terminal) Resize(w, h int) {
+	t.Lock()
+	defer t.Unlock()
+	if w == t.w && h == t.h {
+		return
+	}
+	t.resize(w, h)
+	if t.pty != nil {
+		setPtySize(t.pty, t.w, t.h)
+	}
+}
+
+// resize changes the size of the screen, keeping what is on it
+// If the screen gets shorter, the lines above the cursor scroll off
+func (t *Terminal) resize(w, h int) {
+	w, h = Max(w, 1), Max(h, 1)
+	shift := Max(0, t.cy-(h-1))
+	for _, line := range t.lines[:Min(shift, len(t.lines))] {
+		t.addScrollback(line)
+	}
+	lines := make([][]termCell, h)
+	for y := range lines {
+		lines[y] = t.blankLine(w)
+		if y+shift < len(t.lines) {
+			copy(lines[y], t.lines[y+shift])
+		}
+	}
+	t.lines, t.w, t.h = lines, w, h
+	t.cx, t.cy = Min(t.cx, w), t.cy-shift
+	t.top, t.bottom = 0, h-1
+}
+
+// blank returns an empty cell, with the background of the current style
+func (t *Terminal) blank() termCell {
+	_, bg, _ := t.style.Decompose()
+	return termCell{' ', defStyle.Background(bg)}
+}
+
+// blankLine returns an empty line of the given width
+func (t *Terminal) blankLine(w int) []termCell {
+	line := make([]termCell, w)
+	for x := range line {
+		line[x] = t.blank()
+	}
+	return line
+}
+
+// addScrollback adds a line which scrolled off the top of the screen to the
+// scrollback, and keeps what the user scrolled back to on the screen
+func (t *Terminal) addScrollback(line []termCell) {
+	t.scrollback = append(t.scrollback, line)
+	if len(t.scrollback) > maxScrollback {
+		t.scrollback = t.scrollback[len(t.scrollback)-maxScrollback:]
+	}
+	if t.scroll > 0 {
+		t.scroll = Min(t.scroll+1, len(t.scrollback))
+	}
+}
+
+// ScrollBack scrolls the view of the terminal back through the scrollback by
+// n lines, or forward if n is negative
+func (t *Terminal) ScrollBack(n int) {
+	t.Lock()
+	defer t.Unlock()
+	t.scroll = Max(0, Min(t.scroll+n, len(t.scrollback)))
+}
+
+// Text returns the text of the scrollback and the screen, without the spaces
+// at the end of the lines and the empty lines at the bottom of the screen
+func (t *Terminal) Text() string {
+	t.Lock()
+	defer t.Unlock()
+	var lines []string
+	for _, line := range append(t.scrollback[:len(t.scrollback):len(t.scrollback)], t.lines...) {
+		runes := make([]rune, len(line))
+		for x, c := range line {
+			runes[x] = c.ch
+		}
+		lines = append(lines, strings.TrimRight(string(runes), " "))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// feed runs the output of the shell through the terminal
+func (t *Terminal) feed(data []byte) {
+	if len(t.pending) > 0 {
+		data = append(t.pending, data...)
+		t.pending = nil
+	}
+	for len(data) > 0 {
+		if t.state == termGround && data[0] >= utf8.RuneSelf {
+			if !utf8.FullRune(data) {
+				t.pending = append([]byte(nil), data...)
+				return
+			}
+			r, size := utf8.DecodeRune(data)
+			t.put(r)
+			data = data[size:]
+			continue
+		}
+		t.handleByte(data[0])
+		data = data[1:]
+	}
+}
+
+// handleByte runs a byte of the output which isn't part of a UTF-8 character
+func (t *Terminal) handleByte(b byte) {
+	switch t.state {
+	case termGround:
+		switch b {
+		case 0x1b:
+			t.state = termEscape
+		case '\r':
+			t.cx = 0
+		case '\n', '\v', '\f':
+			t.lineFeed()
+		case '\b':
+			t.cx = Max(Min(t.cx, t.w-1)-1, 0)
+		case '\t':
+			t.cx = Min((t.cx/8+1)*8, t.w-1)
+		default:
+			if b >= ' ' && b != 0x7f {
+				t.put(rune(b))
+			}
+		}
+	case termEscape:
+		t.state = termGround
+		switch b {
+		case '[':
+			t.state = termCSI
+			t.params = t.params[:0]
+		case ']':
+			t.state = termOSC
+		case '(', ')', '*', '+':
+			t.state = termCharset
+		case '7':
+			t.savedX, t.savedY, t.savedStyle = t.cx, t.cy, t.style
+		case '8':
+			t.cx, t.cy, t.style = Min(t.savedX, t.w), Min(t.savedY, t.h-1), t.savedStyle
+		case 'D':
+			t.lineFeed()
+		case 'E':
+			t.cx = 0
+			t.lineFeed()
+		case 'M':
+			t.reverseLineFeed()
+		case 'c':
+			t.reset()
+		}
+	case termCSI:
+		switch {
+		case b >= 0x40 && b <= 0x7e:
+			t.state = termGround
+			t.csi(b)
+		case b >= ' ':
+			t.params = append(t.params, b)
+		case b == 0x1b:
+			t.state = termEscape
+		}
+	case termOSC:
+		// Things like the title of the window, which the pane doesn't have
+		if b == 0x07 {
+			t.state = termGround
+		} else if b == 0x1b {
+			t.state = termOSCEscape
+		}
+	case termOSCEscape, termCharset:
+		t.state = termGround
+	}
+}
+
+// put writes a character at the cursor, wrapping the line if the cursor is past
+// its end
+func (t *Terminal) put(r rune) {
+	if t.cx >= t.w {
+		t.cx = 0
+		t.lineFeed()
+	}
+	t.lines[t.cy][t.cx] = termCell{r, t.style}
+	t.cx++
+}
+
+// lineFeed moves the cursor down a line, scrolling if it is at the bottom of
+// the scrolling region
+func (t *Terminal) lineFeed() {
+	if t.cy == t.bottom {
+		t.scrollUp(t.top, 1)
+	} else if t.cy < t.h-1 {
+		t.cy++
+	}
+}
+
+// reverseLineFeed moves the cursor up a line, scrolling if it is at the top of
+// the scrolling region
+func (t *Terminal) reverseLineFeed() {
+	if t.cy == t.top {
+		t.scrollDown(t.top, 1)
+	} else if t.cy > 0 {
+		t.cy--
+	}
+}
+
+// scrollUp scrolls the lines from top to the bottom of the scrolling region up
+// by n lines
+// The lines which scroll off the top of the screen go to the scrollback
+func (t *Terminal) scrollUp(top, n int) {
+	n = Min(n, t.bottom-top+1)
+	for i := 0; i < n; i++ {
+		if top == 0 {
+			t.addScrollback(t.lines[0])
+		}
+		copy(t.lines[top:t.bottom], t.lines[top+1:t.bottom+1])
+		t.lines[t.bottom] = t.blankLine(t.w)
+	}
+}
+
+// scrollDown scrolls the lines from top to the bottom of the scrolling region
+// down by n lines
+func (t *Terminal) scrollDown(top, n int) {
+	n = Min(n, t.bottom-top+1)
+	for i := 0; i < n; i++ {
+		copy(t.lines[top+1:t.bottom+1], t.lines[top:t.bottom])
+		t.lines[top] = t.blankLine(t.w)
+	}
+}
+
+// erase blanks the characters of line y from x up to end
+func (t *Terminal) erase(y, x, end int) {
+	for ; x < end && x < t.w; x++ {
+		t.lines[y][x] = t.blank()
+	}
+}
+
+// reset puts the terminal back in its first state, with an empty screen
+func (t *Terminal) reset() {
+	t.style = defStyle
+	for y := range t.lines {
+		t.lines[y] = t.blankLine(t.w)
+	}
+	t.cx, t.cy = 0, 0
+	t.top, t.bottom = 0, t.h-1
+	t.hideCursor = false
+}
+
+// reply sends the answer to a query of the shell
+func (t *Terminal) reply(s string) {
+	if t.pty != nil {
+		t.pty.WriteString(s)
+	}
+}
+
+// csi runs a control sequence ending with the final byte
+func (t *Terminal) csi(final byte) {
+	params := string(t.params)
+	private := strings.HasPrefix(params, "?")
+	params = strings.TrimLeft(params, "?>=")
+	var nums []int
+	if params != "" {
+		for _, p := range strings.Split(params, ";") {
+			n, _ := strconv.Atoi(p)
+			nums = append(nums, n)
+		}
+	}
+	// arg returns the ith parameter, or def if it is missing or 0
+	arg := func(i, def int) int {
+		if i < len(nums) && nums[i] != 0 {
+			return nums[i]
+		}
+		return def
+	}
+	n := arg(0, 1)
+	cx := Min(t.cx, t.w-1)
+
+	switch final {
+	case 'A':
+		t.cy = Max(t.cy-n, 0)
+	case 'B', 'e':
+		t.cy = Min(t.cy+n, t.h-1)
+	case 'C', 'a':
+		t.cx = Min(cx+n, t.w-1)
+	case 'D':
+		t.cx = Max(cx-n, 0)
+	case 'E':
+		t.cx, t.cy = 0, Min(t.cy+n, t.h-1)
+	case 'F':
+		t.cx, t.cy = 0, Max(t.cy-n, 0)
+	case 'G', '`':
+		t.cx = Min(n-1, t.w-1)
+	case 'd':
+		t.cy = Min(n-1, t.h-1)
+	case 'H', 'f':
+		t.cy = Min(arg(0, 1)-1, t.h-1)
+		t.cx = Min(arg(1, 1)-1, t.w-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.cy, cx, t.w)
+			for y := t.cy + 1; y < t.h; y++ {
+				t.erase(y, 0, t.w)
+			}
+		case 1:
+			for y := 0; y < t.cy; y++ {
+				t.erase(y, 0, t.w)
+			}
+			t.erase(t.cy, 0, cx+1)
+		default:
+			for y := 0; y < t.h; y++ {
+				t.erase(y, 0, t.w)
+			}
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.erase(t.cy, cx, t.w)
+		case 1:
+			t.erase(t.cy, 0, cx+1)
+		default:
+			t.erase(t.cy, 0, t.w)
+		}
+	case 'L':
+		if t.cy >= t.top && t.cy <= t.bottom {
+			t.scrollDown(t.cy, n)
+		}
+	case 'M':
+		if t.cy >= t.top && t.cy <= t.bottom {
+			t.scrollUp(t.cy, n)
+		}
+	case 'P':
+		line := t.lines[t.cy]
+		n = Min(n, t.w-cx)
+		copy(line[cx:], line[cx+n:])
+		t.erase(t.cy, t.w-n, t.w)
+	case '@':
+		line := t.lines[t.cy]
+		n = Min(n, t.w-cx)
+		copy(line[cx+n:], line[cx:])
+		t.erase(t.cy, cx, cx+n)
+	case 'X':
+		t.erase(t.cy, cx, cx+n)
+	case 'S':
+		t.scrollUp(t.top, n)
+	case 'T':
+		t.scrollDown(t.top, n)
+	case 'm':
+		t.sgr(nums)
+	case 'r':
+		top, bottom := arg(0, 1)-1, Min(arg(1, t.h), t.h)-1
+		if top < bottom && !private {
+			t.top, t.bottom = top, bottom
+			t.cx, t.cy = 0, 0
+		}
+	case 's':
+		t.savedX, t.savedY = t.cx, t.cy
+	case 'u':
+		t.cx, t.cy = Min(t.savedX, t.w), Min(t.savedY, t.h-1)
+	case 'h', 'l':
+		if private && arg(0, 0) == 25 {
+			t.hideCursor = final == 'l'
+		}
+	case 'n':
+		switch arg(0, 0) {
+		case 5:
+			t.reply("\x1b[0n")
+		case 6:
+			t.reply("\x1b[" + strconv.Itoa(t.cy+1) + ";" + strconv.Itoa(cx+1) + "R")
+		}
+	case 'c':
+		if arg(0, 0) == 0 && !private {
+			t.reply("\x1b[?1;2c")
+		}
+	}
+}
+
+// sgr sets the style of the characters which are written
+func (t *Terminal) sgr(nums []int) {
+	if len(nums) == 0 {
+		nums = []int{0}
+	}
+	defFg, defBg, _ := defStyle.Decompose()
+	for i := 0; i < len(nums); i++ {
+		switch n := nums[i]; {
+		case n == 0:
+			t.style = defStyle
+		case n == 1:
+			t.style = t.style.Bold(true)
+		case n == 2:
+			t.style = t.style.Dim(true)
+		case n == 3:
+			t.style = t.style.Italic(true)
+		case n == 4:
+			t.style = t.style.Underline(true)
+		case n == 5:
+			t.style = t.style.Blink(true)
+		case n == 7:
+			t.style = t.style.Reverse(true)
+		case n == 22:
+			t.style = t.style.Bold(false).Dim(false)
+		case n == 23:
+			t.style = t.style.Italic(false)
+		case n == 24:
+			t.style = t.style.Underline(false)
+		case n == 25:
+			t.style = t.style.Blink(false)
+		case n == 27:
+			t.style = t.style.Reverse(false)
+		case n >= 30 && n <= 37:
+			t.style = t.style.Foreground(tcell.Color(n - 30))
+		case n == 39:
+			t.style = t.style.Foreground(defFg)
+		case n >= 40 && n <= 47:
+			t.style = t.style.Background(tcell.Color(n - 40))
+		case n == 49:
+			t.style = t.style.Background(defBg)
+		case n >= 90 && n <= 97:
+			t.style = t.style.Foreground(tcell.Color(n - 90 + 8))
+		case n >= 100 && n <= 107:
+			t.style = t.style.Background(tcell.Color(n - 100 + 8))
+		case n == 38 || n == 48:
+			// 38;5;n is a color of the 256 color palette and 38;2;r;g;b is a
+			// true color
+			var c tcell.Color
+			if i+2 < len(nums) && nums[i+1] == 5 {
+				c = tcell.Color(nums[i+2] & 0xff)
+				i += 2
+			} else if i+4 < len(nums) && nums[i+1] == 2 {
+				c = tcell.NewRGBColor(int32(nums[i+2]), int32(nums[i+3]), int32(nums[i+4]))
+				i += 4
+			} else {
+				return
+			}
+			if n == 38 {
+				t.style = t.style.Foreground(c)
+			} else {
+				t.style = t.style.Background(c)
+			}
+		}
+	}
+}
+
+// termKeys are the escape sequences which a VT100 sends for the keys which
+// aren't characters
+var termKeys = map[tcell.Key]string{
+	tcell.KeyUp:     "\x1b[A",
+	tcell.KeyDown:   "\x1b[B",
+	tcell.KeyRight:  "\x1b[C",
+	tcell.KeyLeft:   "\x1b[D",
+	tcell.KeyHome:   "\x1b[H",
+	tcell.KeyEnd:    "\x1b[F",
+	tcell.KeyInsert: "\x1b[2~",
+	tcell.KeyDelete: "\x1b[3~",
+	tcell.KeyPgUp:   "\x1b[5~",
+	tcell.KeyPgDn:   "\x1b[6~",
+	tcell.KeyF1:     "\x1bOP",
+	tcell.KeyF2:     "\x1bOQ",
+	tcell.KeyF3:     "\x1bOR",
+	tcell.KeyF4:     "\x1bOS",
+}
+
+// termKeyBytes returns what the terminal sends to the shell for a key press
+func termKeyBytes(e *tcell.EventKey) string {
+	var s string
+	if e.Key() == tcell.KeyRune {
+		s = string(e.Rune())
+	} else if seq, ok := termKeys[e.Key()]; ok {
+		return seq
+	} else if e.Key() < ' ' || e.Key() == tcell.KeyDEL {
+		// Control characters, like Ctrl-c, Enter, Tab and Backspace
+		s = string(rune(e.Key()))
+	}
+	if s != "" && e.Modifiers()&tcell.ModAlt != 0 {
+		s = "\x1b" + s
+	}
+	return s
+}
+
+// SendKey sends a key press to the shell, and scrolls the terminal back to the
+// bottom
+func (t *Terminal) SendKey(e *tcell.EventKey) {
+	t.Lock()
+	t.scroll = 0
+	t.Unlock()
+	if s := termKeyBytes(e); s != "" && t.pty != nil {
+		t.pty.WriteString(s)
+	}
+}
+
+// Display draws the screen of the terminal, or the part of the scrollback the
+// user scrolled back to, in the view
+func (t *Terminal) Display(v *View) {
+	t.Lock()
+	defer t.Unlock()
+	start := len(t.scrollback) - t.scroll
+	for y := 0; y < v.height; y++ {
+		var line []termCell
+		if i := start + y; i < len(t.scrollback) {
+			line = t.scrollback[i]
+		} else if i-len(t.scrollback) < len(t.lines) {
+			line = t.lines[i-len(t.scrollback)]
+		}
+		for x := 0; x < v.width; x++ {
+			if x < len(line) {
+				v.drawCell(x, y, line[x].ch, line[x].style)
+			} else {
+				v.drawCell(x, y, ' ', defStyle)
+			}
+		}
+	}
+
+	if v != CurView() {
+		return
+	}
+	if t.scroll == 0 && !t.hideCursor && t.cy < v.height {
+		screen.ShowCursor(v.x+Min(t.cx, t.w-1), v.y+t.cy)
+	} else {
+		screen.HideCursor()
+	}
+}
+
+// termActions are the actions which the terminal pane leaves to the editor,
+// instead of sending their keys to the shell, so that the focus can move out
+// of it
+var termActions = map[string]bool{
+	"NextSplit":   true,
+	"PreviousTab": true,
+	"NextTab":     true,
+}
+
+// termEscapeKey is the key which makes the next key do what it does in the
+// editor, like Ctrl-\ Ctrl-e for the command prompt or Ctrl-\ Ctrl-q to
+// close the pane, while the shell is running
+// Pressing it twice sends it to the shell
+const termEscapeKey = tcell.KeyCtrlBackslash
+
+// HandleTermEvent handles an event for a view which shows a terminal
+// Keys go to the shell, and the mouse wheel scrolls through the scrollback
+// Once the shell has exited, any key closes the view
+func (v *View) HandleTermEvent(event tcell.Event) {
+	t := v.term
+	switch e := event.(type) {
+	case *tcell.EventKey:
+		action := BindingFor(e)
+		if t.escaped {
+			t.escaped = false
+			if action != "" && e.Key() != termEscapeKey {
+				actions[action](v)
+				return
+			}
+		} else if e.Key() == termEscapeKey && !t.Exited() {
+			t.escaped = true
+			messenger.Message("The next key goes to the editor")
+			return
+		}
+		if termActions[action] {
+			actions[action](v)
+			return
+		}
+		if t.Exited() {
+			v.Quit()
+			return
+		}
+		t.SendKey(e)
+	case *tcell.EventMouse:
+		switch e.Buttons() {
+		case tcell.WheelUp:
+			t.ScrollBack(3)
+		case tcell.WheelDown:
+			t.ScrollBack(-3)
+		}
+	}
+}
+
+// TermCommand opens a terminal in a split below the view, running the given
+// command, or the user's shell if there isn't one
+func TermCommand(view *View, args []string) {
+	buf := NewBuffer("", "")
+	buf.name = "Terminal"
+	view.HSplit(buf)
+	nv := CurView()
+	// The terminal's buffer is never edited, so it isn't listed with the others
+	CloseBuffer(buf)
+
+	t, err := NewTerminal(strings.Join(args, " "), nv.width, nv.height)
+	if err != nil {
+		messenger.Error("Error opening a terminal: " + err.Error())
+		nv.Quit()
+		return
+	}
+	nv.term = t
+}
+
+// TermCopy opens what the terminal shows, with its scrollback, in a new buffer
+// The terminal is the view's, or the first one in the tab
+func TermCopy(view *View, args []string) {
+	tv := view
+	if tv.term == nil {
+		for _, v := range CurTab().views {
+			if v.term != nil {
+				tv = v
+				break
+			}
+		}
+	}
+	if tv.term == nil {
+		messenger.Error("There is no terminal in this tab")
+		return
+	}
+	buf := NewBuffer(tv.term.Text(), "")
+	buf.name = "Terminal output"
+	view.HSplit(buf)
+}
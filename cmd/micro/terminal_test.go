@@ -0,0 +1,218 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// screenText returns the lines of the terminal's screen, without the spaces at
+// their ends
+func screenText(t *Terminal) []string {
+	var lines []string
+	for _, line := range t.lines {
+		runes := make([]rune, len(line))
+		for x, c := range line {
+			runes[x] = c.ch
+		}
+		lines = append(lines, strings.TrimRight(string(runes), " "))
+	}
+	return lines
+}
+
+func TestTerminalOutput(t *testing.T) {
+	term := newTerminal(10, 3)
+	term.feed([]byte("hello\r\nwörld"))
+	if got := screenText(term); got[0] != "hello" || got[1] != "wörld" {
+		t.Errorf("The screen is %q", got)
+	}
+	if term.cx != 5 || term.cy != 1 {
+		t.Errorf("The cursor is at %d,%d", term.cx, term.cy)
+	}
+
+	// A character cut in half between two reads is put together
+	term.feed([]byte("\r\n\xc3"))
+	term.feed([]byte("\xa9t\xc3\xa9"))
+	if got := screenText(term)[2]; got != "été" {
+		t.Errorf("The split character gave %q", got)
+	}
+
+	// Long lines wrap, and lines scroll off into the scrollback
+	term.feed([]byte("\r\n0123456789abc"))
+	if got := screenText(term); got[1] != "0123456789" || got[2] != "abc" {
+		t.Errorf("The wrapped line is %q", got)
+	}
+	if len(term.scrollback) != 2 {
+		t.Errorf("The scrollback has %d lines, want 2", len(term.scrollback))
+	}
+	if got := term.Text(); got != "hello\nwörld\nété\n0123456789\nabc" {
+		t.Errorf("The text is %q", got)
+	}
+}
+
+func TestTerminalEscapes(t *testing.T) {
+	term := newTerminal(10, 4)
+	term.feed([]byte("aaaa\r\nbbbb\r\ncccc\r\ndddd"))
+
+	// Move the cursor and clear to the end of the line
+	term.feed([]byte("\x1b[2;3H\x1b[K"))
+	if got := screenText(term)[1]; got != "bb" {
+		t.Errorf("Clearing the line gave %q", got)
+	}
+	// Delete and insert lines
+	term.feed([]byte("\x1b[1;1H\x1b[M"))
+	if got := strings.Join(screenText(term), ","); got != "bb,cccc,dddd," {
+		t.Errorf("Deleting a line gave %q", got)
+	}
+	term.feed([]byte("\x1b[2L"))
+	if got := strings.Join(screenText(term), ","); got != ",,bb,cccc" {
+		t.Errorf("Inserting lines gave %q", got)
+	}
+	// Delete and insert characters
+	term.feed([]byte("\x1b[4;2H\x1b[2P"))
+	if got := screenText(term)[3]; got != "cc" {
+		t.Errorf("Deleting characters gave %q", got)
+	}
+	term.feed([]byte("\x1b[1@x"))
+	if got := screenText(term)[3]; got != "cxc" {
+		t.Errorf("Inserting a character gave %q", got)
+	}
+	// Clear the screen
+	term.feed([]byte("\x1b[H\x1b[2J"))
+	if got := strings.Join(screenText(term), ""); got != "" {
+		t.Errorf("The cleared screen is %q", got)
+	}
+	// A title is skipped
+	term.feed([]byte("\x1b]0;title\x07ok"))
+	if got := screenText(term)[0]; got != "ok" {
+		t.Errorf("The title gave %q", got)
+	}
+}
+
+func TestTerminalScrollRegion(t *testing.T) {
+	term := newTerminal(5, 4)
+	term.feed([]byte("1\r\n2\r\n3\r\n4"))
+	// Scroll the middle two lines, which doesn't go to the scrollback
+	term.feed([]byte("\x1b[2;3r\x1b[3;1H\nx"))
+	if got := strings.Join(screenText(term), ","); got != "1,3,x,4" {
+		t.Errorf("Scrolling the region gave %q", got)
+	}
+	if len(term.scrollback) != 0 {
+		t.Errorf("The region scrolled %d lines into the scrollback", len(term.scrollback))
+	}
+	term.feed([]byte("\x1b[2;1H\x1bMy"))
+	if got := strings.Join(screenText(term), ","); got != "1,y,3,4" {
+		t.Errorf("Reverse scrolling the region gave %q", got)
+	}
+}
+
+func TestTerminalColors(t *testing.T) {
+	term := newTerminal(10, 1)
+	term.feed([]byte("\x1b[1;31ma\x1b[38;5;200;44mb\x1b[0mc"))
+	fg, bg, attr := term.lines[0][0].style.Decompose()
+	if fg != tcell.ColorMaroon || attr&tcell.AttrBold == 0 {
+		t.Errorf("The first character has %v, %v", fg, attr)
+	}
+	fg, bg, _ = term.lines[0][1].style.Decompose()
+	if fg != tcell.Color(200) || bg != tcell.ColorNavy {
+		t.Errorf("The second character has %v on %v", fg, bg)
+	}
+	if style := term.lines[0][2].style; style != defStyle {
+		t.Errorf("The style wasn't reset")
+	}
+}
+
+func TestTerminalResize(t *testing.T) {
+	term := newTerminal(6, 3)
+	term.feed([]byte("a\r\nb\r\nc"))
+	term.resize(4, 2)
+	if got := strings.Join(screenText(term), ","); got != "b,c" {
+		t.Errorf("Shrinking the terminal gave %q", got)
+	}
+	if term.cy != 1 || len(term.scrollback) != 1 {
+		t.Errorf("The cursor is on line %d with %d lines of scrollback", term.cy, len(term.scrollback))
+	}
+	term.resize(8, 4)
+	if len(term.lines) != 4 || len(term.lines[0]) != 8 {
+		t.Errorf("Growing the terminal gave %dx%d", len(term.lines[0]), len(term.lines))
+	}
+}
+
+func TestTermKeyBytes(t *testing.T) {
+	tests := []struct {
+		key  *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyRune, 'é', tcell.ModNone), "é"},
+		{tcell.NewEventKey(tcell.KeyRune, 'b', tcell.ModAlt), "\x1bb"},
+		{tcell.NewEventKey(tcell.KeyCtrlC, 0, tcell.ModCtrl), "\x03"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "\r"},
+		{tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone), "\x7f"},
+		{tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone), "\x1b[A"},
+		{tcell.NewEventKey(tcell.KeyF12, 0, tcell.ModNone), ""},
+	}
+	for _, test := range tests {
+		if got := termKeyBytes(test.key); got != test.want {
+			t.Errorf("%s gave %q, want %q", test.key.Name(), got, test.want)
+		}
+	}
+}
+
+func TestNewTerminal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The terminal only works on linux")
+	}
+	term, err := NewTerminal("echo hello; stty size", 20, 5)
+	if err != nil {
+		t.Skip("Can't open a pseudo-terminal: " + err.Error())
+	}
+	defer term.Close()
+	for i := 0; i < 100 && !term.Exited(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !term.Exited() {
+		t.Fatal("The command didn't exit")
+	}
+	if got := term.Text(); got != "hello\n5 20" {
+		t.Errorf("The terminal shows %q", got)
+	}
+}
+
+func TestTerminalEscapeKey(t *testing.T) {
+	messenger = new(Messenger)
+	InitBindings()
+	ran := false
+	actions["TestAction"] = func(v *View) bool { ran = true; return true }
+	defer delete(actions, "TestAction")
+	BindKey("F5", "TestAction")
+	defer InitBindings()
+
+	v := &View{buf: NewBuffer("", ""), term: newTerminal(10, 2)}
+	f5 := tcell.NewEventKey(tcell.KeyF5, 0, tcell.ModNone)
+	v.HandleTermEvent(f5)
+	if ran {
+		t.Error("F5 went to the editor without the escape key")
+	}
+	v.HandleTermEvent(tcell.NewEventKey(termEscapeKey, 0, tcell.ModCtrl))
+	v.HandleTermEvent(f5)
+	if !ran || v.term.escaped {
+		t.Error("F5 didn't go to the editor after the escape key")
+	}
+}
+
+func TestTerminalSetBuffer(t *testing.T) {
+	messenger = new(Messenger)
+	v := &View{buf: NewBuffer("", ""), term: newTerminal(10, 2)}
+	v.cursor.v = v
+	buf := NewBuffer("a", "")
+	defer func() { buffers = nil }()
+
+	// Showing a buffer in the terminal's view stops the shell, and the view
+	// shows the buffer like any other
+	v.SetBuffer(buf)
+	if v.term != nil || v.buf != buf {
+		t.Error("The view still shows the terminal")
+	}
+}
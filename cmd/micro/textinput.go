@@ -0,0 +1,250 @@
+package main
+
+import (
+	"github.com/atotto/clipboard"
+	"github.com/gdamore/tcell"
+	"github.com/mattn/go-runewidth"
+	"strings"
+	"unicode"
+)
+
+// A TextInput is a single line of text which the user can edit, like the
+// response to a prompt
+// The text is kept as runes, so that the cursor always moves by a whole character
+type TextInput struct {
+	text []rune
+	// The cursor is before the character at this index
+	cursor int
+	// The first character shown on the screen, when the text is too long to
+	// fit in its space
+	scroll int
+}
+
+// Text returns the text of the input
+func (t *TextInput) Text() string {
+	return string(t.text)
+}
+
+// SetText replaces the text of the input, and puts the cursor at its end
+func (t *TextInput) SetText(text string) {
+	t.text = []rune(text)
+	t.cursor = len(t.text)
+}
+
+// Cursor returns where the cursor is in the text, in characters
+func (t *TextInput) Cursor() int {
+	return t.cursor
+}
+
+// SetCursor moves the cursor to the character at x, keeping it in the text
+func (t *TextInput) SetCursor(x int) {
+	t.cursor = Max(0, Min(x, len(t.text)))
+}
+
+// Replace replaces the characters from start up to end with text, and puts
+// the cursor after the new text
+func (t *TextInput) Replace(start, end int, text string) {
+	runes := []rune(text)
+	newText := make([]rune, 0, len(t.text)-(end-start)+len(runes))
+	newText = append(newText, t.text[:start]...)
+	newText = append(newText, runes...)
+	newText = append(newText, t.text[end:]...)
+	t.text = newText
+	t.cursor = start + len(runes)
+}
+
+// Insert inserts text at the cursor
+// A single line is being edited, so newlines become spaces
+func (t *TextInput) Insert(text string) {
+	text = strings.Replace(text, "\r\n", " ", -1)
+	text = strings.Replace(text, "\n", " ", -1)
+	t.Replace(t.cursor, t.cursor, text)
+}
+
+// Clear empties the input
+func (t *TextInput) Clear() {
+	t.text = nil
+	t.cursor = 0
+	t.scroll = 0
+}
+
+// isWordRune returns whether the character is part of a word
+// Unlike IsWordChar, letters and digits of every language count
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// wordStart returns where the word before the cursor starts, skipping the
+// spaces and punctuation between it and the cursor
+func (t *TextInput) wordStart() int {
+	x := t.cursor
+	for x > 0 && !isWordRune(t.text[x-1]) {
+		x--
+	}
+	for x > 0 && isWordRune(t.text[x-1]) {
+		x--
+	}
+	return x
+}
+
+// wordEnd returns where the word after the cursor ends, skipping the spaces
+// and punctuation between the cursor and it
+func (t *TextInput) wordEnd() int {
+	x := t.cursor
+	for x < len(t.text) && !isWordRune(t.text[x]) {
+		x++
+	}
+	for x < len(t.text) && isWordRune(t.text[x]) {
+		x++
+	}
+	return x
+}
+
+// HandleEvent edits the text for a key press, or moves the cursor for a click
+// in the input
+// A click's x must be relative to where the input starts on the screen
+// It returns whether the event was used
+//
+// Left, Right      move by a character (by a word with Ctrl or Alt)
+// Alt-b, Alt-f     move by a word
+// Home, End        go to the start or the end (so do Ctrl-a and Ctrl-e)
+// Backspace        delete the character before the cursor
+// Delete           delete the character after the cursor
+// Ctrl-w           delete the word before the cursor
+// Ctrl-u, Ctrl-k   delete everything before or after the cursor
+// Ctrl-v           paste
+func (t *TextInput) HandleEvent(event tcell.Event) bool {
+	switch e := event.(type) {
+	case *tcell.EventKey:
+		word := e.Modifiers()&(tcell.ModCtrl|tcell.ModAlt) != 0
+		switch e.Key() {
+		case tcell.KeyLeft:
+			if word {
+				t.cursor = t.wordStart()
+			} else if t.cursor > 0 {
+				t.cursor--
+			}
+		case tcell.KeyRight:
+			if word {
+				t.cursor = t.wordEnd()
+			} else if t.cursor < len(t.text) {
+				t.cursor++
+			}
+		case tcell.KeyHome, tcell.KeyCtrlA:
+			t.cursor = 0
+		case tcell.KeyEnd, tcell.KeyCtrlE:
+			t.cursor = len(t.text)
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if t.cursor > 0 {
+				t.Replace(t.cursor-1, t.cursor, "")
+			}
+		case tcell.KeyDelete:
+			if t.cursor < len(t.text) {
+				t.Replace(t.cursor, t.cursor+1, "")
+			}
+		case tcell.KeyCtrlW:
+			t.Replace(t.wordStart(), t.cursor, "")
+		case tcell.KeyCtrlU:
+			t.Replace(0, t.cursor, "")
+		case tcell.KeyCtrlK:
+			t.text = t.text[:t.cursor]
+		case tcell.KeyCtrlV:
+			if clipboard.Unsupported {
+				return true
+			}
+			clip, _ := clipboard.ReadAll()
+			t.Insert(clip)
+		case tcell.KeySpace:
+			t.Insert(" ")
+		case tcell.KeyRune:
+			if e.Modifiers()&tcell.ModAlt != 0 {
+				switch e.Rune() {
+				case 'b':
+					t.cursor = t.wordStart()
+				case 'f':
+					t.cursor = t.wordEnd()
+				default:
+					return false
+				}
+				return true
+			}
+			t.Insert(string(e.Rune()))
+		default:
+			return false
+		}
+		return true
+	case *tcell.EventMouse:
+		if e.Buttons() != tcell.Button1 {
+			return false
+		}
+		x, _ := e.Position()
+		// Find the character drawn in the cell, or the end of the text
+		i, col := t.scroll, 0
+		for i < len(t.text) && col+runeWidth(t.text[i]) <= x {
+			col += runeWidth(t.text[i])
+			i++
+		}
+		t.SetCursor(i)
+		return true
+	}
+	return false
+}
+
+// runeWidth returns the number of cells a character takes on the screen
+// Characters like the combining accents, which have no width of their own,
+// are given a cell too
+func runeWidth(r rune) int {
+	return Max(1, runewidth.RuneWidth(r))
+}
+
+// stringWidth returns the number of cells the string takes on the screen
+func stringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// Display draws the text at x, y in a space of the given width, scrolling it
+// sideways if it doesn't fit so that the cursor can be seen
+// Wide characters, like the CJK ones, take two cells
+// It returns the column of the cursor on the screen
+func (t *TextInput) Display(x, y, width int, style tcell.Style) int {
+	if width <= 0 {
+		return x
+	}
+	// The cursor needs a cell to itself at the end of the text
+	if t.cursor < t.scroll {
+		t.scroll = t.cursor
+	}
+	cursorWidth := 1
+	if t.cursor < len(t.text) {
+		cursorWidth = runeWidth(t.text[t.cursor])
+	}
+	for t.scroll < t.cursor && stringWidth(string(t.text[t.scroll:t.cursor]))+cursorWidth > width {
+		t.scroll++
+	}
+	// Don't leave space at the end when the text is shortened
+	t.scroll = Min(t.scroll, len(t.text))
+	for t.scroll > 0 && stringWidth(string(t.text[t.scroll-1:]))+1 <= width {
+		t.scroll--
+	}
+
+	col, cursorx := 0, 0
+	for i := t.scroll; i < len(t.text); i++ {
+		if i == t.cursor {
+			cursorx = col
+		}
+		w := runeWidth(t.text[i])
+		if col+w > width {
+			break
+		}
+		screen.SetContent(x+col, y, t.text[i], nil, style)
+		col += w
+	}
+	if t.cursor == len(t.text) {
+		cursorx = col
+	}
+	return x + cursorx
+}
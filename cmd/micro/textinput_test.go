@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/gdamore/tcell"
+	"testing"
+)
+
+func TestTextInput(t *testing.T) {
+	var in TextInput
+	key := func(k tcell.Key, r rune, mod tcell.ModMask) {
+		in.HandleEvent(tcell.NewEventKey(k, r, mod))
+	}
+
+	for _, r := range "héllo wörld" {
+		key(tcell.KeyRune, r, tcell.ModNone)
+	}
+	// Multibyte characters are deleted whole
+	key(tcell.KeyLeft, 0, tcell.ModNone)
+	key(tcell.KeyLeft, 0, tcell.ModNone)
+	key(tcell.KeyLeft, 0, tcell.ModNone)
+	key(tcell.KeyBackspace2, 0, tcell.ModNone)
+	if got := in.Text(); got != "héllo wrld" || in.Cursor() != 7 {
+		t.Errorf("Backspace gave %q with the cursor at %d", got, in.Cursor())
+	}
+	key(tcell.KeyDelete, 0, tcell.ModNone)
+	if got := in.Text(); got != "héllo wld" {
+		t.Errorf("Delete gave %q", got)
+	}
+
+	key(tcell.KeyHome, 0, tcell.ModNone)
+	key(tcell.KeyRight, 0, tcell.ModCtrl)
+	if in.Cursor() != 5 {
+		t.Errorf("Ctrl-Right moved the cursor to %d, want 5", in.Cursor())
+	}
+	key(tcell.KeyRune, 'b', tcell.ModAlt)
+	if in.Cursor() != 0 {
+		t.Errorf("Alt-b moved the cursor to %d, want 0", in.Cursor())
+	}
+	key(tcell.KeyEnd, 0, tcell.ModNone)
+	key(tcell.KeyCtrlW, 0, tcell.ModCtrl)
+	if got := in.Text(); got != "héllo " {
+		t.Errorf("Ctrl-W gave %q", got)
+	}
+	key(tcell.KeyLeft, 0, tcell.ModNone)
+	key(tcell.KeyCtrlU, 0, tcell.ModCtrl)
+	if got := in.Text(); got != " " || in.Cursor() != 0 {
+		t.Errorf("Ctrl-U gave %q with the cursor at %d", got, in.Cursor())
+	}
+	key(tcell.KeyCtrlK, 0, tcell.ModCtrl)
+	if got := in.Text(); got != "" {
+		t.Errorf("Ctrl-K gave %q", got)
+	}
+
+	// Pasted newlines become spaces
+	in.Insert("a\nb")
+	if got := in.Text(); got != "a b" {
+		t.Errorf("Inserting a newline gave %q", got)
+	}
+}
+
+func TestTextInputDisplay(t *testing.T) {
+	s := tcell.NewSimulationScreen("")
+	s.Init()
+	s.SetSize(20, 1)
+	screen = s
+	defer func() { screen = nil }()
+
+	var in TextInput
+	in.SetText("abcdefghij")
+	// The text scrolls so that the cursor at the end can be seen
+	if x := in.Display(2, 0, 5, defStyle); x != 6 || in.scroll != 6 {
+		t.Errorf("The cursor is drawn at %d with the text scrolled by %d, want 6 and 6", x, in.scroll)
+	}
+
+	// A click picks the character which is shown there
+	in.HandleEvent(tcell.NewEventMouse(1, 0, tcell.Button1, tcell.ModNone))
+	if in.Cursor() != 7 {
+		t.Errorf("Clicking put the cursor at %d, want 7", in.Cursor())
+	}
+
+	in.SetCursor(0)
+	if x := in.Display(2, 0, 5, defStyle); x != 2 || in.scroll != 0 {
+		t.Errorf("The cursor is drawn at %d with the text scrolled by %d, want 2 and 0", x, in.scroll)
+	}
+}
+
+func TestTextInputDisplayWide(t *testing.T) {
+	s := tcell.NewSimulationScreen("")
+	s.Init()
+	s.SetSize(20, 1)
+	screen = s
+	defer func() { screen = nil }()
+
+	// Each of the characters takes two cells
+	var in TextInput
+	in.SetText("日本語です")
+	if x := in.Display(0, 0, 7, defStyle); x != 6 || in.scroll != 2 {
+		t.Errorf("The cursor is drawn at %d with the text scrolled by %d, want 6 and 2", x, in.scroll)
+	}
+	if r, _, _, _ := s.GetContent(2, 0); r != 'で' {
+		t.Errorf("The second character shown is %q", r)
+	}
+
+	// Both cells of a character pick it
+	in.HandleEvent(tcell.NewEventMouse(3, 0, tcell.Button1, tcell.ModNone))
+	if in.Cursor() != 3 {
+		t.Errorf("Clicking put the cursor at %d, want 3", in.Cursor())
+	}
+	if x := in.Display(0, 0, 7, defStyle); x != 2 {
+		t.Errorf("The cursor is drawn at %d, want 2", x)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SerializedTextEvent is the representation of a TextEvent which is saved to disk
+type SerializedTextEvent struct {
+	EventType int
+	Text      string
+	Start     int
+	End       int
+	Time      time.Time
+
+	// Where the cursor was before the event
+	CursorX int
+	CursorY int
+
+	// Where the event is in the undo tree
+	Parent    int
+	RedoChild int
+	Group     int
+}
+
+// SerializedHistory is the undo tree of a buffer which is saved to disk
+// The events are stored in the order they were made, and the initial state is not
+// stored, so event i is the node with sequence number i+1
+type SerializedHistory struct {
+	// Hash of the buffer's text when the history was saved
+	// If the file has changed since then, the history is useless
+	Hash   string
+	Events []SerializedTextEvent
+	// The sequence number of the current state
+	Current       int
+	RootRedoChild int
+}
+
+// UndoDir returns the directory where the undo histories are stored
+func UndoDir() string {
+	return configDir + "/undo"
+}
+
+// UndoFile returns the file where the undo history for the file at path is stored
+// The absolute path of the file is escaped so that every file gets its own history
+func UndoFile(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return UndoDir() + "/" + strings.Replace(abs, "/", "%", -1)
+}
+
+// HashText returns the hash which identifies the text of a buffer
+func HashText(text string) string {
+	sum := md5.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveUndo writes the buffer's undo history to the undo directory
+// A buffer with unsaved changes keeps the history written when it was last
+// saved, because that is the one which goes with the file
+func (b *Buffer) SaveUndo() error {
+	if !settings.PersistentUndo || b.path == "" || b.IsDirty() {
+		return nil
+	}
+	if _, err := os.Stat(UndoDir()); os.IsNotExist(err) {
+		if err := os.Mkdir(UndoDir(), os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	history := serializeTree(b.eh.tree)
+	history.Hash = HashText(b.String())
+
+	file, err := os.Create(UndoFile(b.path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return gob.NewEncoder(file).Encode(history)
+}
+
+// LoadUndo restores the buffer's undo history from the undo directory
+// The history is discarded if the file has been changed since it was saved
+func (b *Buffer) LoadUndo() error {
+	if !settings.PersistentUndo || b.path == "" {
+		return nil
+	}
+	file, err := os.Open(UndoFile(b.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	var history SerializedHistory
+	if err := gob.NewDecoder(file).Decode(&history); err != nil {
+		return err
+	}
+	if history.Hash != HashText(b.String()) {
+		return nil
+	}
+
+	b.eh.tree = b.deserializeTree(history)
+	return nil
+}
+
+// serializeTree stores the events of the tree as they are: the events of the
+// states which are currently undone have already been inverted, and will be
+// inverted again when they are redone
+func serializeTree(t *UndoTree) SerializedHistory {
+	var history SerializedHistory
+	for _, n := range t.nodes[1:] {
+		history.Events = append(history.Events, SerializedTextEvent{
+			EventType: n.event.eventType,
+			Text:      n.event.text,
+			Start:     n.event.start,
+			End:       n.event.end,
+			Time:      n.event.time,
+			CursorX:   n.event.c.x,
+			CursorY:   n.event.c.y,
+			Parent:    n.parent.seq,
+			RedoChild: n.redoChild,
+			Group:     n.group,
+		})
+	}
+	history.Current = t.cur.seq
+	history.RootRedoChild = t.root.redoChild
+	return history
+}
+
+func (b *Buffer) deserializeTree(history SerializedHistory) *UndoTree {
+	t := NewUndoTree()
+	t.root.redoChild = history.RootRedoChild
+	if len(history.Events) > 0 {
+		// The initial state was created when the first change was made
+		t.root.time = history.Events[0].Time
+	}
+
+	for i, e := range history.Events {
+		if e.Parent < 0 || e.Parent > i {
+			// The parent must have been created before the event
+			return NewUndoTree()
+		}
+		te := &TextEvent{
+			eventType: e.EventType,
+			text:      e.Text,
+			start:     e.Start,
+			end:       e.End,
+			buf:       b,
+			time:      e.Time,
+		}
+		// The cursor's view is filled in when the event is undone or redone
+		te.c.x, te.c.y = e.CursorX, e.CursorY
+
+		parent := t.nodes[e.Parent]
+		n := &UndoNode{
+			event:     te,
+			parent:    parent,
+			redoChild: e.RedoChild,
+			seq:       i + 1,
+			time:      e.Time,
+			group:     e.Group,
+		}
+		parent.children = append(parent.children, n)
+		t.nodes = append(t.nodes, n)
+	}
+
+	if history.Current < 0 || history.Current >= len(t.nodes) {
+		return NewUndoTree()
+	}
+	// A redo must go to one of the children, so a history which was cut short
+	// or edited is thrown away
+	for _, n := range t.nodes {
+		if n.redoChild < 0 || n.redoChild >= Max(len(n.children), 1) {
+			return NewUndoTree()
+		}
+	}
+	t.cur = t.nodes[history.Current]
+	return t
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// UndoNode is a state of the buffer in the undo tree
+// The state is reached by executing the node's event on the state of its parent
+type UndoNode struct {
+	event    *TextEvent
+	parent   *UndoNode
+	children []*UndoNode
+
+	// The index of the child that redo moves to
+	// This is the branch which was made or visited last
+	redoChild int
+
+	// The order in which the nodes were created, the root is 0
+	seq int
+	// When the state was created
+	time time.Time
+	// Events in the same group are undone and redone together
+	// 0 means that the event is not in a group
+	group int
+}
+
+// UndoTree stores every state the buffer has been in, so that making a change
+// after undoing creates a new branch instead of throwing the redo history away
+type UndoTree struct {
+	root *UndoNode
+	// The state the buffer is currently in
+	cur *UndoNode
+	// Every node in the order they were created
+	nodes []*UndoNode
+}
+
+// NewUndoTree returns an undo tree which only holds the initial state
+func NewUndoTree() *UndoTree {
+	t := new(UndoTree)
+	t.root = &UndoNode{time: time.Now()}
+	t.cur = t.root
+	t.nodes = []*UndoNode{t.root}
+	return t
+}
+
+// Add adds a new state reached by executing the event on the current state,
+// and makes it the current state
+func (t *UndoTree) Add(e *TextEvent) *UndoNode {
+	n := &UndoNode{
+		event:  e,
+		parent: t.cur,
+		seq:    len(t.nodes),
+		time:   e.time,
+	}
+	t.cur.children = append(t.cur.children, n)
+	t.cur.redoChild = len(t.cur.children) - 1
+	t.cur = n
+	t.nodes = append(t.nodes, n)
+	return n
+}
+
+// Len returns the number of changes in the tree
+func (t *UndoTree) Len() int {
+	return len(t.nodes) - 1
+}
+
+// RedoNode returns the state that redo moves to, or nil if there is none
+func (t *UndoTree) RedoNode() *UndoNode {
+	if len(t.cur.children) == 0 {
+		return nil
+	}
+	return t.cur.children[t.cur.redoChild]
+}
+
+// Path returns the states which must be undone, and then redone, to get from
+// the current state to the target
+func (t *UndoTree) Path(target *UndoNode) (undo []*UndoNode, redo []*UndoNode) {
+	// Mark the ancestors of the target so we know when we reach the common one
+	ancestors := make(map[*UndoNode]bool)
+	for n := target; n != nil; n = n.parent {
+		ancestors[n] = true
+	}
+
+	n := t.cur
+	for !ancestors[n] {
+		undo = append(undo, n)
+		n = n.parent
+	}
+
+	// Walk back up from the target, and reverse the path on the way down
+	down := new(Stack)
+	for m := target; m != n; m = m.parent {
+		down.Push(m)
+	}
+	for down.Len() > 0 {
+		redo = append(redo, down.Pop().(*UndoNode))
+	}
+	return undo, redo
+}
+
+// Seq returns the state which was created n changes after the current one
+// (or before it, if n is negative), stopping at the first and last states
+// The events of a group count as one change, and the states in the middle of
+// a group are skipped
+func (t *UndoTree) Seq(n int) *UndoNode {
+	i := t.cur.seq
+	for ; n < 0 && i > 0; n++ {
+		i--
+		for !t.groupEnd(i) {
+			i--
+		}
+	}
+	for ; n > 0 && i < len(t.nodes)-1; n-- {
+		i++
+		for !t.groupEnd(i) {
+			i++
+		}
+	}
+	return t.nodes[i]
+}
+
+// groupEnd returns whether the state i is not in the middle of a group, so
+// that Earlier and Later can stop there
+func (t *UndoTree) groupEnd(i int) bool {
+	group := t.nodes[i].group
+	return group == 0 || i == len(t.nodes)-1 || t.nodes[i+1].group != group
+}
+
+// AtTime returns the last state which was created before the given time
+// If all the changes were made after it, this is the initial state
+func (t *UndoTree) AtTime(when time.Time) *UndoNode {
+	target := t.root
+	for i, n := range t.nodes[1:] {
+		if !n.time.After(when) && t.groupEnd(i+1) {
+			target = n
+		}
+	}
+	return target
+}
+
+// Visualize draws the tree as text, one line per branch
+// Changes without alternatives are collapsed into ranges, and the
+// current state is marked with a '*'
+func (t *UndoTree) Visualize() []string {
+	return t.drawBranch(t.root)
+}
+
+func (t *UndoTree) drawBranch(n *UndoNode) []string {
+	// Follow the branch as long as there is no other way to go
+	start := n
+	for len(n.children) == 1 && n != t.cur {
+		n = n.children[0]
+	}
+
+	label := strconv.Itoa(start.seq)
+	if n != start {
+		label += "-" + strconv.Itoa(n.seq)
+	}
+	if n == t.cur {
+		label += "*"
+	}
+	if len(n.children) == 0 {
+		return []string{label}
+	}
+
+	pad := Spaces(Count(label))
+	var lines []string
+	for i, child := range n.children {
+		sub := t.drawBranch(child)
+		if i == 0 {
+			lines = append(lines, label+" - "+sub[0])
+		} else {
+			lines = append(lines, pad+" \\ "+sub[0])
+		}
+		for _, l := range sub[1:] {
+			lines = append(lines, pad+"   "+l)
+		}
+	}
+	return lines
+}
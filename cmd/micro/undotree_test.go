@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestEventHandler(txt string) *EventHandler {
+	b := NewBuffer(txt, "")
+	v := &View{buf: b}
+	v.cursor.v = v
+	b.eh.v = v
+	return b.eh
+}
+
+func TestUndoTreeBranches(t *testing.T) {
+	eh := newTestEventHandler("")
+
+	eh.Insert(0, "a")
+	eh.Insert(1, "b")
+	eh.UndoOneEvent()
+	// This used to throw away the redo history
+	eh.Insert(1, "c")
+
+	if eh.buf.String() != "ac" {
+		t.Errorf("Insert after undo gave %q", eh.buf.String())
+	}
+	if eh.tree.Len() != 3 {
+		t.Errorf("Len = %d, want 3", eh.tree.Len())
+	}
+
+	// Both branches are still reachable
+	eh.Earlier(1)
+	if eh.buf.String() != "ab" {
+		t.Errorf("Earlier(1) gave %q", eh.buf.String())
+	}
+	eh.Earlier(2)
+	if eh.buf.String() != "" {
+		t.Errorf("Earlier(2) gave %q", eh.buf.String())
+	}
+	eh.Later(3)
+	if eh.buf.String() != "ac" {
+		t.Errorf("Later(3) gave %q", eh.buf.String())
+	}
+
+	// Redo follows the branch which was visited last
+	eh.Earlier(1)
+	eh.UndoOneEvent()
+	eh.RedoOneEvent()
+	if eh.buf.String() != "ab" {
+		t.Errorf("Redo gave %q", eh.buf.String())
+	}
+}
+
+func TestUndoTreeGroups(t *testing.T) {
+	eh := newTestEventHandler("")
+
+	eh.Insert(0, "a")
+	eh.BeginGroup()
+	eh.Insert(1, "b")
+	eh.Insert(2, "c")
+	eh.EndGroup()
+	eh.Insert(3, "d")
+
+	// The group is a single change
+	eh.Earlier(2)
+	if eh.buf.String() != "a" {
+		t.Errorf("Earlier(2) gave %q", eh.buf.String())
+	}
+	eh.Later(1)
+	if eh.buf.String() != "abc" {
+		t.Errorf("Later(1) gave %q", eh.buf.String())
+	}
+}
+
+func TestUndoTreeTime(t *testing.T) {
+	eh := newTestEventHandler("")
+
+	now := time.Now()
+	for i, s := range []string{"a", "b", "c"} {
+		eh.Insert(i, s)
+		// Pretend the changes were made a minute apart
+		eh.tree.cur.time = now.Add(time.Duration(i) * time.Minute)
+	}
+
+	eh.EarlierTime(90 * time.Second)
+	if eh.buf.String() != "a" {
+		t.Errorf("EarlierTime(90s) gave %q", eh.buf.String())
+	}
+	eh.LaterTime(time.Minute)
+	if eh.buf.String() != "ab" {
+		t.Errorf("LaterTime(1m) gave %q", eh.buf.String())
+	}
+}
+
+func TestUndoTreeVisualize(t *testing.T) {
+	tree := NewUndoTree()
+	a := tree.Add(&TextEvent{})
+	tree.Add(&TextEvent{})
+	tree.Add(&TextEvent{})
+	tree.cur = a
+	tree.Add(&TextEvent{})
+
+	want := []string{
+		"0-1 - 2-3",
+		"    \\ 4*",
+	}
+	got := tree.Visualize()
+	if len(got) != len(want) {
+		t.Fatalf("Visualize() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Visualize() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSaveUndoDirty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "micro-undo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldConfigDir, oldSettings := configDir, settings
+	configDir, settings.PersistentUndo = dir, true
+	defer func() { configDir, settings = oldConfigDir, oldSettings }()
+
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("a"), 0644)
+	buf, _ := NewBufferFromFile(path)
+	v := &View{buf: buf}
+	v.cursor.v = v
+	buf.eh.v = v
+	buf.eh.Insert(1, "b")
+	buf.Save()
+	if err := buf.SaveUndo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Quitting without saving the next change keeps the history of the file
+	buf.eh.Insert(2, "c")
+	buf.SaveUndo()
+	buf, _ = NewBufferFromFile(path)
+	buf.eh.v = v
+	buf.eh.Undo()
+	if got := buf.String(); got != "a" {
+		t.Errorf("Undoing in the reopened file gave %q", got)
+	}
+}
+
+func TestDeserializeTreeInvalid(t *testing.T) {
+	eh := newTestEventHandler("")
+	eh.Insert(0, "a")
+	eh.Insert(1, "b")
+	eh.UndoOneEvent()
+	eh.Insert(1, "c")
+	history := serializeTree(eh.tree)
+	if tree := eh.buf.deserializeTree(history); tree.Len() != 3 {
+		t.Fatalf("The history has %d changes, want 3", tree.Len())
+	}
+
+	// A history which points at states which aren't there is thrown away
+	tests := map[string]func(h *SerializedHistory){
+		"redo child": func(h *SerializedHistory) { h.Events[0].RedoChild = 2 },
+		"root":       func(h *SerializedHistory) { h.RootRedoChild = 1 },
+		"negative":   func(h *SerializedHistory) { h.Events[0].RedoChild = -1 },
+		"parent":     func(h *SerializedHistory) { h.Events[1].Parent = -1 },
+		"current":    func(h *SerializedHistory) { h.Current = 4 },
+		// The second branch was lost, but the first event still redoes to it
+		"cut short": func(h *SerializedHistory) { h.Events, h.Current = h.Events[:2], 2 },
+	}
+	for name, change := range tests {
+		h := serializeTree(eh.tree)
+		change(&h)
+		if tree := eh.buf.deserializeTree(h); tree.Len() != 0 {
+			t.Errorf("%s: the history has %d changes, want 0", name, tree.Len())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"strings"
 	"unicode/utf8"
 )
 
@@ -33,6 +34,11 @@ func Spaces(n int) string {
 	return str
 }
 
+// GetLeadingWhitespace returns the spaces and tabs at the start of a string
+func GetLeadingWhitespace(str string) string {
+	return str[:len(str)-len(strings.TrimLeft(str, " \t"))]
+}
+
 // Min takes the min of two ints
 func Min(a, b int) int {
 	if a > b {
@@ -3,9 +3,7 @@ package main
 import (
 	"github.com/atotto/clipboard"
 	"github.com/gdamore/tcell"
-	"io/ioutil"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -14,31 +12,54 @@ import (
 // that the user sees the buffer from.
 type View struct {
 	cursor Cursor
+	// The extra cursors, for editing at several places at once
+	cursors []Cursor
+
+	// Whether there is a block selection, and the corner of it which isn't the cursor
+	// as a visual column and a line
+	blockMode   bool
+	blockAnchor [2]int
 
 	// The topmost line, used for vertical scrolling
 	topline int
+	// The first row of the topmost line which is visible, when the line is wrapped
+	topRow int
 	// The leftmost column, used for horizontal scrolling
 	leftCol int
 
-	// Percentage of the terminal window that this view takes up (from 0 to 100)
+	// Percentage of the parent split that this view takes up (from 0 to 100)
 	widthPercent  int
 	heightPercent int
 
+	// The location of the top left corner of the view on the screen
+	x int
+	y int
+
 	// Actual with and height
 	width  int
 	height int
 
+	// The node of the split tree which holds this view
+	splitNode *LeafNode
+
 	// How much to offset because of line numbers
 	lineNumOffset int
 
-	// The eventhandler for undo/redo
-	eh *EventHandler
-
 	// The buffer
 	buf *Buffer
 	// The statusline
 	sline Statusline
 
+	// The terminal the view shows instead of its buffer, if it is a terminal pane
+	term *Terminal
+
+	// The diff which compares the view with the one next to it, if it is in one
+	diff *DiffView
+
+	// The gutter message which is shown in the messenger, and the line it is on
+	gutterMessage string
+	gutterLine    int
+
 	// Since tcell doesn't differentiate between a mouse release event
 	// and a mouse move event with no keys pressed, we need to keep
 	// track of whether or not the mouse was pressed (or not released) last event to determine
@@ -56,6 +77,11 @@ type View struct {
 	// Same here, just to keep track for mouse move events
 	tripleClick bool
 
+	// Whether the mouse was pressed with Alt, and where
+	// This is a click if the mouse is released at the same place, and a drag otherwise
+	altPressed bool
+	altClick   [2]int
+
 	// Syntax highlighting matches
 	matches SyntaxMatches
 	// The matches from the last frame
@@ -76,10 +102,13 @@ func NewViewWidthHeight(buf *Buffer, w, h int) *View {
 	v := new(View)
 
 	v.buf = buf
+	AddBuffer(buf)
 
 	v.widthPercent = w
 	v.heightPercent = h
-	v.Resize(screen.Size())
+	// The split tree will lay the view out properly once it is added to it
+	sw, sh := screen.Size()
+	v.Resize(sw*w/100, (sh-1)*h/100)
 
 	v.topline = 0
 	// Put the cursor at the first spot
@@ -90,8 +119,6 @@ func NewViewWidthHeight(buf *Buffer, w, h int) *View {
 	}
 	v.cursor.ResetSelection()
 
-	v.eh = NewEventHandler(v)
-
 	v.sline = Statusline{
 		view: v,
 	}
@@ -114,20 +141,24 @@ func (v *View) UpdateLines(start, end int) {
 	v.updateLines[1] = end + 1
 }
 
-// Resize recalculates the actual width and height of the view from the width and height
-// percentages
-// This is usually called when the window is resized, or when a split has been added and
-// the percentages have changed
+// Resize sets the actual width and height of the view
+// This is called by the split tree when the window is resized, or when a split has been
+// added and the percentages have changed
 func (v *View) Resize(w, h int) {
-	// Always include 1 line for the command line at the bottom
-	h--
-	v.width = int(float32(w) * float32(v.widthPercent) / 100)
+	v.width = w
 	// We subtract 1 for the statusline
-	v.height = int(float32(h)*float32(v.heightPercent)/100) - 1
+	v.height = h - 1
+	if v.term != nil {
+		v.term.Resize(v.width, v.height)
+	}
 }
 
 // ScrollUp scrolls the view up n lines (if possible)
 func (v *View) ScrollUp(n int) {
+	if settings.Softwrap {
+		v.scrollRows(-n)
+		return
+	}
 	// Try to scroll by n but if it would overflow, scroll by 1
 	if v.topline-n >= 0 {
 		v.topline -= n
@@ -138,16 +169,24 @@ func (v *View) ScrollUp(n int) {
 
 // ScrollDown scrolls the view down n lines (if possible)
 func (v *View) ScrollDown(n int) {
+	if settings.Softwrap {
+		v.scrollRows(n)
+		return
+	}
 	// Try to scroll by n but if it would overflow, scroll by 1
-	if v.topline+n <= len(v.buf.lines)-v.height {
+	if v.topline+n <= v.buf.NumLines()-v.height {
 		v.topline += n
-	} else if v.topline < len(v.buf.lines)-v.height {
+	} else if v.topline < v.buf.NumLines()-v.height {
 		v.topline++
 	}
 }
 
 // PageUp scrolls the view up a page
 func (v *View) PageUp() {
+	if settings.Softwrap {
+		v.scrollRows(-v.height)
+		return
+	}
 	if v.topline > v.height {
 		v.ScrollUp(v.height)
 	} else {
@@ -157,17 +196,25 @@ func (v *View) PageUp() {
 
 // PageDown scrolls the view down a page
 func (v *View) PageDown() {
-	if len(v.buf.lines)-(v.topline+v.height) > v.height {
+	if settings.Softwrap {
+		v.scrollRows(v.height)
+		return
+	}
+	if v.buf.NumLines()-(v.topline+v.height) > v.height {
 		v.ScrollDown(v.height)
 	} else {
-		if len(v.buf.lines) >= v.height {
-			v.topline = len(v.buf.lines) - v.height
+		if v.buf.NumLines() >= v.height {
+			v.topline = v.buf.NumLines() - v.height
 		}
 	}
 }
 
 // HalfPageUp scrolls the view up half a page
 func (v *View) HalfPageUp() {
+	if settings.Softwrap {
+		v.scrollRows(-v.height / 2)
+		return
+	}
 	if v.topline > v.height/2 {
 		v.ScrollUp(v.height / 2)
 	} else {
@@ -177,11 +224,15 @@ func (v *View) HalfPageUp() {
 
 // HalfPageDown scrolls the view down half a page
 func (v *View) HalfPageDown() {
-	if len(v.buf.lines)-(v.topline+v.height) > v.height/2 {
+	if settings.Softwrap {
+		v.scrollRows(v.height / 2)
+		return
+	}
+	if v.buf.NumLines()-(v.topline+v.height) > v.height/2 {
 		v.ScrollDown(v.height / 2)
 	} else {
-		if len(v.buf.lines) >= v.height {
-			v.topline = len(v.buf.lines) - v.height
+		if v.buf.NumLines() >= v.height {
+			v.topline = v.buf.NumLines() - v.height
 		}
 	}
 }
@@ -191,24 +242,14 @@ func (v *View) HalfPageDown() {
 // causing them to lose the unsaved changes
 // The message is what to print after saying "You have unsaved changes. "
 func (v *View) CanClose(msg string) bool {
-	if v.buf.IsDirty() {
-		quit, canceled := messenger.Prompt("You have unsaved changes. " + msg)
-		if !canceled {
-			if strings.ToLower(quit) == "yes" || strings.ToLower(quit) == "y" {
-				return true
-			}
-		}
-	} else {
-		return true
-	}
-	return false
+	return v.buf.CanClose(msg)
 }
 
 // Save the buffer to disk
 func (v *View) Save() {
 	// If this is an empty buffer, ask for a filename
 	if v.buf.path == "" {
-		filename, canceled := messenger.Prompt("Filename: ")
+		filename, canceled := messenger.Prompt("Filename: ", "File", FileCompletion)
 		if !canceled {
 			v.buf.path = filename
 			v.buf.name = filename
@@ -216,11 +257,27 @@ func (v *View) Save() {
 			return
 		}
 	}
+	// A file which doesn't format is still saved, as it is
+	var fmtErr error
+	if settings.FormatOnSave {
+		if _, fmtErr = FormatBuffer(v.buf); fmtErr == errNoFormatter {
+			fmtErr = nil
+		}
+	}
 	err := v.buf.Save()
 	if err != nil {
 		messenger.Error(err.Error())
+	} else if err = v.buf.SaveUndo(); err != nil {
+		messenger.Error("Saved " + v.buf.path + ", but the undo history could not be saved: " + err.Error())
 	} else {
-		messenger.Message("Saved " + v.buf.path)
+		if fmtErr != nil {
+			messenger.Error("Saved " + v.buf.path + ", but it could not be formatted: " + fmtErr.Error())
+		} else {
+			messenger.Message("Saved " + v.buf.path)
+		}
+		RunHook("onSave", v.buf.path)
+		RunLinter(v.buf)
+		UpdateGit(v.buf)
 	}
 }
 
@@ -250,6 +307,7 @@ func (v *View) Cut() {
 
 // Paste whatever is in the system clipboard into the buffer
 // Delete and paste if the user has a selection
+// A block which was copied from a block selection is pasted as a block
 func (v *View) Paste() {
 	if !clipboard.Unsupported {
 		if v.cursor.HasSelection() {
@@ -257,7 +315,12 @@ func (v *View) Paste() {
 			v.cursor.ResetSelection()
 		}
 		clip, _ := clipboard.ReadAll()
-		v.eh.Insert(v.cursor.Loc(), clip)
+		if clip != "" && clip == blockClipboard {
+			// A block which was copied is pasted as a block
+			v.PasteBlock(clip, v.cursor.GetVisualX(), v.cursor.y)
+			return
+		}
+		v.buf.eh.Insert(v.cursor.Loc(), clip)
 		v.cursor.SetLoc(v.cursor.Loc() + Count(clip))
 	} else {
 		messenger.Error("Clipboard is not supported on your system")
@@ -273,27 +336,123 @@ func (v *View) SelectAll() {
 	v.cursor.y = 0
 }
 
-// OpenFile opens a new file in the current view
-// It makes sure that the current buffer can be closed first (unsaved changes)
-func (v *View) OpenFile() {
-	if v.CanClose("Continue? ") {
-		filename, canceled := messenger.Prompt("File to open: ")
-		if canceled {
-			return
-		}
-		file, err := ioutil.ReadFile(filename)
+// VSplit opens the buffer in a new view to the right of this one
+func (v *View) VSplit(buf *Buffer) {
+	nv := NewView(buf)
+	v.splitNode.VSplit(nv)
+	CurTab().Update()
+	CurTab().SetCurView(nv)
+}
+
+// HSplit opens the buffer in a new view below this one
+func (v *View) HSplit(buf *Buffer) {
+	nv := NewView(buf)
+	v.splitNode.HSplit(nv)
+	CurTab().Update()
+	CurTab().SetCurView(nv)
+}
+
+// ResizeSplit grows the view by n percent of its split (or shrinks it if n is negative)
+func (v *View) ResizeSplit(n int) {
+	if !v.splitNode.ResizeBy(n) {
+		messenger.Error("Can't resize the split any further")
+		return
+	}
+	CurTab().Resize()
+}
+
+// Quit closes the view (and its tab if it was the only view in it),
+// or exits micro if it is the last view
+// If no other view shows the buffer, the buffer is closed too, so the user is asked
+// about unsaved changes
+func (v *View) Quit() {
+	t := CurTab()
+	if len(tabs) == 1 && len(t.views) == 1 {
+		QuitAll()
+		return
+	}
 
-		if err != nil {
-			messenger.Error(err.Error())
+	if NumViews(v.buf) == 1 {
+		if !v.CanClose("Quit anyway? ") {
 			return
 		}
-		v.buf = NewBuffer(string(file), filename)
+		CloseBuffer(v.buf)
+	}
+	if v.diff != nil {
+		v.diff.Close()
+	}
+	// The shell is only stopped once the quit can't be canceled
+	if v.term != nil {
+		v.term.Close()
+	}
+
+	if len(t.views) == 1 {
+		CloseTab(curTab)
+		return
+	}
+
+	idx := t.curView
+	v.splitNode.Delete()
+	t.Update()
+	// Give the focus to the view before the one that was closed
+	if idx > 0 {
+		idx--
+	}
+	t.SetCurView(t.views[idx])
+}
+
+// SetBuffer shows a different buffer in the view
+// The previous buffer stays open so the user can come back to it
+func (v *View) SetBuffer(buf *Buffer) {
+	AddBuffer(buf)
+	if v.diff != nil {
+		v.diff.Close()
 	}
+	// A terminal becomes a normal view of the buffer
+	if v.term != nil {
+		v.term.Close()
+		v.term = nil
+	}
+	v.buf = buf
+	v.topline, v.topRow = 0, 0
+	v.leftCol = 0
+	v.cursor.x, v.cursor.y = 0, 0
+	v.cursor.lastVisualX = 0
+	v.cursor.ResetSelection()
+	v.Relocate()
+}
+
+// NextBuffer shows the buffer n places after the current one in the list of open
+// buffers (or before it, if n is negative)
+func (v *View) NextBuffer(n int) {
+	i := BufferIndex(v.buf) + n
+	i = ((i % len(buffers)) + len(buffers)) % len(buffers)
+	v.SetBuffer(buffers[i])
+}
+
+// OpenFile opens a new file in the current view
+// The buffer that was shown before stays open in the list of buffers
+func (v *View) OpenFile() {
+	filename, canceled := messenger.Prompt("File to open: ", "File", FileCompletion)
+	if canceled {
+		return
+	}
+	buf, err := OpenBuffer(filename)
+	if err != nil {
+		messenger.Error(err.Error())
+		return
+	}
+	v.SetBuffer(buf)
 }
 
 // Relocate moves the view window so that the cursor is in view
 // This is useful if the user has scrolled far away, and then starts typing
+// With softwrap, the view scrolls by rows and never scrolls horizontally
 func (v *View) Relocate() bool {
+	if settings.Softwrap {
+		return v.relocateRows()
+	}
+
 	ret := false
 	cy := v.cursor.y
 	if cy < v.topline {
@@ -320,12 +479,17 @@ func (v *View) Relocate() bool {
 // MoveToMouseClick moves the cursor to location x, y assuming x, y were given
 // by a mouse click
 func (v *View) MoveToMouseClick(x, y int) {
+	if settings.Softwrap {
+		v.moveToWrappedClick(x, y-v.topline)
+		return
+	}
+
 	if y-v.topline > v.height-1 {
 		v.ScrollDown(1)
 		y = v.height + v.topline - 1
 	}
-	if y >= len(v.buf.lines) {
-		y = len(v.buf.lines) - 1
+	if y >= v.buf.NumLines() {
+		y = v.buf.NumLines() - 1
 	}
 	if y < 0 {
 		y = 0
@@ -335,8 +499,8 @@ func (v *View) MoveToMouseClick(x, y int) {
 	}
 
 	x = v.cursor.GetCharPosInLine(y, x)
-	if x > Count(v.buf.lines[y]) {
-		x = Count(v.buf.lines[y])
+	if x > Count(v.buf.Line(y)) {
+		x = Count(v.buf.Line(y))
 	}
 	v.cursor.x = x
 	v.cursor.y = y
@@ -345,6 +509,11 @@ func (v *View) MoveToMouseClick(x, y int) {
 
 // HandleEvent handles an event passed by the main loop
 func (v *View) HandleEvent(event tcell.Event) {
+	if v.term != nil {
+		v.HandleTermEvent(event)
+		return
+	}
+
 	// This bool determines whether the view is relocated at the end of the function
 	// By default it's true because most events should cause a relocate
 	relocate := true
@@ -352,178 +521,38 @@ func (v *View) HandleEvent(event tcell.Event) {
 	// By default we don't update and syntax highlighting
 	v.UpdateLines(-2, 0)
 	switch e := event.(type) {
-	case *tcell.EventResize:
-		// Window resized
-		v.Resize(e.Size())
 	case *tcell.EventKey:
-		switch e.Key() {
-		case tcell.KeyUp:
-			// Cursor up
-			v.cursor.ResetSelection()
-			v.cursor.Up()
-		case tcell.KeyDown:
-			// Cursor down
-			v.cursor.ResetSelection()
-			v.cursor.Down()
-		case tcell.KeyLeft:
-			// Cursor left
-			v.cursor.ResetSelection()
-			v.cursor.Left()
-		case tcell.KeyRight:
-			// Cursor right
-			v.cursor.ResetSelection()
-			v.cursor.Right()
-		case tcell.KeyEnter:
-			// Insert a newline
-			if v.cursor.HasSelection() {
-				v.cursor.DeleteSelection()
-				v.cursor.ResetSelection()
-			}
-			v.eh.Insert(v.cursor.Loc(), "\n")
-			v.cursor.Right()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-			v.cursor.lastVisualX = v.cursor.GetVisualX()
-			// v.UpdateLines(v.cursor.y-1, v.cursor.y)
-		case tcell.KeySpace:
-			// Insert a space
-			if v.cursor.HasSelection() {
-				v.cursor.DeleteSelection()
-				v.cursor.ResetSelection()
-			}
-			v.eh.Insert(v.cursor.Loc(), " ")
-			v.cursor.Right()
-			v.UpdateLines(v.cursor.y, v.cursor.y)
-		case tcell.KeyBackspace2, tcell.KeyBackspace:
-			// Delete a character
-			if v.cursor.HasSelection() {
-				v.cursor.DeleteSelection()
-				v.cursor.ResetSelection()
-				// Rehighlight the entire buffer
-				v.UpdateLines(v.topline, v.topline+v.height)
-			} else if v.cursor.Loc() > 0 {
-				// We have to do something a bit hacky here because we want to
-				// delete the line by first moving left and then deleting backwards
-				// but the undo redo would place the cursor in the wrong place
-				// So instead we move left, save the position, move back, delete
-				// and restore the position
-				v.cursor.Left()
-				cx, cy := v.cursor.x, v.cursor.y
-				v.cursor.Right()
-				loc := v.cursor.Loc()
-				v.eh.Remove(loc-1, loc)
-				v.cursor.x, v.cursor.y = cx, cy
-				// Rehighlight the entire buffer
-				v.UpdateLines(v.topline, v.topline+v.height)
-				// v.UpdateLines(v.cursor.y, v.cursor.y+1)
-			}
-			v.cursor.lastVisualX = v.cursor.GetVisualX()
-		case tcell.KeyTab:
-			// Insert a tab
-			if v.cursor.HasSelection() {
-				v.cursor.DeleteSelection()
-				v.cursor.ResetSelection()
+		action := BindingFor(e)
+		if v.blockMode && v.HandleBlockKey(e, action) {
+			// The key edited the block selection
+			break
+		}
+		if action != "" {
+			if multiCursorActions[action] {
+				v.RunAtCursors(func() { relocate = actions[action](v) })
+			} else {
+				relocate = actions[action](v)
 			}
-			if settings.TabsToSpaces {
-				v.eh.Insert(v.cursor.Loc(), Spaces(settings.TabSize))
-				for i := 0; i < settings.TabSize; i++ {
-					v.cursor.Right()
+		} else if e.Key() == tcell.KeyRune {
+			// Insert a character at every cursor
+			v.RunAtCursors(func() {
+				if v.cursor.HasSelection() {
+					v.cursor.DeleteSelection()
+					v.cursor.ResetSelection()
+					// Rehighlight the entire buffer
+					v.UpdateLines(v.topline, v.topline+v.height)
+				} else {
+					v.UpdateLines(v.cursor.y, v.cursor.y)
 				}
-			} else {
-				v.eh.Insert(v.cursor.Loc(), "\t")
+				v.buf.eh.Insert(v.cursor.Loc(), string(e.Rune()))
 				v.cursor.Right()
-			}
-			v.UpdateLines(v.cursor.y, v.cursor.y)
-		case tcell.KeyCtrlS:
-			v.Save()
-		case tcell.KeyCtrlF:
-			if v.cursor.HasSelection() {
-				searchStart = v.cursor.curSelection[1]
-			} else {
-				searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
-			}
-			BeginSearch()
-		case tcell.KeyCtrlN:
-			if v.cursor.HasSelection() {
-				searchStart = v.cursor.curSelection[1]
-			} else {
-				searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
-			}
-			messenger.Message("Find: " + lastSearch)
-			Search(lastSearch, v, true)
-		case tcell.KeyCtrlP:
-			if v.cursor.HasSelection() {
-				searchStart = v.cursor.curSelection[0]
-			} else {
-				searchStart = ToCharPos(v.cursor.x, v.cursor.y, v.buf)
-			}
-			messenger.Message("Find: " + lastSearch)
-			Search(lastSearch, v, false)
-		case tcell.KeyCtrlZ:
-			v.eh.Undo()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyCtrlY:
-			v.eh.Redo()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyCtrlC:
-			v.Copy()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyCtrlX:
-			v.Cut()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyCtrlV:
-			v.Paste()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyCtrlA:
-			v.SelectAll()
-		case tcell.KeyCtrlO:
-			v.OpenFile()
-			// Rehighlight the entire buffer
-			v.UpdateLines(v.topline, v.topline+v.height)
-		case tcell.KeyHome:
-			v.topline = 0
-			relocate = false
-		case tcell.KeyEnd:
-			if v.height > len(v.buf.lines) {
-				v.topline = 0
-			} else {
-				v.topline = len(v.buf.lines) - v.height
-			}
-			relocate = false
-		case tcell.KeyPgUp:
-			v.PageUp()
-			relocate = false
-		case tcell.KeyPgDn:
-			v.PageDown()
-			relocate = false
-		case tcell.KeyCtrlU:
-			v.HalfPageUp()
-			relocate = false
-		case tcell.KeyCtrlD:
-			v.HalfPageDown()
-			relocate = false
-		case tcell.KeyRune:
-			// Insert a character
-			if v.cursor.HasSelection() {
-				v.cursor.DeleteSelection()
-				v.cursor.ResetSelection()
-				// Rehighlight the entire buffer
-				v.UpdateLines(v.topline, v.topline+v.height)
-			} else {
-				v.UpdateLines(v.cursor.y, v.cursor.y)
-			}
-			v.eh.Insert(v.cursor.Loc(), string(e.Rune()))
-			v.cursor.Right()
+			})
 		}
 	case *tcell.EventMouse:
 		x, y := e.Position()
-		x -= v.lineNumOffset - v.leftCol
-		y += v.topline
+		// Translate the screen location into a location in the buffer
+		x -= v.x + v.lineNumOffset - v.leftCol
+		y += v.topline - v.y
 		// Position always seems to be off by one
 		x--
 		y--
@@ -533,6 +562,27 @@ func (v *View) HandleEvent(event tcell.Event) {
 		switch button {
 		case tcell.Button1:
 			// Left click
+			// Alt-click adds a cursor and Alt-drag selects a block, but which one it is
+			// is only known once the mouse moves or is released
+			if v.mouseReleased && e.Modifiers()&tcell.ModAlt != 0 {
+				v.altPressed = true
+				v.altClick = [2]int{x, y}
+				v.blockMode = false
+				v.mouseReleased = false
+				relocate = false
+				break
+			}
+			if v.altPressed {
+				if x != v.altClick[0] || y != v.altClick[1] {
+					v.dragBlock(x, y)
+				}
+				break
+			}
+			// A normal click removes the extra cursors and the block selection
+			if v.mouseReleased {
+				v.cursors = nil
+				v.blockMode = false
+			}
 			origX, origY := v.cursor.x, v.cursor.y
 			v.MoveToMouseClick(x, y)
 
@@ -586,7 +636,9 @@ func (v *View) HandleEvent(event tcell.Event) {
 				// events, this still allows the user to make selections, except only after they
 				// release the mouse
 
-				if !v.doubleClick && !v.tripleClick {
+				if v.altPressed {
+					v.releaseAlt(x, y)
+				} else if !v.doubleClick && !v.tripleClick {
 					v.MoveToMouseClick(x, y)
 					v.cursor.curSelection[1] = v.cursor.Loc()
 				}
@@ -615,143 +667,283 @@ func (v *View) HandleEvent(event tcell.Event) {
 	if relocate {
 		v.Relocate()
 	}
-	if settings.Syntax {
-		v.matches = Match(v)
-	}
 }
 
 // DisplayView renders the view to the screen
 func (v *View) DisplayView() {
-	// matches := make(SyntaxMatches, len(v.buf.lines))
+	// matches := make(SyntaxMatches, v.buf.NumLines())
 	//
 	// viewStart := v.topline
 	// viewEnd := v.topline + v.height
-	// if viewEnd > len(v.buf.lines) {
-	// 	viewEnd = len(v.buf.lines)
+	// if viewEnd > v.buf.NumLines() {
+	// 	viewEnd = v.buf.NumLines()
 	// }
 	//
-	// lines := v.buf.lines[viewStart:viewEnd]
+	// lines := v.buf.Lines(viewStart, viewEnd)
 	// for i, line := range lines {
 	// 	matches[i] = make([]tcell.Style, len(line))
 	// }
 
-	// The character number of the character in the top left of the screen
-
-	charNum := ToCharPos(0, v.topline, v.buf)
-
 	// Convert the length of buffer to a string, and get the length of the string
 	// We are going to have to offset by that amount
-	maxLineLength := len(strconv.Itoa(len(v.buf.lines)))
+	maxLineLength := len(strconv.Itoa(v.buf.NumLines()))
 	// + 1 for the little space after the line number
 	v.lineNumOffset = maxLineLength + 1
+	// The gutter is only shown when some lines are flagged
+	gutter := v.buf.GutterMessages()
+	if len(gutter) > 0 {
+		v.lineNumOffset += 2
+	}
+	// So are the changes from git's HEAD, or from the other side of a diff,
+	// where the part of a modified line which changed is highlighted too
+	var changes map[int]int
+	var changedText map[int][2]int
+	if v.diff != nil {
+		changes, changedText = v.diff.Changes(v)
+	} else {
+		changes = GitChanges(v.buf.GitHunks(false))
+	}
+	if len(changes) > 0 {
+		v.lineNumOffset++
+	}
+
+	selectStyle := defStyle.Reverse(true)
+	if style, ok := colorscheme["selection"]; ok {
+		selectStyle = style
+	}
+	// The terminal only shows one cursor, so the extra cursors are drawn as reversed cells
+	extraCursors := make(map[[2]int]bool)
+	for _, c := range v.cursors {
+		extraCursors[[2]int{c.x, c.y}] = true
+	}
+	// An empty block selection is a column where typing inserts on every line,
+	// so it is drawn like extra cursors
+	if v.blockMode {
+		x1, y1, x2, y2 := v.BlockRect()
+		for y := y1; x1 == x2 && y <= y2; y++ {
+			if y != v.cursor.y && y < v.buf.NumLines() && v.visualLength(y) >= x1 {
+				extraCursors[[2]int{v.cursor.GetCharPosInLine(y, x1), y}] = true
+			}
+		}
+	}
+
+	// The matches of the last search are highlighted
+	searchMatches := HighlightedMatches(v)
+	searchStyle := defStyle.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow)
+	if style, ok := colorscheme["search-match"]; ok {
+		searchStyle = style
+	}
 
-	var highlightStyle tcell.Style
+	lineNumStyle := defStyle
+	if style, ok := colorscheme["line-number"]; ok {
+		lineNumStyle = style
+	}
+
+	// The line and the row of that line which are drawn on each row of the view
+	// Without softwrap the row is always 0
+	y, row := v.topline, v.topRow
+	textWidth := v.textWidth()
 
 	for lineN := 0; lineN < v.height; lineN++ {
 		var x int
 		// If the buffer is smaller than the view height
 		// and we went too far, break
-		if lineN+v.topline >= len(v.buf.lines) {
+		if y >= v.buf.NumLines() {
 			break
 		}
-		line := v.buf.lines[lineN+v.topline]
+		line := v.buf.Line(y)
+
+		// Write the marker of a flagged line in the gutter
+		if len(gutter) > 0 {
+			if m, ok := gutter[y]; ok && row == 0 {
+				v.drawCell(x, lineN, '>', GutterStyle(m.kind))
+				v.drawCell(x+1, lineN, '>', GutterStyle(m.kind))
+			} else {
+				v.drawCell(x, lineN, ' ', defStyle)
+				v.drawCell(x+1, lineN, ' ', defStyle)
+			}
+			x += 2
+		}
+		// And the marker of a changed line
+		if len(changes) > 0 {
+			if kind, ok := changes[y]; ok && row == 0 {
+				marker, style := GitMarker(kind)
+				v.drawCell(x, lineN, marker, style)
+			} else {
+				v.drawCell(x, lineN, ' ', defStyle)
+			}
+			x++
+		}
 
-		// Write the line number
-		lineNumStyle := defStyle
-		if style, ok := colorscheme["line-number"]; ok {
-			lineNumStyle = style
+		// Write the line number, or just spaces for the rest of a wrapped line
+		lineNum := strconv.Itoa(y + 1)
+		if row > 0 {
+			lineNum = ""
 		}
 		// Write the spaces before the line number if necessary
-		lineNum := strconv.Itoa(lineN + v.topline + 1)
 		for i := 0; i < maxLineLength-len(lineNum); i++ {
-			screen.SetContent(x, lineN, ' ', nil, lineNumStyle)
+			v.drawCell(x, lineN, ' ', lineNumStyle)
 			x++
 		}
 		// Write the actual line number
 		for _, ch := range lineNum {
-			screen.SetContent(x, lineN, ch, nil, lineNumStyle)
+			v.drawCell(x, lineN, ch, lineNumStyle)
 			x++
 		}
 		// Write the extra space
-		screen.SetContent(x, lineN, ' ', nil, lineNumStyle)
+		v.drawCell(x, lineN, ' ', lineNumStyle)
 		x++
 
+		// The visual columns of the line which are shown on this row
+		startCol := v.leftCol
+		if settings.Softwrap {
+			startCol = row * textWidth
+		}
+		endCol := startCol + textWidth
+
 		// Write the line
-		tabchars := 0
+		// visualX is the column of the character in the line, with the tabs expanded
+		visualX := 0
+		lineStart := ToCharPos(0, y, v.buf)
+		charNum := lineStart
 		runes := []rune(line)
-		for colN := v.leftCol; colN < v.leftCol+v.width; colN++ {
-			if colN >= len(runes) {
+		for colN, ch := range runes {
+			if visualX >= endCol {
 				break
 			}
-			ch := runes[colN]
-			var lineStyle tcell.Style
+			lineStyle := defStyle
 			// Does the current character need to be syntax highlighted?
-
-			// if lineN >= v.updateLines[0] && lineN < v.updateLines[1] {
 			if settings.Syntax {
-				highlightStyle = v.matches[lineN][colN]
+				lineStyle = v.matches[y-v.topline][colN]
 			}
-			// } else if lineN < len(v.lastMatches) && colN < len(v.lastMatches[lineN]) {
-			// highlightStyle = v.lastMatches[lineN][colN]
-			// } else {
-			// highlightStyle = defStyle
-			// }
-
-			if v.cursor.HasSelection() &&
-				(charNum >= v.cursor.curSelection[0] && charNum < v.cursor.curSelection[1] ||
-					charNum < v.cursor.curSelection[0] && charNum >= v.cursor.curSelection[1]) {
-
-				lineStyle = defStyle.Reverse(true)
-
-				if style, ok := colorscheme["selection"]; ok {
-					lineStyle = style
+			if v.diff != nil {
+				text := changedText[y]
+				if bg, ok := diffBackground(changes[y], colN >= text[0] && colN < text[1]); ok {
+					lineStyle = lineStyle.Background(bg)
 				}
-			} else {
-				lineStyle = highlightStyle
 			}
-			// matches[lineN][colN] = highlightStyle
+			if searchMatches != nil && InMatch(searchMatches, charNum) {
+				lineStyle = searchStyle
+			}
+			if v.IsSelected(charNum) || v.InBlock(visualX, y) {
+				lineStyle = selectStyle
+			}
+			if extraCursors[[2]int{colN, y}] {
+				lineStyle = defStyle.Reverse(true)
+			}
 
+			width := 1
 			if ch == '\t' {
-				screen.SetContent(x+tabchars, lineN, ' ', nil, lineStyle)
-				tabSize := settings.TabSize
-				for i := 0; i < tabSize-1; i++ {
-					tabchars++
-					if x-v.leftCol+tabchars >= v.lineNumOffset {
-						screen.SetContent(x-v.leftCol+tabchars, lineN, ' ', nil, lineStyle)
-					}
-				}
-			} else {
-				if x-v.leftCol+tabchars >= v.lineNumOffset {
-					screen.SetContent(x-v.leftCol+tabchars, lineN, ch, nil, lineStyle)
+				ch = ' '
+				width = settings.TabSize
+			}
+			for i := 0; i < width; i++ {
+				if visualX >= startCol && visualX < endCol {
+					v.drawCell(x+visualX-startCol, lineN, ch, lineStyle)
 				}
+				visualX++
 			}
 			charNum++
-			x++
 		}
-		// Here we are at a newline
+		// The lines which differ from the other side of a diff are highlighted
+		// up to the edge of the view
+		if bg, ok := diffBackground(changes[y], false); ok && v.diff != nil {
+			for col := Max(visualX, startCol); col < endCol; col++ {
+				v.drawCell(x+col-startCol, lineN, ' ', defStyle.Background(bg))
+			}
+		}
+		// Here we are at a newline, if the whole line has been drawn
 
 		// The newline may be selected, in which case we should draw the selection style
 		// with a space to represent it
-		if v.cursor.HasSelection() &&
-			(charNum >= v.cursor.curSelection[0] && charNum < v.cursor.curSelection[1] ||
-				charNum < v.cursor.curSelection[0] && charNum >= v.cursor.curSelection[1]) {
-
-			selectStyle := defStyle.Reverse(true)
-
-			if style, ok := colorscheme["selection"]; ok {
-				selectStyle = style
+		if charNum == lineStart+len(runes) && visualX >= startCol && visualX < endCol {
+			if extraCursors[[2]int{len(runes), y}] {
+				v.drawCell(x+visualX-startCol, lineN, ' ', defStyle.Reverse(true))
+			} else if v.IsSelected(charNum) {
+				v.drawCell(x+visualX-startCol, lineN, ' ', selectStyle)
 			}
-			screen.SetContent(x-v.leftCol+tabchars, lineN, ' ', nil, selectStyle)
 		}
 
-		charNum++
+		if row+1 < v.lineRows(y) {
+			row++
+		} else {
+			y, row = y+1, 0
+		}
 	}
 	// v.lastMatches = matches
 }
 
+// drawCell draws a character at the location x, y relative to the top left corner of the view
+// Anything outside of the view is clipped
+func (v *View) drawCell(x, y int, ch rune, style tcell.Style) {
+	if x >= 0 && x < v.width && y >= 0 && y <= v.height {
+		screen.SetContent(v.x+x, v.y+y, ch, nil, style)
+	}
+}
+
+// DisplayDivider draws the divider which separates the view from the one to the left of it
+func (v *View) DisplayDivider() {
+	if v.x == 0 {
+		return
+	}
+	dividerStyle := defStyle.Reverse(true)
+	if style, ok := colorscheme["divider"]; ok {
+		dividerStyle = style
+	}
+	for y := 0; y <= v.height; y++ {
+		screen.SetContent(v.x-1, v.y+y, '|', nil, dividerStyle)
+	}
+}
+
+// Clamp makes sure the cursor and the scroll position are still inside the buffer
+// This is necessary because the buffer may have been modified from another view
+func (v *View) Clamp() {
+	if v.cursor.y >= v.buf.NumLines() {
+		v.cursor.y = v.buf.NumLines() - 1
+	}
+	if v.cursor.x > Count(v.buf.Line(v.cursor.y)) {
+		v.cursor.x = Count(v.buf.Line(v.cursor.y))
+	}
+	if v.topline >= v.buf.NumLines() {
+		v.topline = v.buf.NumLines() - 1
+	}
+	for i := range v.cursors {
+		c := &v.cursors[i]
+		if c.y >= v.buf.NumLines() {
+			c.y = v.buf.NumLines() - 1
+		}
+		if c.x > Count(v.buf.Line(c.y)) {
+			c.x = Count(v.buf.Line(c.y))
+		}
+	}
+	if rows := v.lineRows(v.topline); v.topRow >= rows {
+		v.topRow = rows - 1
+	}
+}
+
 // Display renders the view, the cursor, and statusline
+// The cursor is only shown in the view which has the focus
 func (v *View) Display() {
+	if v.term != nil {
+		v.term.Display(v)
+		v.DisplayDivider()
+		v.sline.Display()
+		return
+	}
+	v.Clamp()
+	if v.diff != nil {
+		v.diff.Scroll()
+	}
+	if settings.Syntax {
+		v.matches = Match(v)
+	}
 	v.DisplayView()
-	v.cursor.Display()
+	v.DisplayDivider()
+	if v == CurView() {
+		v.cursor.Display()
+		if !messenger.hasPrompt {
+			v.DisplayGutterMessage()
+		}
+	}
 	v.sline.Display()
 }